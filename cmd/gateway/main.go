@@ -19,6 +19,7 @@ import (
 var (
 	configFile = flag.String("config", "configs/config.yaml", "配置文件路径")
 	version    = flag.Bool("version", false, "显示版本信息")
+	watchEvery = flag.Duration("watch-interval", 5*time.Second, "配置文件变化检查间隔，0表示不监听")
 )
 
 const (
@@ -62,10 +63,6 @@ func main() {
 		metricsServer = startMetricsServer(cfg.Metrics.Port, cfg.Metrics.Path)
 	}
 
-	// 创建上下文用于优雅关闭
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// 启动网关（在goroutine中）
 	serverErr := make(chan error, 1)
 	go func() {
@@ -74,16 +71,37 @@ func main() {
 		}
 	}()
 
-	// 等待中断信号
+	// 监听配置文件变化
+	watchCtx, watchCancel := context.WithCancel(context.Background())
+	defer watchCancel()
+	if *watchEvery > 0 {
+		watcher := config.NewWatcher(*configFile, *watchEvery)
+		go watcher.Start(watchCtx, func() {
+			logger.Infof("检测到配置文件变化，重新加载: %s", *configFile)
+			gw.ReloadFromFile(*configFile)
+		})
+	}
+
+	// 等待中断信号，SIGHUP触发配置重载
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// 等待退出信号或启动错误
-	select {
-	case err := <-serverErr:
-		logger.Errorf("服务器错误: %v", err)
-	case sig := <-sigChan:
-		logger.Infof("接收到信号: %s，开始优雅关闭", sig)
+wait:
+	for {
+		select {
+		case err := <-serverErr:
+			logger.Errorf("服务器错误: %v", err)
+			break wait
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				logger.Infof("接收到信号: %s，重新加载配置", sig)
+				gw.ReloadFromFile(*configFile)
+				continue
+			}
+			logger.Infof("接收到信号: %s，开始优雅关闭", sig)
+			break wait
+		}
 	}
 
 	// 优雅关闭
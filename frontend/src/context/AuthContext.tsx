@@ -99,7 +99,7 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     setRefreshing(true);
     try {
       const res = await api.post('/auth/refresh', { refresh_token: refreshToken });
-      persist(res.data.access_token, refreshToken); // backend不返回新的refresh
+      persist(res.data.access_token, res.data.refresh_token); // 刷新令牌每次轮换
       notify.info('令牌已续期');
     } catch (e) {
       notify.error('令牌刷新失败, 请重新登录');
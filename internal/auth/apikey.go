@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/cache"
+)
+
+const (
+	// apiKeyPrefix API密钥明文前缀，格式为 gw_{id}_{secret}
+	apiKeyPrefix = "gw_"
+	// apiKeyCachePrefix API密钥在缓存中的键前缀
+	apiKeyCachePrefix = "auth:api_key:"
+	// apiKeyIndexKey 缓存中API密钥标识列表的键
+	apiKeyIndexKey = "auth:api_keys"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("无效的API密钥")
+	ErrExpiredAPIKey  = errors.New("API密钥已过期")
+	ErrRevokedAPIKey  = errors.New("API密钥已被吊销")
+	ErrAPIKeyNotFound = errors.New("API密钥不存在")
+)
+
+// APIKey API密钥元数据，只保存密钥的哈希
+type APIKey struct {
+	ID        string     `json:"id"`
+	Hash      string     `json:"hash,omitempty"`
+	Owner     string     `json:"owner"`
+	Roles     []string   `json:"roles"`
+	Routes    []string   `json:"routes,omitempty"` // 允许访问的路由路径，为空时不限制
+	Tier      string     `json:"tier,omitempty"`   // 速率限制等级
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// AllowsPath 检查密钥是否允许访问指定请求路径
+func (k *APIKey) AllowsPath(path string) bool {
+	if len(k.Routes) == 0 {
+		return true
+	}
+	for _, route := range k.Routes {
+		route = strings.TrimSuffix(route, "/")
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore API密钥存储
+type APIKeyStore interface {
+	Get(ctx context.Context, id string) (*APIKey, error)
+	Save(ctx context.Context, key *APIKey) error
+	List(ctx context.Context) ([]*APIKey, error)
+}
+
+// APIKeyService API密钥的创建、验证、轮换和吊销
+type APIKeyService struct {
+	store APIKeyStore
+}
+
+// NewAPIKeyService 创建API密钥服务
+func NewAPIKeyService(store APIKeyStore) *APIKeyService {
+	return &APIKeyService{store: store}
+}
+
+// Create 创建API密钥，返回的明文只在创建时可见
+func (s *APIKeyService) Create(ctx context.Context, key APIKey) (string, *APIKey, error) {
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key.ID = id
+	key.CreatedAt = time.Now()
+	key.RotatedAt = nil
+	key.RevokedAt = nil
+	return s.issue(ctx, &key)
+}
+
+// Rotate 为API密钥生成新的明文，旧的明文立即失效
+func (s *APIKeyService) Rotate(ctx context.Context, id string) (string, *APIKey, error) {
+	key, err := s.store.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if key.RevokedAt != nil {
+		return "", nil, ErrRevokedAPIKey
+	}
+
+	now := time.Now()
+	key.RotatedAt = &now
+	return s.issue(ctx, key)
+}
+
+// Revoke 吊销API密钥
+func (s *APIKeyService) Revoke(ctx context.Context, id string) error {
+	key, err := s.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	return s.store.Save(ctx, key)
+}
+
+// List 列出所有API密钥
+func (s *APIKeyService) List(ctx context.Context) ([]*APIKey, error) {
+	return s.store.List(ctx)
+}
+
+// Authenticate 验证API密钥明文
+func (s *APIKeyService) Authenticate(ctx context.Context, plaintext string) (*APIKey, error) {
+	id, ok := parseAPIKeyID(plaintext)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.store.Get(ctx, id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrRevokedAPIKey
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrExpiredAPIKey
+	}
+	return key, nil
+}
+
+// issue 生成新的明文并保存哈希
+func (s *APIKeyService) issue(ctx context.Context, key *APIKey) (string, *APIKey, error) {
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := apiKeyPrefix + key.ID + "_" + secret
+	key.Hash = hashAPIKey(plaintext)
+	if err := s.store.Save(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+// parseAPIKeyID 从明文中解析密钥标识
+func parseAPIKeyID(plaintext string) (string, bool) {
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(plaintext, apiKeyPrefix), "_")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// hashAPIKey 计算密钥哈希，密钥为高熵随机值，无需使用慢哈希
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString 生成随机字符串
+func randomString(size int, encode func([]byte) string) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %w", err)
+	}
+	return encode(b), nil
+}
+
+// CacheAPIKeyStore 将API密钥保存在缓存后端中
+type CacheAPIKeyStore struct {
+	cache cache.Cache
+	mutex sync.Mutex
+}
+
+// NewCacheAPIKeyStore 创建基于缓存的API密钥存储
+func NewCacheAPIKeyStore(c cache.Cache) *CacheAPIKeyStore {
+	return &CacheAPIKeyStore{cache: c}
+}
+
+// Get 获取API密钥
+func (s *CacheAPIKeyStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	value, err := s.cache.Get(ctx, apiKeyCachePrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(value), &key); err != nil {
+		return nil, fmt.Errorf("解析API密钥失败: %w", err)
+	}
+	return &key, nil
+}
+
+// Save 保存API密钥并更新标识列表
+func (s *CacheAPIKeyStore) Save(ctx context.Context, key *APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.cache.Set(ctx, apiKeyCachePrefix+key.ID, key, 0); err != nil {
+		return err
+	}
+
+	ids, err := s.ids(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == key.ID {
+			return nil
+		}
+	}
+	return s.cache.Set(ctx, apiKeyIndexKey, append(ids, key.ID), 0)
+}
+
+// List 列出所有API密钥
+func (s *CacheAPIKeyStore) List(ctx context.Context) ([]*APIKey, error) {
+	ids, err := s.ids(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.Get(ctx, id)
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ids 读取API密钥标识列表
+func (s *CacheAPIKeyStore) ids(ctx context.Context) ([]string, error) {
+	value, err := s.cache.Get(ctx, apiKeyIndexKey)
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	var ids []string
+	if err := json.Unmarshal([]byte(value), &ids); err != nil {
+		return nil, fmt.Errorf("解析API密钥列表失败: %w", err)
+	}
+	return ids, nil
+}
+
+// FileAPIKeyStore 将API密钥保存在JSON文件中
+type FileAPIKeyStore struct {
+	path  string
+	keys  map[string]*APIKey
+	mutex sync.RWMutex
+}
+
+// NewFileAPIKeyStore 创建基于文件的API密钥存储，文件不存在时在首次保存时创建
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	s := &FileAPIKeyStore{path: path, keys: make(map[string]*APIKey)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取API密钥文件失败: %w", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("解析API密钥文件失败: %w", err)
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	return s, nil
+}
+
+// Get 获取API密钥
+func (s *FileAPIKeyStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// Save 保存API密钥并写回文件
+func (s *FileAPIKeyStore) Save(ctx context.Context, key *APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.keys[key.ID]
+	copied := *key
+	s.keys[key.ID] = &copied
+	if err := s.write(); err != nil {
+		if existed {
+			s.keys[key.ID] = previous
+		} else {
+			delete(s.keys, key.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// List 列出所有API密钥
+func (s *FileAPIKeyStore) List(ctx context.Context) ([]*APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sorted(), nil
+}
+
+// sorted 按创建时间排序的密钥副本，调用方需持有mutex
+func (s *FileAPIKeyStore) sorted() []*APIKey {
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// write 先写临时文件再重命名，调用方需持有mutex
+func (s *FileAPIKeyStore) write() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".api-keys-*.json")
+	if err != nil {
+		return fmt.Errorf("写入API密钥文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入API密钥文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入API密钥文件失败: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -1,8 +1,12 @@
 package auth
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,6 +17,21 @@ var (
 	ErrInvalidToken = errors.New("无效的token")
 	ErrExpiredToken = errors.New("token已过期")
 	ErrTokenNotFound = errors.New("token不存在")
+	ErrRevokedToken = errors.New("token已被吊销")
+	ErrRefreshTokenReused = errors.New("刷新token已被使用")
+	ErrInactiveUser = errors.New("用户已被禁用")
+)
+
+// 令牌中的时间精确到毫秒，与用户吊销令牌的时间比较，吊销前后同一秒内签发的令牌才能区分
+func init() {
+	jwt.TimePrecision = time.Millisecond
+}
+
+const (
+	// TokenTypeAccess 访问令牌类型
+	TokenTypeAccess = "access"
+	// TokenTypeRefresh 刷新令牌类型
+	TokenTypeRefresh = "refresh"
 )
 
 // Claims JWT声明结构
@@ -21,25 +40,80 @@ type Claims struct {
 	Username string   `json:"username"`
 	Email    string   `json:"email"`
 	Roles    []string `json:"roles"`
+	Scope    string   `json:"scope,omitempty"` // 以空格分隔的权限范围
+	Type     string   `json:"typ"`
+	// External 令牌由外部身份提供方签发，用户不在本地用户服务中
+	External bool `json:"-"`
+	// Raw 令牌中的全部声明，用于读取网关未映射的自定义声明
+	Raw jwt.MapClaims `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// UnmarshalJSON 解析声明，同时保留全部声明到Raw
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Raw)
+}
+
+// RefreshClaims 刷新令牌声明，同一次登录轮换出的刷新令牌属于同一家族
+type RefreshClaims struct {
+	Type   string `json:"typ"`
+	Family string `json:"fid"`
 	jwt.RegisteredClaims
 }
 
+// TokenValidator 令牌验证器
+type TokenValidator interface {
+	ValidateToken(tokenString string) (*Claims, error)
+}
+
 // TokenService JWT token服务
 type TokenService struct {
-	secret        []byte
+	keyring       atomic.Pointer[Keyring]
 	tokenExpiry   time.Duration
 	refreshExpiry time.Duration
 	issuer        string
+	revocations   *RevocationStore
 }
 
 // NewTokenService 创建token服务实例
-func NewTokenService(cfg config.AuthConfig) *TokenService {
-	return &TokenService{
-		secret:        []byte(cfg.JWTSecret),
+func NewTokenService(cfg config.AuthConfig) (*TokenService, error) {
+	keyring, err := NewKeyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &TokenService{
 		tokenExpiry:   cfg.TokenExpiry,
 		refreshExpiry: cfg.RefreshExpiry,
 		issuer:        cfg.Issuer,
 	}
+	ts.keyring.Store(keyring)
+	return ts, nil
+}
+
+// SetKeyring 替换密钥环，用于不停机轮换签名密钥
+func (ts *TokenService) SetKeyring(keyring *Keyring) {
+	ts.keyring.Store(keyring)
+}
+
+// JWKS 返回用于验证令牌的公钥集合
+func (ts *TokenService) JWKS() JSONWebKeySet {
+	return ts.keyring.Load().JWKS()
+}
+
+// parse 解析并验证令牌签名，只接受密钥环配置的算法
+func (ts *TokenService) parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	keyring := ts.keyring.Load()
+	return jwt.ParseWithClaims(tokenString, claims, keyring.Keyfunc, jwt.WithValidMethods(keyring.Algorithms()))
+}
+
+// SetRevocationStore 设置令牌吊销列表，未设置时不检查吊销状态
+func (ts *TokenService) SetRevocationStore(store *RevocationStore) {
+	ts.revocations = store
 }
 
 // GenerateToken 生成访问token
@@ -49,41 +123,54 @@ func (ts *TokenService) GenerateToken(userID, username, email string, roles []st
 		Username: username,
 		Email:    email,
 		Roles:    roles,
+		Type:     TokenTypeAccess,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ts.tokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    ts.issuer,
 			Subject:   userID,
+			ID:        generateJTI(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(ts.secret)
+	return ts.keyring.Load().Sign(claims)
 }
 
-// GenerateRefreshToken 生成刷新token
+// GenerateRefreshToken 生成刷新token，每次登录生成的刷新令牌开始一个新的令牌家族
 func (ts *TokenService) GenerateRefreshToken(userID string) (string, error) {
-	claims := jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ts.refreshExpiry)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		NotBefore: jwt.NewNumericDate(time.Now()),
-		Issuer:    ts.issuer,
-		Subject:   userID,
+	return ts.generateRefreshToken(userID, generateJTI())
+}
+
+// generateRefreshToken 生成属于指定家族的刷新token，并延长家族的有效期
+func (ts *TokenService) generateRefreshToken(userID, family string) (string, error) {
+	claims := RefreshClaims{
+		Type:   TokenTypeRefresh,
+		Family: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ts.refreshExpiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    ts.issuer,
+			Subject:   userID,
+			ID:        generateJTI(),
+		},
+	}
+
+	if ts.revocations != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), revocationTimeout)
+		defer cancel()
+		if err := ts.revocations.StartFamily(ctx, family, ts.refreshExpiry); err != nil {
+			return "", fmt.Errorf("登记刷新令牌失败: %w", err)
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(ts.secret)
+	return ts.keyring.Load().Sign(claims)
 }
 
 // ValidateToken 验证token
 func (ts *TokenService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("意外的签名方法: %v", token.Header["alg"])
-		}
-		return ts.secret, nil
-	})
+	token, err := ts.parse(tokenString, &Claims{})
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -93,36 +180,140 @@ func (ts *TokenService) ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		// 刷新令牌不能作为访问令牌使用
+		if claims.Type != TokenTypeAccess {
+			return nil, ErrInvalidToken
+		}
+		if err := ts.checkRevoked(&claims.RegisteredClaims); err != nil {
+			return nil, err
+		}
 		return claims, nil
 	}
 
 	return nil, ErrInvalidToken
 }
 
-// RefreshToken 刷新token
-func (ts *TokenService) RefreshToken(refreshTokenString string) (string, error) {
-	token, err := jwt.ParseWithClaims(refreshTokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("意外的签名方法: %v", token.Header["alg"])
+// RefreshToken 使用刷新令牌换取新的访问令牌和刷新令牌，旧的刷新令牌随即失效。
+// 用户信息通过users重新获取；已轮换的刷新令牌再次使用时吊销整个令牌家族。
+func (ts *TokenService) RefreshToken(refreshTokenString string, users UserService) (string, string, error) {
+	claims := &RefreshClaims{}
+	token, err := ts.parse(refreshTokenString, claims)
+
+	if err != nil || !token.Valid {
+		return "", "", ErrInvalidToken
+	}
+	// 访问令牌不能作为刷新令牌使用
+	if claims.Type != TokenTypeRefresh || claims.Family == "" || claims.ExpiresAt == nil {
+		return "", "", ErrInvalidToken
+	}
+	if err := ts.checkRevoked(&claims.RegisteredClaims); err != nil {
+		return "", "", err
+	}
+
+	// 在使用刷新令牌前获取用户信息，用户服务暂时不可用时令牌仍可再次使用
+	user, err := users.GetUser(claims.Subject)
+	if err != nil {
+		return "", "", fmt.Errorf("获取用户信息失败: %w", err)
+	}
+	if !user.Active {
+		return "", "", ErrInactiveUser
+	}
+
+	if ts.revocations != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), revocationTimeout)
+		defer cancel()
+		if err := ts.revocations.UseRefreshToken(ctx, claims.Family, claims.ID, claims.ExpiresAt.Time); err != nil {
+			if errors.Is(err, ErrRevokedToken) || errors.Is(err, ErrRefreshTokenReused) {
+				return "", "", err
+			}
+			return "", "", fmt.Errorf("更新刷新令牌状态失败: %w", err)
 		}
-		return ts.secret, nil
-	})
+	}
 
+	accessToken, err := ts.GenerateToken(user.ID, user.Username, user.Email, user.Roles)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := ts.generateRefreshToken(user.ID, claims.Family)
 	if err != nil {
-		return "", ErrInvalidToken
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// RevokeToken 吊销访问令牌或刷新令牌直到其过期，吊销刷新令牌时同时吊销其所属家族
+func (ts *TokenService) RevokeToken(ctx context.Context, tokenString string) error {
+	if ts.revocations == nil {
+		return errors.New("未启用令牌吊销")
+	}
+
+	claims := &RefreshClaims{}
+	_, err := ts.parse(tokenString, claims)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			// 已过期的令牌无需吊销
+			return nil
+		}
+		return ErrInvalidToken
+	}
+	if claims.ExpiresAt == nil {
+		return ErrInvalidToken
+	}
+
+	if claims.Type == TokenTypeRefresh && claims.Family != "" {
+		if err := ts.revocations.RevokeFamily(ctx, claims.Family); err != nil {
+			return err
+		}
+	}
+	return ts.revocations.Revoke(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// RevokeTokenID 按jti吊销令牌，expiresAt为令牌的过期时间
+func (ts *TokenService) RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error {
+	if ts.revocations == nil {
+		return errors.New("未启用令牌吊销")
+	}
+	return ts.revocations.Revoke(ctx, jti, expiresAt)
+}
+
+// RevokeUserTokens 吊销用户此前签发的所有访问令牌和刷新令牌
+func (ts *TokenService) RevokeUserTokens(ctx context.Context, userID string) error {
+	if ts.revocations == nil {
+		return errors.New("未启用令牌吊销")
 	}
 
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		// 这里应该从数据库或缓存中获取用户信息
-		// 为了简化，我们使用Subject作为用户ID
-		userID := claims.Subject
-		// 实际应用中应该查询用户的当前角色信息
-		roles := []string{"user"} // 默认角色
-		
-		return ts.GenerateToken(userID, "", "", roles)
+	return ts.revocations.RevokeUser(ctx, userID, ts.MaxTokenLifetime())
+}
+
+// MaxTokenLifetime 返回令牌的最长有效期
+func (ts *TokenService) MaxTokenLifetime() time.Duration {
+	if ts.refreshExpiry > ts.tokenExpiry {
+		return ts.refreshExpiry
+	}
+	return ts.tokenExpiry
+}
+
+// checkRevoked 检查令牌是否已被吊销，吊销列表不可用时拒绝令牌
+func (ts *TokenService) checkRevoked(claims *jwt.RegisteredClaims) error {
+	if ts.revocations == nil {
+		return nil
 	}
 
-	return "", ErrInvalidToken
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revocationTimeout)
+	defer cancel()
+
+	if err := ts.revocations.Check(ctx, claims.ID, claims.Subject, issuedAt); err != nil {
+		if errors.Is(err, ErrRevokedToken) {
+			return err
+		}
+		return fmt.Errorf("检查令牌吊销状态失败: %w", err)
+	}
+	return nil
 }
 
 // ExtractUserID 从token中提取用户ID
@@ -154,6 +345,39 @@ func (c *Claims) HasAnyRole(roles ...string) bool {
 	return false
 }
 
+// Scopes 返回令牌的权限范围列表
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Claim 按以点分隔的路径读取声明的字符串值，声明不存在时返回空字符串。
+// 没有原始声明的身份（如客户端证书）只能读取用户标识、用户名和邮箱
+func (c *Claims) Claim(path string) string {
+	if c.Raw != nil {
+		return claimString(c.Raw, path)
+	}
+	switch path {
+	case "user_id", "sub":
+		return c.UserID
+	case "username":
+		return c.Username
+	case "email":
+		return c.Email
+	default:
+		return ""
+	}
+}
+
+// HasScope 检查令牌是否具有指定权限范围
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // User 用户信息结构
 type User struct {
 	ID       string   `json:"id"`
@@ -201,7 +425,7 @@ func NewMockUserService() *MockUserService {
 func (m *MockUserService) GetUser(userID string) (*User, error) {
 	user, exists := m.users[userID]
 	if !exists {
-		return nil, errors.New("用户不存在")
+		return nil, ErrUserNotFound
 	}
 	return user, nil
 }
@@ -210,11 +434,11 @@ func (m *MockUserService) GetUser(userID string) (*User, error) {
 func (m *MockUserService) ValidateCredentials(username, password string) (*User, error) {
 	// 简化验证逻辑，实际应用中应该验证密码哈希
 	for _, user := range m.users {
-		if user.Username == username && password == "password123" {
+		if normalizeUsername(user.Username) == normalizeUsername(username) && password == "password123" {
 			return user, nil
 		}
 	}
-	return nil, errors.New("用户名或密码错误")
+	return nil, ErrInvalidCredentials
 }
 
 // IsUserActive 检查用户是否活跃
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/x509"
+
+	"api-gateway/internal/config"
+)
+
+// ClientCertClaims 将经过验证的客户端证书映射为用户身份，证书中没有可用的用户标识时返回nil
+func ClientCertClaims(cert *x509.Certificate, cfg config.ClientCertAuthConfig) *Claims {
+	var userID string
+	switch cfg.UserIDFrom {
+	case "san_dns":
+		if len(cert.DNSNames) > 0 {
+			userID = cert.DNSNames[0]
+		}
+	case "san_email":
+		if len(cert.EmailAddresses) > 0 {
+			userID = cert.EmailAddresses[0]
+		}
+	case "san_uri":
+		if len(cert.URIs) > 0 {
+			userID = cert.URIs[0].String()
+		}
+	default:
+		userID = cert.Subject.CommonName
+	}
+	if userID == "" {
+		return nil
+	}
+
+	var roles []string
+	if cfg.RolesFromOU {
+		roles = append(roles, cert.Subject.OrganizationalUnit...)
+	}
+	roles = append(roles, cfg.Roles[userID]...)
+
+	claims := &Claims{
+		UserID:   userID,
+		Username: cert.Subject.CommonName,
+		Roles:    roles,
+		Type:     TokenTypeAccess,
+		External: true,
+	}
+	if len(cert.EmailAddresses) > 0 {
+		claims.Email = cert.EmailAddresses[0]
+	}
+	if claims.Username == "" {
+		claims.Username = userID
+	}
+	return claims
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+	"api-gateway/internal/config"
+)
+
+// signingKey 签名密钥，对称密钥的签名和验证密钥相同
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring 令牌密钥环，使用一个密钥签发令牌，按kid选择验证密钥以支持密钥轮换
+type Keyring struct {
+	signing    *signingKey
+	keys       map[string]*signingKey
+	secret     *signingKey
+	algorithms []string
+}
+
+// JSONWebKey JWKS中的公钥
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet JWKS公钥集合
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewKeyring 根据认证配置加载密钥，未配置签名密钥时使用JWTSecret进行HS256签名
+func NewKeyring(cfg config.AuthConfig) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*signingKey)}
+
+	if cfg.JWTSecret != "" {
+		k.secret = &signingKey{
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(cfg.JWTSecret),
+			verifyKey: []byte(cfg.JWTSecret),
+		}
+	}
+
+	for _, keyCfg := range cfg.SigningKeys {
+		key, err := loadSigningKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("加载签名密钥 %s 失败: %w", keyCfg.ID, err)
+		}
+		k.keys[key.id] = key
+
+		if key.signKey == nil {
+			continue
+		}
+		if (k.signing == nil && cfg.SigningKeyID == "") || key.id == cfg.SigningKeyID {
+			k.signing = key
+		}
+	}
+
+	if len(cfg.SigningKeys) == 0 {
+		k.signing = k.secret
+	}
+	if k.signing == nil {
+		return nil, errors.New("没有可用于签发令牌的密钥")
+	}
+
+	k.algorithms = cfg.Algorithms
+	if len(k.algorithms) == 0 {
+		seen := make(map[string]bool)
+		for _, keyCfg := range cfg.SigningKeys {
+			alg := k.keys[keyCfg.ID].method.Alg()
+			if !seen[alg] {
+				seen[alg] = true
+				k.algorithms = append(k.algorithms, alg)
+			}
+		}
+		if len(cfg.SigningKeys) == 0 {
+			k.algorithms = []string{jwt.SigningMethodHS256.Alg()}
+		}
+	}
+
+	return k, nil
+}
+
+// loadSigningKey 从PEM文件加载密钥并检查密钥类型与算法是否匹配
+func loadSigningKey(cfg config.SigningKeyConfig) (*signingKey, error) {
+	method := jwt.GetSigningMethod(cfg.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("不支持的签名算法: %s", cfg.Algorithm)
+	}
+
+	key := &signingKey{id: cfg.ID, method: method}
+	var err error
+	if cfg.PrivateKeyFile != "" {
+		key.signKey, key.verifyKey, err = readPrivateKey(cfg.PrivateKeyFile, method)
+	} else {
+		key.verifyKey, err = readPublicKey(cfg.PublicKeyFile, method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// readPrivateKey 读取私钥，返回私钥和对应的公钥
+func readPrivateKey(path string, method jwt.SigningMethod) (interface{}, interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取私钥文件失败: %w", err)
+	}
+
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &key.PublicKey, nil
+	case *jwt.SigningMethodECDSA:
+		key, err := jwt.ParseECPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkCurve(&key.PublicKey, m); err != nil {
+			return nil, nil, err
+		}
+		return key, &key.PublicKey, nil
+	case *jwt.SigningMethodEd25519:
+		key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, key.(crypto.Signer).Public(), nil
+	default:
+		return nil, nil, fmt.Errorf("签名算法 %s 不支持密钥文件", method.Alg())
+	}
+}
+
+// readPublicKey 读取公钥
+func readPublicKey(path string, method jwt.SigningMethod) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取公钥文件失败: %w", err)
+	}
+
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	case *jwt.SigningMethodECDSA:
+		key, err := jwt.ParseECPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCurve(key, m); err != nil {
+			return nil, err
+		}
+		return key, nil
+	case *jwt.SigningMethodEd25519:
+		return jwt.ParseEdPublicKeyFromPEM(data)
+	default:
+		return nil, fmt.Errorf("签名算法 %s 不支持密钥文件", method.Alg())
+	}
+}
+
+// checkCurve 检查ECDSA密钥的曲线与算法是否匹配，例如ES256需要P-256
+func checkCurve(key *ecdsa.PublicKey, method *jwt.SigningMethodECDSA) error {
+	if key.Curve.Params().BitSize != method.CurveBits {
+		return fmt.Errorf("密钥曲线 %s 与算法 %s 不匹配", key.Curve.Params().Name, method.Alg())
+	}
+	return nil
+}
+
+// Sign 使用签名密钥签发令牌，非对称密钥签发的令牌头部包含kid
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	if k.signing.id != "" {
+		token.Header["kid"] = k.signing.id
+	}
+	return token.SignedString(k.signing.signKey)
+}
+
+// Keyfunc 根据令牌头部的kid选择验证密钥，令牌算法必须与密钥算法一致
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	var key *signingKey
+	if kid == "" {
+		key = k.secret
+	} else {
+		key = k.keys[kid]
+	}
+	if key == nil {
+		return nil, fmt.Errorf("未知的密钥: %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("令牌算法 %s 与密钥算法 %s 不匹配", token.Method.Alg(), key.method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// Algorithms 返回接受的令牌算法
+func (k *Keyring) Algorithms() []string {
+	return k.algorithms
+}
+
+// JWKS 返回所有非对称验证密钥的公钥集合
+func (k *Keyring) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range k.keys {
+		jwk := JSONWebKey{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encodeSegment(pub.N.Bytes())
+			jwk.E = encodeSegment(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = pub.Curve.Params().Name
+			jwk.X = encodeSegment(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = encodeSegment(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = encodeSegment(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
+
+// encodeSegment 使用无填充的base64url编码
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,485 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+// jwksFetchTimeout 获取JWKS和发现文档的超时时间
+const jwksFetchTimeout = 5 * time.Second
+
+// OIDCValidator 验证外部身份提供方签发的令牌，支持同时配置多个issuer
+type OIDCValidator struct {
+	providers   map[string]*oidcProvider
+	revocations *RevocationStore
+}
+
+// oidcProvider 单个身份提供方
+type oidcProvider struct {
+	config config.OIDCConfig
+	keys   *jwksCache
+}
+
+// NewOIDCValidator 创建外部令牌验证器，使用本地文件的JWKS会立即加载
+func NewOIDCValidator(providers []config.OIDCConfig) (*OIDCValidator, error) {
+	client := &http.Client{Timeout: jwksFetchTimeout}
+	v := &OIDCValidator{providers: make(map[string]*oidcProvider)}
+
+	for _, cfg := range providers {
+		if len(cfg.Algorithms) == 0 {
+			cfg.Algorithms = []string{jwt.SigningMethodRS256.Alg()}
+		}
+
+		keys := &jwksCache{
+			issuer:             cfg.Issuer,
+			url:                cfg.JWKSURL,
+			file:               cfg.JWKSFile,
+			refreshInterval:    cfg.RefreshInterval,
+			minRefreshInterval: cfg.MinRefreshInterval,
+			client:             client,
+		}
+		if cfg.JWKSFile != "" {
+			if err := keys.refresh(); err != nil {
+				return nil, fmt.Errorf("加载OIDC提供方 %s 的JWKS失败: %w", cfg.Issuer, err)
+			}
+		}
+
+		v.providers[cfg.Issuer] = &oidcProvider{config: cfg, keys: keys}
+	}
+	return v, nil
+}
+
+// SetRevocationStore 设置令牌吊销列表，登出时吊销的外部令牌按jti记录在其中
+func (v *OIDCValidator) SetRevocationStore(store *RevocationStore) {
+	v.revocations = store
+}
+
+// ValidateToken 验证令牌的签名、iss、aud、exp和nbf，并按配置映射用户信息
+func (v *OIDCValidator) ValidateToken(tokenString string) (*Claims, error) {
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, unverified); err != nil {
+		return nil, ErrInvalidToken
+	}
+	issuer, _ := unverified.GetIssuer()
+	provider, ok := v.providers[issuer]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	cfg := provider.config
+	mapClaims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, mapClaims, provider.keys.keyfunc,
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		logger.Debugf("外部令牌验证失败 %s: %v", issuer, err)
+		return nil, ErrInvalidToken
+	}
+
+	audiences, err := mapClaims.GetAudience()
+	if err != nil || !containsAny(audiences, cfg.Audiences) {
+		return nil, ErrInvalidToken
+	}
+
+	claims, err := mapOIDCClaims(mapClaims, cfg.Claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkRevoked(claims.ID); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkRevoked 检查外部令牌是否已被吊销。外部用户不在本地用户服务中，只检查单独吊销的jti
+func (v *OIDCValidator) checkRevoked(jti string) error {
+	if v.revocations == nil || jti == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revocationTimeout)
+	defer cancel()
+
+	revoked, err := v.revocations.IsRevoked(ctx, jti)
+	if err != nil {
+		return fmt.Errorf("检查令牌吊销状态失败: %w", err)
+	}
+	if revoked {
+		return ErrRevokedToken
+	}
+	return nil
+}
+
+// mapOIDCClaims 将外部令牌声明映射为网关的用户信息
+func mapOIDCClaims(mapClaims jwt.MapClaims, mapping config.ClaimMapping) (*Claims, error) {
+	claims := &Claims{
+		UserID:   claimString(mapClaims, mapping.UserID),
+		Username: claimString(mapClaims, mapping.Username),
+		Email:    claimString(mapClaims, mapping.Email),
+		Roles:    claimStrings(mapClaims, mapping.Roles),
+		Scope:    strings.Join(claimStrings(mapClaims, mapping.Scope), " "),
+		Type:     TokenTypeAccess,
+		External: true,
+		Raw:      mapClaims,
+	}
+	if claims.UserID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	claims.Issuer, _ = mapClaims.GetIssuer()
+	claims.Subject, _ = mapClaims.GetSubject()
+	claims.Audience, _ = mapClaims.GetAudience()
+	claims.ExpiresAt, _ = mapClaims.GetExpirationTime()
+	claims.NotBefore, _ = mapClaims.GetNotBefore()
+	claims.IssuedAt, _ = mapClaims.GetIssuedAt()
+	claims.ID = claimString(mapClaims, "jti")
+	return claims, nil
+}
+
+// claimValue 按以点分隔的路径读取声明
+func claimValue(claims jwt.MapClaims, path string) interface{} {
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// claimString 读取字符串声明
+func claimString(claims jwt.MapClaims, path string) string {
+	switch value := claimValue(claims, path).(type) {
+	case string:
+		return value
+	case float64:
+		return fmt.Sprintf("%.0f", value)
+	default:
+		return ""
+	}
+}
+
+// claimStrings 读取字符串列表声明，字符串值按空格或逗号分隔
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	switch value := claimValue(claims, path).(type) {
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// containsAny 检查两个列表是否有相同的元素
+func containsAny(values, candidates []string) bool {
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwksCache 缓存身份提供方的公钥，过期或遇到未知kid时重新获取。
+// 获取JWKS时不持有锁，同一时间只有一次获取，期间其他请求继续使用已缓存的密钥
+type jwksCache struct {
+	issuer             string
+	url                string
+	file               string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	client             *http.Client
+
+	mutex      sync.Mutex
+	keys       map[string]jwksKey
+	fetchedAt  time.Time
+	refreshing *jwksRefresh
+}
+
+// jwksKey JWKS中的验证密钥
+type jwksKey struct {
+	algorithm string
+	key       interface{}
+}
+
+// jwksRefresh 一次正在进行的刷新，完成后关闭done
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// keyfunc 按kid选择验证密钥
+func (c *jwksCache) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, err := c.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.algorithm != "" && key.algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("令牌算法 %s 与密钥算法 %s 不匹配", token.Method.Alg(), key.algorithm)
+	}
+	return key.key, nil
+}
+
+// lookup 查找密钥。缓存过期时在后台刷新，继续使用已缓存的密钥；
+// 还没有密钥或找不到kid时等待刷新完成
+func (c *jwksCache) lookup(kid string) (jwksKey, error) {
+	c.mutex.Lock()
+	key, ok := c.find(kid)
+	loaded := c.keys != nil
+	expired := c.refreshInterval > 0 && time.Since(c.fetchedAt) > c.refreshInterval
+	rotated := time.Since(c.fetchedAt) >= c.minRefreshInterval
+	c.mutex.Unlock()
+
+	if ok {
+		if expired {
+			c.startRefresh()
+		}
+		return key, nil
+	}
+
+	// 身份提供方可能已轮换密钥
+	if !loaded || expired || rotated {
+		if err := c.refresh(); err != nil {
+			return jwksKey{}, err
+		}
+		c.mutex.Lock()
+		key, ok = c.find(kid)
+		c.mutex.Unlock()
+	}
+	if !ok {
+		return jwksKey{}, fmt.Errorf("未知的密钥: %q", kid)
+	}
+	return key, nil
+}
+
+// find 查找密钥，令牌未携带kid且只有一个密钥时使用该密钥。调用方需持有mutex
+func (c *jwksCache) find(kid string) (jwksKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// refresh 重新加载公钥并等待完成，已有刷新在进行时等待同一次刷新的结果
+func (c *jwksCache) refresh() error {
+	call := c.startRefresh()
+	<-call.done
+	return call.err
+}
+
+// startRefresh 在后台重新加载公钥，已有刷新在进行时返回该次刷新
+func (c *jwksCache) startRefresh() *jwksRefresh {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.refreshing == nil {
+		call := &jwksRefresh{done: make(chan struct{})}
+		c.refreshing = call
+		go func() {
+			call.err = c.load()
+			close(call.done)
+		}()
+	}
+	return c.refreshing
+}
+
+// load 获取并解析公钥，完成后更新缓存
+func (c *jwksCache) load() error {
+	var data []byte
+	var err error
+	if c.file != "" {
+		data, err = os.ReadFile(c.file)
+	} else {
+		data, err = c.fetchJWKS()
+	}
+
+	var keys map[string]jwksKey
+	if err != nil {
+		logger.Warnf("获取OIDC提供方 %s 的JWKS失败: %v", c.issuer, err)
+	} else if keys, err = parseJWKS(data); err != nil {
+		logger.Warnf("解析OIDC提供方 %s 的JWKS失败: %v", c.issuer, err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// 无论成功与否都更新时间，避免身份提供方不可用时每个请求都触发刷新
+	c.fetchedAt = time.Now()
+	if err == nil {
+		c.keys = keys
+	}
+	c.refreshing = nil
+	return err
+}
+
+// fetchJWKS 从jwks_url获取公钥，未配置时通过发现文档获取地址。同一时间只有一次刷新调用
+func (c *jwksCache) fetchJWKS() ([]byte, error) {
+	if c.url == "" {
+		data, err := c.get(strings.TrimSuffix(c.issuer, "/") + "/.well-known/openid-configuration")
+		if err != nil {
+			return nil, err
+		}
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := json.Unmarshal(data, &discovery); err != nil || discovery.JWKSURI == "" {
+			return nil, errors.New("发现文档中缺少jwks_uri")
+		}
+		c.url = discovery.JWKSURI
+	}
+	return c.get(c.url)
+}
+
+// get 发送GET请求并读取响应体
+func (c *jwksCache) get(url string) ([]byte, error) {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("请求 %s 返回状态码 %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWKS 解析JWKS，跳过不支持的密钥类型和非签名用途的密钥
+func parseJWKS(data []byte) (map[string]jwksKey, error) {
+	var set JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jwksKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			logger.Warnf("跳过无效的JWK %q: %v", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = jwksKey{algorithm: jwk.Algorithm, key: key}
+	}
+	return keys, nil
+}
+
+// parseJWK 将JWK转换为公钥
+func parseJWK(jwk JSONWebKey) (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeSegment(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", jwk.Curve)
+		}
+		x, err := decodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("不支持的曲线: %s", jwk.Curve)
+		}
+		x, err := decodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("无效的Ed25519公钥")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("不支持的密钥类型: %s", jwk.KeyType)
+	}
+}
+
+// decodeSegment 解码无填充的base64url
+func decodeSegment(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+// ChainValidator 依次使用多个验证器验证令牌，令牌不属于某个验证器时尝试下一个
+type ChainValidator struct {
+	validators []TokenValidator
+}
+
+// NewChainValidator 创建组合验证器
+func NewChainValidator(validators ...TokenValidator) *ChainValidator {
+	return &ChainValidator{validators: validators}
+}
+
+// ValidateToken 返回第一个验证成功的结果，过期或已吊销等明确的错误直接返回
+func (c *ChainValidator) ValidateToken(tokenString string) (*Claims, error) {
+	for _, validator := range c.validators {
+		claims, err := validator.ValidateToken(tokenString)
+		if err == nil || !errors.Is(err, ErrInvalidToken) {
+			return claims, err
+		}
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+func init() {
+	logger.Init(config.LoggingConfig{Level: "error", Format: "text"})
+}
+
+// ed25519JWK 生成Ed25519公钥的JWK
+func ed25519JWK(t *testing.T, kid string) JSONWebKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return JSONWebKey{KeyType: "OKP", KeyID: kid, Use: "sig", Curve: "Ed25519", X: encodeSegment(public)}
+}
+
+func TestJWKSCacheRefreshOutsideLock(t *testing.T) {
+	var mutex sync.Mutex
+	jwks := JSONWebKeySet{Keys: []JSONWebKey{ed25519JWK(t, "k1")}}
+	var fetches atomic.Int32
+	gate := make(chan struct{})
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一次之后的获取等待gate
+		if fetches.Add(1) > 1 {
+			<-gate
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	defer idp.Close()
+
+	keys := &jwksCache{
+		issuer:          idp.URL,
+		url:             idp.URL,
+		refreshInterval: 10 * time.Millisecond,
+		client:          idp.Client(),
+	}
+	_, err := keys.lookup("k1")
+	require.NoError(t, err)
+
+	// 缓存过期后在后台刷新，刷新期间继续使用已缓存的密钥
+	time.Sleep(20 * time.Millisecond)
+	mutex.Lock()
+	jwks.Keys = append(jwks.Keys, ed25519JWK(t, "k2"))
+	mutex.Unlock()
+	for i := 0; i < 3; i++ {
+		_, err = keys.lookup("k1")
+		require.NoError(t, err)
+	}
+
+	// 未知kid等待正在进行的刷新，不重复获取
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := keys.lookup("k2")
+			assert.NoError(t, err)
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+	close(gate)
+	wg.Wait()
+	assert.Equal(t, int32(2), fetches.Load())
+
+	_, err = keys.lookup("k3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"api-gateway/internal/cache"
+)
+
+const (
+	// revokedTokenPrefix 已吊销令牌的缓存键前缀
+	revokedTokenPrefix = "auth:revoked:"
+	// userNotBeforePrefix 用户令牌最早签发时间的缓存键前缀
+	userNotBeforePrefix = "auth:not_before:"
+	// refreshFamilyPrefix 有效刷新令牌家族的缓存键前缀
+	refreshFamilyPrefix = "auth:refresh_family:"
+	// usedRefreshTokenPrefix 已使用刷新令牌的缓存键前缀
+	usedRefreshTokenPrefix = "auth:refresh_used:"
+
+	// revocationTimeout 访问吊销列表的超时时间
+	revocationTimeout = time.Second
+)
+
+// RevocationStore 令牌吊销列表，记录保存在缓存中，过期时间与令牌剩余有效期一致
+type RevocationStore struct {
+	cache cache.Cache
+}
+
+// NewRevocationStore 创建令牌吊销列表
+func NewRevocationStore(c cache.Cache) *RevocationStore {
+	return &RevocationStore{cache: c}
+}
+
+// Revoke 吊销指定jti的令牌直到expiresAt
+func (s *RevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return ErrInvalidToken
+	}
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		// 令牌已过期，无需记录
+		return nil
+	}
+	return s.cache.Set(ctx, revokedTokenPrefix+jti, "1", ttl)
+}
+
+// IsRevoked 检查指定jti的令牌是否已被吊销
+func (s *RevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	value, err := s.cache.Get(ctx, revokedTokenPrefix+jti)
+	if err != nil {
+		return false, err
+	}
+	return value != "", nil
+}
+
+// RevokeUser 吊销用户在此之前签发的所有令牌，ttl应不小于令牌的最长有效期
+func (s *RevocationStore) RevokeUser(ctx context.Context, userID string, ttl time.Duration) error {
+	notBefore := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return s.cache.Set(ctx, userNotBeforePrefix+userID, notBefore, ttl)
+}
+
+// UserNotBefore 获取用户令牌的最早有效签发时间，未设置时返回零值
+func (s *RevocationStore) UserNotBefore(ctx context.Context, userID string) (time.Time, error) {
+	value, err := s.cache.Get(ctx, userNotBeforePrefix+userID)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的用户令牌吊销时间: %w", err)
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// Check 检查令牌是否被单独吊销或因用户吊销而失效，在用户吊销的同一时刻签发的令牌同样失效。
+// 本地令牌的签发时间精确到毫秒，只精确到秒的外部令牌在吊销的同一秒内签发时同样失效。
+func (s *RevocationStore) Check(ctx context.Context, jti, userID string, issuedAt time.Time) error {
+	if jti != "" {
+		revoked, err := s.IsRevoked(ctx, jti)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrRevokedToken
+		}
+	}
+
+	notBefore, err := s.UserNotBefore(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !notBefore.IsZero() && !issuedAt.After(notBefore) {
+		return ErrRevokedToken
+	}
+	return nil
+}
+
+// StartFamily 登记有效的刷新令牌家族，ttl应不小于家族中最新刷新令牌的剩余有效期
+func (s *RevocationStore) StartFamily(ctx context.Context, family string, ttl time.Duration) error {
+	return s.cache.Set(ctx, refreshFamilyPrefix+family, "1", ttl)
+}
+
+// RevokeFamily 吊销刷新令牌家族，家族中的所有刷新令牌随即失效
+func (s *RevocationStore) RevokeFamily(ctx context.Context, family string) error {
+	return s.cache.Del(ctx, refreshFamilyPrefix+family)
+}
+
+// UseRefreshToken 将刷新令牌标记为已使用。家族已被吊销时返回ErrRevokedToken；
+// 令牌此前已被使用时说明令牌可能被盗用，吊销整个家族并返回ErrRefreshTokenReused。
+func (s *RevocationStore) UseRefreshToken(ctx context.Context, family, jti string, expiresAt time.Time) error {
+	if family == "" || jti == "" {
+		return ErrInvalidToken
+	}
+
+	active, err := s.cache.Get(ctx, refreshFamilyPrefix+family)
+	if err != nil {
+		return err
+	}
+	if active == "" {
+		return ErrRevokedToken
+	}
+
+	// 使用原子计数判断令牌是否已被使用，并发刷新时只有一个请求成功
+	key := usedRefreshTokenPrefix + jti
+	used, err := s.cache.Incr(ctx, key)
+	if err != nil {
+		return err
+	}
+	if used > 1 {
+		if err := s.RevokeFamily(ctx, family); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+
+	if ttl := time.Until(expiresAt); ttl > 0 {
+		return s.cache.Expire(ctx, key, ttl)
+	}
+	return nil
+}
+
+// generateJTI 生成令牌唯一标识
+func generateJTI() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
+)
+
+func TestRevokeUserSameSecond(t *testing.T) {
+	service, err := NewTokenService(config.AuthConfig{JWTSecret: "secret", TokenExpiry: time.Hour})
+	require.NoError(t, err)
+	service.SetRevocationStore(NewRevocationStore(cache.NewMemoryCache()))
+
+	// 吊销前签发的令牌失效，即使与吊销在同一秒内
+	before, err := service.GenerateToken("1", "alice", "", nil)
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, service.RevokeUserTokens(context.Background(), "1"))
+	_, err = service.ValidateToken(before)
+	assert.ErrorIs(t, err, ErrRevokedToken)
+
+	// 吊销后立即签发的令牌有效
+	time.Sleep(2 * time.Millisecond)
+	after, err := service.GenerateToken("1", "alice", "", nil)
+	require.NoError(t, err)
+	_, err = service.ValidateToken(after)
+	assert.NoError(t, err)
+
+	// 只精确到秒的外部令牌在吊销的同一秒内签发时失效
+	store := NewRevocationStore(cache.NewMemoryCache())
+	require.NoError(t, store.RevokeUser(context.Background(), "2", time.Hour))
+	notBefore, err := store.UserNotBefore(context.Background(), "2")
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.Check(context.Background(), "", "2", notBefore.Truncate(time.Second)), ErrRevokedToken)
+	assert.NoError(t, store.Check(context.Background(), "", "2", notBefore.Add(time.Millisecond)))
+}
@@ -0,0 +1,491 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+var (
+	ErrUserNotFound       = errors.New("用户不存在")
+	ErrUserExists         = errors.New("用户已存在")
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+)
+
+// UserManager 支持创建和禁用用户的用户服务
+type UserManager interface {
+	CreateUser(user User, password string) (*User, error)
+	SetUserActive(userID string, active bool) error
+}
+
+// NewUserService 根据配置创建用户服务
+func NewUserService(cfg config.UserStoreConfig) (UserService, error) {
+	switch cfg.Store {
+	case "file":
+		store, err := NewFileUserStore(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "http":
+		return NewHTTPUserStore(cfg), nil
+	case "mock":
+		if !cfg.AllowMock {
+			return nil, errors.New("演示用户存储mock需要设置allow_mock")
+		}
+		logger.Warn("使用演示用户服务，请勿在生产环境使用")
+		return NewMockUserService(), nil
+	default:
+		return nil, fmt.Errorf("无效的用户存储: %q", cfg.Store)
+	}
+}
+
+// normalizeUsername 用户名不区分大小写并忽略首尾空白，用户存储的查找和登录失败锁定都使用它
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// HashPassword 使用bcrypt计算密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword 验证密码，支持bcrypt和PHC格式的argon2id哈希
+func VerifyPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2id(hash, password)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// verifyArgon2id 验证格式为 $argon2id$v=19$m=65536,t=3,p=2$salt$hash 的argon2id哈希
+func verifyArgon2id(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// verifyDummyPassword 用户不存在时同样计算一次哈希，避免通过响应时间判断用户名是否存在
+func verifyDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword(generateJTI())
+	})
+	VerifyPassword(dummyHash, password)
+}
+
+// fileUser 用户文件中的用户记录
+type fileUser struct {
+	ID           string   `yaml:"id" json:"id"`
+	Username     string   `yaml:"username" json:"username"`
+	Email        string   `yaml:"email,omitempty" json:"email,omitempty"`
+	Roles        []string `yaml:"roles" json:"roles"`
+	PasswordHash string   `yaml:"password_hash" json:"password_hash"`
+	Disabled     bool     `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+}
+
+// user 转换为不含密码哈希的用户信息
+func (u *fileUser) user() *User {
+	return &User{
+		ID:       u.ID,
+		Username: u.Username,
+		Email:    u.Email,
+		Roles:    append([]string(nil), u.Roles...),
+		Active:   !u.Disabled,
+	}
+}
+
+// fileUsers 用户文件结构
+type fileUsers struct {
+	Users []*fileUser `yaml:"users" json:"users"`
+}
+
+// FileUserStore 基于YAML或JSON文件的用户存储，密码使用bcrypt或argon2id哈希，用户名不区分大小写
+type FileUserStore struct {
+	path       string
+	mutex      sync.RWMutex
+	users      []*fileUser
+	byID       map[string]*fileUser
+	byUsername map[string]*fileUser // 键为normalizeUsername后的用户名
+}
+
+// NewFileUserStore 创建基于文件的用户存储，文件不存在时在首次创建用户时写入
+func NewFileUserStore(path string) (*FileUserStore, error) {
+	s := &FileUserStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload 重新加载用户文件，加载失败时保留当前用户
+func (s *FileUserStore) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		data = nil
+	} else if err != nil {
+		return fmt.Errorf("读取用户文件失败: %w", err)
+	}
+
+	// JSON是YAML的子集，两种格式都使用YAML解析
+	var file fileUsers
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("解析用户文件失败: %w", err)
+	}
+
+	byID := make(map[string]*fileUser)
+	byUsername := make(map[string]*fileUser)
+	for i, u := range file.Users {
+		if u.Username == "" {
+			return fmt.Errorf("用户 %d 的用户名不能为空", i)
+		}
+		if u.ID == "" {
+			u.ID = u.Username
+		}
+		name := normalizeUsername(u.Username)
+		if byID[u.ID] != nil || byUsername[name] != nil {
+			return fmt.Errorf("用户 %s 重复", u.Username)
+		}
+		byID[u.ID] = u
+		byUsername[name] = u
+	}
+
+	s.mutex.Lock()
+	s.users = file.Users
+	s.byID = byID
+	s.byUsername = byUsername
+	s.mutex.Unlock()
+	return nil
+}
+
+// Watch 监听用户文件，文件变化时重新加载，直到ctx取消
+func (s *FileUserStore) Watch(ctx context.Context, interval time.Duration) {
+	config.NewWatcher(s.path, interval).Start(ctx, func() {
+		if err := s.Reload(); err != nil {
+			logger.Errorf("重新加载用户文件失败，继续使用当前用户: %v", err)
+			return
+		}
+		logger.Info("用户文件已重新加载")
+	})
+}
+
+// GetUser 获取用户信息
+func (s *FileUserStore) GetUser(userID string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	u, ok := s.byID[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return u.user(), nil
+}
+
+// ValidateCredentials 验证用户凭据，密码正确但用户已禁用时返回ErrInactiveUser
+func (s *FileUserStore) ValidateCredentials(username, password string) (*User, error) {
+	s.mutex.RLock()
+	u, ok := s.byUsername[normalizeUsername(username)]
+	var hash string
+	if ok {
+		hash = u.PasswordHash
+	}
+	s.mutex.RUnlock()
+
+	if !ok {
+		verifyDummyPassword(password)
+		return nil, ErrInvalidCredentials
+	}
+	if !VerifyPassword(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := s.GetUser(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active {
+		return nil, ErrInactiveUser
+	}
+	return user, nil
+}
+
+// IsUserActive 检查用户是否活跃
+func (s *FileUserStore) IsUserActive(userID string) (bool, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.Active, nil
+}
+
+// CreateUser 创建用户并写回用户文件
+func (s *FileUserStore) CreateUser(user User, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	name := normalizeUsername(user.Username)
+	if s.byUsername[name] != nil {
+		return nil, ErrUserExists
+	}
+	if user.ID == "" {
+		if user.ID, err = randomString(8, hex.EncodeToString); err != nil {
+			return nil, err
+		}
+	}
+	if s.byID[user.ID] != nil {
+		return nil, ErrUserExists
+	}
+
+	u := &fileUser{
+		ID:           user.ID,
+		Username:     user.Username,
+		Email:        user.Email,
+		Roles:        user.Roles,
+		PasswordHash: hash,
+	}
+	if err := s.write(append(s.users, u)); err != nil {
+		return nil, err
+	}
+
+	s.users = append(s.users, u)
+	s.byID[u.ID] = u
+	s.byUsername[name] = u
+	return u.user(), nil
+}
+
+// SetUserActive 启用或禁用用户并写回用户文件
+func (s *FileUserStore) SetUserActive(userID string, active bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	u, ok := s.byID[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	previous := u.Disabled
+	u.Disabled = !active
+	if err := s.write(s.users); err != nil {
+		u.Disabled = previous
+		return err
+	}
+	return nil
+}
+
+// write 按文件扩展名写入YAML或JSON，先写临时文件再重命名，调用方需持有mutex
+func (s *FileUserStore) write(users []*fileUser) error {
+	var data []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(s.path), ".json") {
+		data, err = json.MarshalIndent(fileUsers{Users: users}, "", "  ")
+	} else {
+		data, err = yaml.Marshal(fileUsers{Users: users})
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".users-*")
+	if err != nil {
+		return fmt.Errorf("写入用户文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入用户文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入用户文件失败: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// HTTPUserStore 将用户查询和凭据验证委托给上游用户服务。
+// 上游需要提供 GET {url}/users/{id} 和 POST {url}/authenticate 两个接口，均返回User结构。
+type HTTPUserStore struct {
+	baseURL  string
+	token    string
+	client   *http.Client
+	cacheTTL time.Duration
+
+	mutex sync.Mutex
+	users map[string]cachedUser
+}
+
+// cachedUser 本地缓存的上游用户信息
+type cachedUser struct {
+	user      *User
+	expiresAt time.Time
+}
+
+// NewHTTPUserStore 创建委托上游用户服务的用户存储
+func NewHTTPUserStore(cfg config.UserStoreConfig) *HTTPUserStore {
+	return &HTTPUserStore{
+		baseURL:  strings.TrimSuffix(cfg.URL, "/"),
+		token:    cfg.Token,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		cacheTTL: cfg.CacheTTL,
+		users:    make(map[string]cachedUser),
+	}
+}
+
+// GetUser 获取用户信息，结果在本地缓存cacheTTL时间
+func (s *HTTPUserStore) GetUser(userID string) (*User, error) {
+	s.mutex.Lock()
+	cached, ok := s.users[userID]
+	s.mutex.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.user, nil
+	}
+
+	var user User
+	status, err := s.do(http.MethodGet, "/users/"+url.PathEscape(userID), nil, &user)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		s.cache(&user)
+		return &user, nil
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	default:
+		return nil, fmt.Errorf("用户服务返回状态码 %d", status)
+	}
+}
+
+// ValidateCredentials 由上游用户服务验证凭据
+func (s *HTTPUserStore) ValidateCredentials(username, password string) (*User, error) {
+	body := map[string]string{"username": username, "password": password}
+
+	var user User
+	status, err := s.do(http.MethodPost, "/authenticate", body, &user)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		s.cache(&user)
+		if !user.Active {
+			return nil, ErrInactiveUser
+		}
+		return &user, nil
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, fmt.Errorf("用户服务返回状态码 %d", status)
+	}
+}
+
+// IsUserActive 检查用户是否活跃
+func (s *HTTPUserStore) IsUserActive(userID string) (bool, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.Active, nil
+}
+
+// cache 缓存用户信息
+func (s *HTTPUserStore) cache(user *User) {
+	if s.cacheTTL <= 0 {
+		return
+	}
+	s.mutex.Lock()
+	s.users[user.ID] = cachedUser{user: user, expiresAt: time.Now().Add(s.cacheTTL)}
+	s.mutex.Unlock()
+}
+
+// do 调用上游用户服务，状态码为200时将响应解析到out
+func (s *HTTPUserStore) do(method, path string, body, out interface{}) (int, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, s.baseURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("调用用户服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("解析用户服务响应失败: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
+)
+
+// argon2idHash 生成PHC格式的argon2id哈希
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 2, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=65536,t=1,p=2$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	require.NoError(t, err)
+	assert.True(t, VerifyPassword(hash, "secret"))
+	assert.False(t, VerifyPassword(hash, "Secret"))
+
+	argonHash := argon2idHash("secret")
+	assert.True(t, VerifyPassword(argonHash, "secret"))
+	assert.False(t, VerifyPassword(argonHash, "other"))
+
+	// 格式错误或版本不支持的哈希不通过
+	assert.False(t, VerifyPassword("$argon2id$v=19$m=65536,t=1$c2FsdA$aGFzaA", "secret"))
+	assert.False(t, VerifyPassword("$argon2id$v=16$m=65536,t=1,p=2$c2FsdA$aGFzaA", "secret"))
+	assert.False(t, VerifyPassword("plain", "plain"))
+}
+
+func TestFileUserStoreReload(t *testing.T) {
+	hash, err := HashPassword("secret")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	writeUsers := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	writeUsers(fmt.Sprintf(`users:
+  - id: "1"
+    username: Alice
+    roles: [admin]
+    password_hash: "%s"
+  - username: bob
+    password_hash: "%s"
+    disabled: true
+`, hash, argon2idHash("secret")))
+
+	store, err := NewFileUserStore(path)
+	require.NoError(t, err)
+
+	// 用户名不区分大小写，未配置ID时使用用户名
+	user, err := store.ValidateCredentials(" alice ", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", user.Username)
+	assert.Equal(t, []string{"admin"}, user.Roles)
+	_, err = store.ValidateCredentials("alice", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = store.ValidateCredentials("bob", "secret")
+	assert.ErrorIs(t, err, ErrInactiveUser)
+	_, err = store.GetUser("bob")
+	assert.NoError(t, err)
+
+	// 重新加载后使用新的用户
+	writeUsers(fmt.Sprintf(`users:
+  - id: "1"
+    username: Alice
+    password_hash: "%s"
+  - username: carol
+    password_hash: "%s"
+`, hash, hash))
+	require.NoError(t, store.Reload())
+	_, err = store.ValidateCredentials("carol", "secret")
+	assert.NoError(t, err)
+	_, err = store.GetUser("bob")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// 加载失败时保留当前用户，只有大小写不同的用户名视为重复
+	writeUsers(fmt.Sprintf(`users:
+  - username: carol
+    password_hash: "%s"
+  - username: CAROL
+    password_hash: "%s"
+`, hash, hash))
+	assert.ErrorContains(t, store.Reload(), "重复")
+	_, err = store.ValidateCredentials("alice", "secret")
+	assert.NoError(t, err)
+
+	_, err = store.CreateUser(User{Username: "ALICE"}, "secret")
+	assert.ErrorIs(t, err, ErrUserExists)
+}
+
+func TestLockoutMatchesUserStore(t *testing.T) {
+	lockout := NewLoginLockout(cache.NewMemoryCache(), config.LockoutConfig{
+		MaxAttempts: 2, Window: time.Minute, BaseDuration: time.Minute, MaxDuration: time.Hour,
+	})
+	ctx := context.Background()
+
+	// 与用户存储相同的用户名规范化，大小写不同的尝试计入同一个账户
+	_, err := lockout.RecordFailure(ctx, "Alice")
+	require.NoError(t, err)
+	duration, err := lockout.RecordFailure(ctx, " alice")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, duration)
+
+	remaining, err := lockout.Locked(ctx, "ALICE")
+	require.NoError(t, err)
+	assert.Positive(t, remaining)
+}
+
+func TestHTTPUserStore(t *testing.T) {
+	var lookups atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer upstream-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/users/1":
+			lookups.Add(1)
+			json.NewEncoder(w).Encode(User{ID: "1", Username: "alice", Active: true})
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/authenticate":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			switch {
+			case body["password"] != "secret":
+				w.WriteHeader(http.StatusUnauthorized)
+			case body["username"] == "bob":
+				json.NewEncoder(w).Encode(User{ID: "2", Username: "bob"})
+			case body["username"] == "alice":
+				json.NewEncoder(w).Encode(User{ID: "1", Username: "alice", Active: true})
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	store := NewHTTPUserStore(config.UserStoreConfig{URL: upstream.URL + "/", Token: "upstream-token", Timeout: time.Second, CacheTTL: time.Minute})
+
+	user, err := store.ValidateCredentials("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "1", user.ID)
+	_, err = store.ValidateCredentials("alice", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = store.ValidateCredentials("bob", "secret")
+	assert.ErrorIs(t, err, ErrInactiveUser)
+	_, err = store.ValidateCredentials("carol", "secret")
+	assert.ErrorContains(t, err, "500")
+
+	// 验证凭据时缓存了用户信息
+	active, err := store.IsUserActive("1")
+	require.NoError(t, err)
+	assert.True(t, active)
+	assert.Zero(t, lookups.Load())
+
+	_, err = store.GetUser("3")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// 未缓存时查询上游
+	uncached := NewHTTPUserStore(config.UserStoreConfig{URL: upstream.URL, Token: "upstream-token", Timeout: time.Second})
+	for i := 0; i < 2; i++ {
+		_, err = uncached.GetUser("1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), lookups.Load())
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -22,6 +24,12 @@ type Cache interface {
 	Close() error
 }
 
+// ScriptRunner 支持原子执行Lua脚本的缓存，目前只有Redis缓存实现
+type ScriptRunner interface {
+	Cache
+	RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error)
+}
+
 // RedisCache Redis缓存实现
 type RedisCache struct {
 	client *redis.Client
@@ -95,6 +103,11 @@ func (r *RedisCache) Expire(ctx context.Context, key string, expiration time.Dur
 	return r.client.Expire(ctx, key, expiration).Err()
 }
 
+// RunScript 执行Lua脚本，优先使用EVALSHA，脚本未加载时自动回退到EVAL
+func (r *RedisCache) RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.Run(ctx, r.client, keys, args...).Result()
+}
+
 // Close 关闭连接
 func (r *RedisCache) Close() error {
 	return r.client.Close()
@@ -102,7 +115,8 @@ func (r *RedisCache) Close() error {
 
 // MemoryCache 内存缓存实现（用于开发和测试）
 type MemoryCache struct {
-	data map[string]cacheItem
+	data  map[string]cacheItem
+	mutex sync.Mutex
 }
 
 type cacheItem struct {
@@ -119,6 +133,9 @@ func NewMemoryCache() Cache {
 
 // Get 获取缓存值
 func (m *MemoryCache) Get(ctx context.Context, key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	item, exists := m.data[key]
 	if !exists {
 		return "", nil
@@ -151,12 +168,17 @@ func (m *MemoryCache) Set(ctx context.Context, key string, value interface{}, ex
 		item.expiration = time.Now().Add(expiration)
 	}
 
+	m.mutex.Lock()
 	m.data[key] = item
+	m.mutex.Unlock()
 	return nil
 }
 
 // Del 删除缓存键
 func (m *MemoryCache) Del(ctx context.Context, keys ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	for _, key := range keys {
 		delete(m.data, key)
 	}
@@ -165,6 +187,9 @@ func (m *MemoryCache) Del(ctx context.Context, keys ...string) error {
 
 // Exists 检查键是否存在
 func (m *MemoryCache) Exists(ctx context.Context, keys ...string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	count := int64(0)
 	for _, key := range keys {
 		if _, exists := m.data[key]; exists {
@@ -176,20 +201,31 @@ func (m *MemoryCache) Exists(ctx context.Context, keys ...string) (int64, error)
 
 // Incr 增加计数器
 func (m *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	item, exists := m.data[key]
-	if !exists {
+	if !exists || (!item.expiration.IsZero() && time.Now().After(item.expiration)) {
 		m.data[key] = cacheItem{value: "1"}
 		return 1, nil
 	}
 
-	// 简单实现，实际应该解析数字
-	val := len(item.value) + 1
-	m.data[key] = cacheItem{value: fmt.Sprintf("%d", val)}
-	return int64(val), nil
+	// 与Redis的INCR一致，保留原有的过期时间
+	val, err := strconv.ParseInt(item.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("缓存值不是整数: %s", key)
+	}
+	val++
+	item.value = strconv.FormatInt(val, 10)
+	m.data[key] = item
+	return val, nil
 }
 
 // Expire 设置键过期时间
 func (m *MemoryCache) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	if item, exists := m.data[key]; exists {
 		item.expiration = time.Now().Add(expiration)
 		m.data[key] = item
@@ -199,6 +235,9 @@ func (m *MemoryCache) Expire(ctx context.Context, key string, expiration time.Du
 
 // Close 关闭连接
 func (m *MemoryCache) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	m.data = make(map[string]cacheItem)
 	return nil
 }
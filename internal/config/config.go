@@ -1,8 +1,10 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -16,6 +18,57 @@ type Config struct {
 	Auth     AuthConfig     `yaml:"auth"`
 	Logging  LoggingConfig  `yaml:"logging"`
 	Metrics  MetricsConfig  `yaml:"metrics"`
+	Admin    AdminConfig    `yaml:"admin"`
+	// RateLimit 路由速率限制使用的算法，路由的 rate_limit 为每个窗口允许的请求数
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// Quota 按调用方统计的长周期用量配额，路由通过 quota_group 使用
+	Quota QuotaConfig `yaml:"quota"`
+
+	path       string                // 配置文件路径
+	unresolved []unresolvedReference // 加载时未能解析的环境变量和密钥文件引用
+}
+
+// RateLimitConfig 速率限制配置
+type RateLimitConfig struct {
+	// Algorithm token_bucket（默认）、gcra、sliding_window（滑动窗口日志）、
+	// sliding_window_counter（滑动窗口计数器）或 fixed_window，使用Redis时所有网关实例共享同一个限额
+	Algorithm string        `yaml:"algorithm"`
+	Window    time.Duration `yaml:"window"` // 限额对应的时间窗口，默认1秒
+	Burst     int           `yaml:"burst"`  // 突发容量，0表示与限额相同
+	// Plans 命名的速率限制计划，路由的速率限制通过 plans 引用，按调用方的等级或角色选择
+	Plans map[string]RateLimitPlan `yaml:"plans"`
+	// TierClaim 令牌中表示计划等级的声明，默认为tier；API密钥的等级优先
+	TierClaim string `yaml:"tier_claim"`
+	// DefaultPlan 已认证但等级和角色都没有匹配计划的调用方使用的计划
+	DefaultPlan string `yaml:"default_plan"`
+	// AnonymousPlan 未认证的调用方使用的计划
+	AnonymousPlan string `yaml:"anonymous_plan"`
+}
+
+// RateLimitPlan 速率限制计划，未配置的窗口、突发容量和算法使用全局配置
+type RateLimitPlan struct {
+	Rate      int           `yaml:"rate"` // 每个窗口允许的请求数
+	Burst     int           `yaml:"burst"`
+	Window    time.Duration `yaml:"window"`
+	Algorithm string        `yaml:"algorithm"`
+	// Roles 具有任一角色的调用方可以使用该计划，匹配多个计划时使用限额最高的计划
+	Roles []string `yaml:"roles"`
+}
+
+// QuotaConfig 用量配额配置，按日历日和日历月统计每个调用方在每个配额组内的请求数
+type QuotaConfig struct {
+	Groups map[string]QuotaGroup `yaml:"groups"`
+	// TimeZone 日和月窗口使用的时区，默认UTC
+	TimeZone string `yaml:"time_zone"`
+	// SnapshotFile 用量快照文件，Redis中的计数丢失后从快照恢复，为空时不保存快照
+	SnapshotFile     string        `yaml:"snapshot_file"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"` // 默认1分钟
+}
+
+// QuotaGroup 配额组，0表示对应的窗口不限制
+type QuotaGroup struct {
+	Daily   int64 `yaml:"daily"`
+	Monthly int64 `yaml:"monthly"`
 }
 
 // ServerConfig 服务器配置
@@ -33,6 +86,10 @@ type TLSConfig struct {
 	Enabled  bool   `yaml:"enabled"`
 	CertFile string `yaml:"cert_file"`
 	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile 验证客户端证书的CA证书，配置后启用双向TLS
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"` // require（默认）或 verify_if_given
+	MinVersion   string `yaml:"min_version"` // 1.2（默认）或 1.3
 }
 
 // RedisConfig Redis配置
@@ -46,26 +103,124 @@ type RedisConfig struct {
 
 // RouteConfig 路由配置
 type RouteConfig struct {
-	Path         string           `yaml:"path"`
-	Method       string           `yaml:"method"`
-	Backends     []BackendConfig  `yaml:"backends"`
-	AuthRequired bool             `yaml:"auth_required"`
-	RateLimit    int              `yaml:"rate_limit"`
-	CacheEnabled bool             `yaml:"cache_enabled"`
-	CacheTTL     time.Duration    `yaml:"cache_ttl"`
-	Timeout      time.Duration    `yaml:"timeout"`
-	Retries      int              `yaml:"retries"`
-	LoadBalancer LoadBalancerType `yaml:"load_balancer"`
-	Middleware   []string         `yaml:"middleware"`
+	ID             string               `yaml:"id"`              // 路由标识，默认由路径生成
+	Path           string               `yaml:"path"`
+	Method         string               `yaml:"method"`
+	Backends       []BackendConfig      `yaml:"backends"`
+	AuthRequired   bool                 `yaml:"auth_required"`
+	RateLimit      int                  `yaml:"rate_limit"`
+	CacheEnabled   bool                 `yaml:"cache_enabled"`
+	CacheTTL       time.Duration        `yaml:"cache_ttl"`
+	Timeout        time.Duration        `yaml:"timeout"`
+	Retries        int                  `yaml:"retries"`         // 失败后的重试次数，默认3，-1表示不重试
+	RetryPolicy    RetryPolicy          `yaml:"retry_policy"`
+	LoadBalancer   LoadBalancerType     `yaml:"load_balancer"`
+	Middleware     []string             `yaml:"middleware"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	MaxBodySize    int64                `yaml:"max_body_size"`   // 请求体最大字节数，0表示不限制
+	RequiredRoles  []string             `yaml:"required_roles"`  // 访问路由需要具有的任一角色
+	RequiredScopes []string             `yaml:"required_scopes"` // 访问路由需要具有的全部权限范围
+	MethodRules    []MethodRule         `yaml:"method_rules"`    // 针对特定方法的额外授权规则
+	// IdentityHeaders 转发给后端的身份头，键为身份字段，值为请求头名称，例如 user_id: X-User-ID
+	IdentityHeaders map[string]string `yaml:"identity_headers"`
+	// RateLimits 路由的多个速率限制，全部满足才放行；rate_limit 相当于名为default的一条限制
+	RateLimits []RateLimitRule `yaml:"rate_limits"`
+	// QuotaGroup 路由计入的配额组，多个路由可以共用一个配额组
+	QuotaGroup string `yaml:"quota_group"`
+	// Concurrency 并发限制和等待队列
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+}
+
+// ConcurrencyConfig 路由的并发限制。超过 max_concurrent 的请求，以及所有后端都达到 max_connections 时的请求，
+// 分别在长度为 queue_size 的队列中等待，队列已满或等待超时时返回503
+type ConcurrencyConfig struct {
+	MaxConcurrent int           `yaml:"max_concurrent"` // 路由同时处理的请求数，0表示不限制
+	QueueSize     int           `yaml:"queue_size"`     // 等待队列的长度，0表示不排队
+	QueueTimeout  time.Duration `yaml:"queue_timeout"`  // 排队等待的最长时间，默认1秒
+	Order         string        `yaml:"order"`          // fifo（默认）或 priority
+	// Priorities order为priority时角色或API密钥等级对应的优先级，取调用方最高的优先级，数值大的先出队，默认为0
+	Priorities map[string]int `yaml:"priorities"`
+}
+
+// RateLimitRule 一条速率限制，Key中任一部分在请求中不存在时该限制不生效。
+// 名称和键相同的限制共享计数，键中不包含route时同名限制在多个路由之间共享
+type RateLimitRule struct {
+	Name   string        `yaml:"name"`   // 策略名，出现在 RateLimit/RateLimit-Policy 响应头中，多条限制时必须配置
+	Limit  int           `yaml:"limit"`  // 每个窗口允许的请求数
+	Window time.Duration `yaml:"window"` // 默认使用 rate_limit.window
+	Burst  int           `yaml:"burst"`  // 默认使用 rate_limit.burst
+	// Plans 按调用方选择的计划，配置后限额、窗口和突发容量由选中的计划决定，
+	// 响应头中的策略名为计划名。没有选中列表中的计划时该限制不生效
+	Plans []string `yaml:"plans"`
+	// Key 限流键的组成部分：route、method、ip、ip_prefix:/24（可附加IPv6前缀，如 ip_prefix:/24,/56）、
+	// user（用户标识，未认证时为客户端IP）、api_key、header:<名称>、claim:<声明路径>，默认为 route 和 user
+	Key []string `yaml:"key"`
+}
+
+// IdentityFields 可以转发给后端的身份字段
+var IdentityFields = []string{"user_id", "username", "email", "roles", "scopes", "api_key_id"}
+
+// MethodRule 针对特定HTTP方法的授权规则，在路由级规则之外额外生效
+type MethodRule struct {
+	Methods        []string `yaml:"methods"`
+	RequiredRoles  []string `yaml:"required_roles"`
+	RequiredScopes []string `yaml:"required_scopes"`
+}
+
+// RequiresAuthorization 检查路由是否配置了授权规则
+func (r RouteConfig) RequiresAuthorization() bool {
+	return len(r.RequiredRoles) > 0 || len(r.RequiredScopes) > 0 || len(r.MethodRules) > 0
+}
+
+// CircuitBreakerConfig 熔断器配置
+type CircuitBreakerConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	ConsecutiveFailures int           `yaml:"consecutive_failures"`   // 连续失败次数阈值
+	ErrorRateThreshold  float64       `yaml:"error_rate_threshold"`   // 窗口内错误率阈值 (0-1)
+	MinRequests         int           `yaml:"min_requests"`           // 计算错误率所需的最少请求数
+	Window              time.Duration `yaml:"window"`                 // 错误率统计窗口
+	OpenTimeout         time.Duration `yaml:"open_timeout"`           // 熔断后进入半开状态前的等待时间
+	HalfOpenMaxRequests int           `yaml:"half_open_max_requests"` // 半开状态允许的探测请求数
+}
+
+// RetryPolicy 重试策略配置
+type RetryPolicy struct {
+	RetryOn            []int         `yaml:"retry_on"`             // 触发重试的上游状态码
+	RetryNonIdempotent bool          `yaml:"retry_non_idempotent"` // 是否允许重试非幂等方法
+	BaseBackoff        time.Duration `yaml:"base_backoff"`         // 初始退避时间
+	MaxBackoff         time.Duration `yaml:"max_backoff"`          // 最大退避时间
+	Budget             time.Duration `yaml:"budget"`               // 单个请求的重试时间预算
+	MaxBufferSize      int64         `yaml:"max_buffer_size"`      // 为重放而缓存的请求体最大字节数
 }
 
 // BackendConfig 后端服务配置
 type BackendConfig struct {
-	URL            string        `yaml:"url"`
-	Weight         int           `yaml:"weight"`
-	MaxConnections int           `yaml:"max_connections"`
-	HealthCheck    HealthCheck   `yaml:"health_check"`
-	Timeout        time.Duration `yaml:"timeout"`
+	URL            string         `yaml:"url"`
+	Weight         int            `yaml:"weight"`
+	MaxConnections int            `yaml:"max_connections"`
+	HealthCheck    HealthCheck    `yaml:"health_check"`
+	Timeout        time.Duration  `yaml:"timeout"`
+	Pool           ConnectionPool `yaml:"pool"`
+	TLS            UpstreamTLS    `yaml:"tls"`
+}
+
+// UpstreamTLS 连接后端的TLS配置，证书文件修改后在下次握手时重新加载
+type UpstreamTLS struct {
+	CAFile             string `yaml:"ca_file"`     // 验证后端证书的CA证书，默认使用系统证书
+	CertFile           string `yaml:"cert_file"`   // 双向TLS的客户端证书
+	KeyFile            string `yaml:"key_file"`    // 双向TLS的客户端私钥
+	ServerName         string `yaml:"server_name"` // SNI和证书验证使用的主机名，默认为后端地址的主机名
+	MinVersion         string `yaml:"min_version"` // 1.2（默认）或 1.3
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ConnectionPool 上游连接池配置
+type ConnectionPool struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"` // 0表示不限制
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	EnableHTTP2         bool          `yaml:"enable_http2"`
 }
 
 // HealthCheck 健康检查配置
@@ -82,6 +237,107 @@ type AuthConfig struct {
 	TokenExpiry   time.Duration `yaml:"token_expiry"`
 	RefreshExpiry time.Duration `yaml:"refresh_expiry"`
 	Issuer        string        `yaml:"issuer"`
+	// SigningKeys 非对称签名密钥，配置后使用其中的签名密钥签发令牌，其余密钥仅用于验证
+	SigningKeys  []SigningKeyConfig `yaml:"signing_keys"`
+	SigningKeyID string             `yaml:"signing_key_id"` // 签发令牌使用的密钥，默认为第一个配置了私钥的密钥
+	// Algorithms 接受的令牌算法，默认为签名密钥的算法，未配置签名密钥时为HS256
+	Algorithms []string `yaml:"algorithms"`
+	// OIDC 外部身份提供方，其签发的令牌通过JWKS验证
+	OIDC []OIDCConfig `yaml:"oidc"`
+	// APIKeys API密钥认证，用于无法完成登录流程的机器客户端
+	APIKeys APIKeyConfig `yaml:"api_keys"`
+	Users   UserStoreConfig `yaml:"users"`
+	Lockout LockoutConfig   `yaml:"lockout"`
+	// IdentitySigning 转发给后端的身份头签名，后端用同一密钥验证请求来自网关
+	IdentitySigning IdentitySigningConfig `yaml:"identity_signing"`
+	// ForwardAuth 外部授权服务，路由通过 forward_auth 中间件启用
+	ForwardAuth ForwardAuthConfig `yaml:"forward_auth"`
+	// ClientCert 将经过验证的客户端证书映射为用户身份
+	ClientCert ClientCertAuthConfig `yaml:"client_cert"`
+}
+
+// ClientCertAuthConfig 客户端证书身份映射，需要监听器配置 client_ca_file
+type ClientCertAuthConfig struct {
+	Enabled     bool                `yaml:"enabled"`
+	UserIDFrom  string              `yaml:"user_id_from"`  // common_name（默认）、san_dns、san_email 或 san_uri
+	RolesFromOU bool                `yaml:"roles_from_ou"` // 使用证书的组织单位作为角色
+	Roles       map[string][]string `yaml:"roles"`         // 按用户标识额外授予的角色
+}
+
+// ForwardAuthConfig 外部授权服务配置
+type ForwardAuthConfig struct {
+	URL             string        `yaml:"url"`              // 授权服务地址，请求以JSON形式POST
+	Timeout         time.Duration `yaml:"timeout"`          // 超时后拒绝请求
+	CacheTTL        time.Duration `yaml:"cache_ttl"`        // 授权结果的缓存时间
+	RequestHeaders  []string      `yaml:"request_headers"`  // 发送给授权服务的请求头
+	IncludeClaims   bool          `yaml:"include_claims"`   // 是否发送认证后的用户身份
+	UpstreamHeaders []string      `yaml:"upstream_headers"` // 授权通过时从授权服务响应复制到上游请求的头
+}
+
+// IdentitySigningConfig 身份头签名配置
+type IdentitySigningConfig struct {
+	Secret string `yaml:"secret"` // HMAC-SHA256密钥，为空时不签名
+	Header string `yaml:"header"` // 签名头名称
+}
+
+// UserStoreConfig 用户存储配置
+type UserStoreConfig struct {
+	Store          string        `yaml:"store"`           // file（默认）、http 或 mock（内置演示用户，需要设置 allow_mock）
+	AllowMock      bool          `yaml:"allow_mock"`      // 允许使用演示用户，仅用于演示和开发
+	File           string        `yaml:"file"`            // YAML或JSON格式的用户文件，默认 configs/users.yaml
+	ReloadInterval time.Duration `yaml:"reload_interval"` // 检查用户文件变化的间隔
+	URL            string        `yaml:"url"`             // 上游用户服务地址
+	Token          string        `yaml:"token"`           // 调用上游用户服务使用的Bearer令牌
+	Timeout        time.Duration `yaml:"timeout"`
+	CacheTTL       time.Duration `yaml:"cache_ttl"` // 上游用户信息的本地缓存时间
+}
+
+// LockoutConfig 登录失败锁定配置，每次锁定的时长在上一次的基础上翻倍
+type LockoutConfig struct {
+	MaxAttempts  int           `yaml:"max_attempts"` // 窗口内连续失败次数达到后锁定，0表示不锁定
+	Window       time.Duration `yaml:"window"`
+	BaseDuration time.Duration `yaml:"base_duration"`
+	MaxDuration  time.Duration `yaml:"max_duration"`
+}
+
+// APIKeyConfig API密钥认证配置
+type APIKeyConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Header     string `yaml:"header"`      // 携带密钥的请求头，默认 X-API-Key
+	QueryParam string `yaml:"query_param"` // 携带密钥的查询参数，为空时不从查询参数读取
+	Store      string `yaml:"store"`       // 密钥存储：cache（默认）或 file
+	File       string `yaml:"file"`        // store 为 file 时的存储文件
+}
+
+// OIDCConfig 外部身份提供方配置
+type OIDCConfig struct {
+	Issuer    string   `yaml:"issuer"`
+	Audiences []string `yaml:"audiences"` // 令牌的aud需要包含其中之一
+	// JWKSURL 公钥地址，与JWKSFile都为空时通过 {issuer}/.well-known/openid-configuration 发现
+	JWKSURL            string        `yaml:"jwks_url"`
+	JWKSFile           string        `yaml:"jwks_file"`
+	Algorithms         []string      `yaml:"algorithms"`
+	ClockSkew          time.Duration `yaml:"clock_skew"`           // 校验exp和nbf时允许的时钟偏差
+	RefreshInterval    time.Duration `yaml:"refresh_interval"`     // 公钥缓存时间
+	MinRefreshInterval time.Duration `yaml:"min_refresh_interval"` // 遇到未知kid时两次刷新的最小间隔
+	Claims             ClaimMapping  `yaml:"claims"`
+}
+
+// ClaimMapping 令牌声明到用户信息的映射，支持 realm_access.roles 这样的嵌套路径
+type ClaimMapping struct {
+	UserID   string `yaml:"user_id"`
+	Username string `yaml:"username"`
+	Email    string `yaml:"email"`
+	Roles    string `yaml:"roles"`
+	Scope    string `yaml:"scope"`
+}
+
+// SigningKeyConfig 令牌签名密钥配置，密钥文件为PEM格式
+type SigningKeyConfig struct {
+	ID             string `yaml:"id"`
+	Algorithm      string `yaml:"algorithm"`        // RS256, ES256, EdDSA 等
+	PrivateKeyFile string `yaml:"private_key_file"` // 私钥文件，可用于签发令牌
+	PublicKeyFile  string `yaml:"public_key_file"`  // 公钥文件，未配置私钥时仅用于验证令牌
 }
 
 // LoggingConfig 日志配置
@@ -101,6 +357,11 @@ type MetricsConfig struct {
 	Port    int    `yaml:"port"`
 }
 
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	PersistRoutes bool `yaml:"persist_routes"` // 通过管理接口修改路由后写回配置文件
+}
+
 // LoadBalancerType 负载均衡类型
 type LoadBalancerType string
 
@@ -111,18 +372,38 @@ const (
 	IPHash        LoadBalancerType = "ip_hash"
 )
 
-// Load 从文件加载配置
+// Load 从文件加载配置。
+// 配置值中的 ${VAR} 和 ${VAR:-default} 引用会被展开，以 file:// 开头的值从对应文件读取，
+// 之后 GATEWAY_ 前缀的环境变量覆盖对应字段，例如 GATEWAY_SERVER_PORT 覆盖 server.port。
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("读取配置文件失败: %w", err)
 	}
 
+	data, unresolved, err := interpolate(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %w", err)
+	}
+
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
 
+	// 环境变量覆盖
+	overridden, err := applyEnvOverrides(&config)
+	if err != nil {
+		return nil, fmt.Errorf("应用环境变量覆盖失败: %w", err)
+	}
+
+	// 被覆盖的字段不再需要原引用
+	for _, ref := range unresolved {
+		if !contains(overridden, ref.path) {
+			config.unresolved = append(config.unresolved, ref)
+		}
+	}
+
 	// 设置默认值
 	setDefaults(&config)
 
@@ -131,11 +412,32 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("配置验证失败: %w", err)
 	}
 
+	config.path = path
 	return &config, nil
 }
 
+// Path 返回配置文件路径，未从文件加载时为空
+func (c *Config) Path() string {
+	return c.path
+}
+
 // setDefaults 设置默认配置值
 func setDefaults(config *Config) {
+	if config.RateLimit.Algorithm == "" {
+		config.RateLimit.Algorithm = "token_bucket"
+	}
+	if config.RateLimit.Window == 0 {
+		config.RateLimit.Window = time.Second
+	}
+	if config.RateLimit.TierClaim == "" {
+		config.RateLimit.TierClaim = "tier"
+	}
+	if config.Quota.TimeZone == "" {
+		config.Quota.TimeZone = "UTC"
+	}
+	if config.Quota.SnapshotInterval == 0 {
+		config.Quota.SnapshotInterval = time.Minute
+	}
 	if config.Server.Port == 0 {
 		config.Server.Port = 8080
 	}
@@ -168,6 +470,32 @@ func setDefaults(config *Config) {
 	if config.Auth.RefreshExpiry == 0 {
 		config.Auth.RefreshExpiry = 7 * 24 * time.Hour
 	}
+	for i := range config.Auth.OIDC {
+		setOIDCDefaults(&config.Auth.OIDC[i])
+	}
+	if config.Auth.APIKeys.Header == "" {
+		config.Auth.APIKeys.Header = "X-API-Key"
+	}
+	if config.Server.TLS.ClientCAFile != "" && config.Server.TLS.ClientAuth == "" {
+		config.Server.TLS.ClientAuth = "require"
+	}
+	if config.Auth.ClientCert.UserIDFrom == "" {
+		config.Auth.ClientCert.UserIDFrom = "common_name"
+	}
+	if config.Auth.ForwardAuth.Timeout == 0 {
+		config.Auth.ForwardAuth.Timeout = 2 * time.Second
+	}
+	if config.Auth.ForwardAuth.CacheTTL == 0 {
+		config.Auth.ForwardAuth.CacheTTL = 5 * time.Second
+	}
+	if config.Auth.IdentitySigning.Header == "" {
+		config.Auth.IdentitySigning.Header = "X-Gateway-Signature"
+	}
+	if config.Auth.APIKeys.Store == "" {
+		config.Auth.APIKeys.Store = "cache"
+	}
+	setUserStoreDefaults(&config.Auth.Users)
+	setLockoutDefaults(&config.Auth.Lockout)
 
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
@@ -186,6 +514,9 @@ func setDefaults(config *Config) {
 	// 设置路由默认值
 	for i := range config.Routes {
 		route := &config.Routes[i]
+		if route.ID == "" {
+			route.ID = RouteID(route.Path)
+		}
 		if route.LoadBalancer == "" {
 			route.LoadBalancer = RoundRobin
 		}
@@ -195,9 +526,34 @@ func setDefaults(config *Config) {
 		if route.Retries == 0 {
 			route.Retries = 3
 		}
+		if len(route.RetryPolicy.RetryOn) == 0 {
+			route.RetryPolicy.RetryOn = []int{502, 503, 504}
+		}
+		if route.RetryPolicy.BaseBackoff == 0 {
+			route.RetryPolicy.BaseBackoff = 50 * time.Millisecond
+		}
+		// 最大退避时间默认1秒，不小于初始退避时间
+		if route.RetryPolicy.MaxBackoff == 0 {
+			route.RetryPolicy.MaxBackoff = time.Second
+			if route.RetryPolicy.BaseBackoff > route.RetryPolicy.MaxBackoff {
+				route.RetryPolicy.MaxBackoff = route.RetryPolicy.BaseBackoff
+			}
+		}
+		if route.RetryPolicy.Budget == 0 {
+			route.RetryPolicy.Budget = 10 * time.Second
+		}
+		if route.RetryPolicy.MaxBufferSize == 0 {
+			route.RetryPolicy.MaxBufferSize = 1 << 20
+		}
+		if route.CircuitBreaker.Enabled {
+			setCircuitBreakerDefaults(&route.CircuitBreaker)
+		}
 		if route.CacheTTL == 0 {
 			route.CacheTTL = 5 * time.Minute
 		}
+		if route.Concurrency.QueueSize > 0 && route.Concurrency.QueueTimeout == 0 {
+			route.Concurrency.QueueTimeout = time.Second
+		}
 
 		// 设置后端服务默认值
 		for j := range route.Backends {
@@ -211,6 +567,7 @@ func setDefaults(config *Config) {
 			if backend.Timeout == 0 {
 				backend.Timeout = 30 * time.Second
 			}
+			SetConnectionPoolDefaults(&backend.Pool)
 			if backend.HealthCheck.Interval == 0 {
 				backend.HealthCheck.Interval = 30 * time.Second
 			}
@@ -224,33 +581,385 @@ func setDefaults(config *Config) {
 	}
 }
 
+// SetConnectionPoolDefaults 设置连接池默认值
+func SetConnectionPoolDefaults(pool *ConnectionPool) {
+	if pool.MaxIdleConns == 0 {
+		pool.MaxIdleConns = 100
+	}
+	if pool.MaxIdleConnsPerHost == 0 {
+		pool.MaxIdleConnsPerHost = 20
+	}
+	if pool.IdleConnTimeout == 0 {
+		pool.IdleConnTimeout = 90 * time.Second
+	}
+}
+
+// setCircuitBreakerDefaults 设置熔断器默认值
+func setCircuitBreakerDefaults(cb *CircuitBreakerConfig) {
+	if cb.ConsecutiveFailures == 0 {
+		cb.ConsecutiveFailures = 5
+	}
+	if cb.ErrorRateThreshold == 0 {
+		cb.ErrorRateThreshold = 0.5
+	}
+	if cb.MinRequests == 0 {
+		cb.MinRequests = 20
+	}
+	if cb.Window == 0 {
+		cb.Window = 10 * time.Second
+	}
+	if cb.OpenTimeout == 0 {
+		cb.OpenTimeout = 30 * time.Second
+	}
+	if cb.HalfOpenMaxRequests == 0 {
+		cb.HalfOpenMaxRequests = 1
+	}
+}
+
+// setOIDCDefaults 设置外部身份提供方的默认值
+func setOIDCDefaults(provider *OIDCConfig) {
+	if len(provider.Algorithms) == 0 {
+		provider.Algorithms = []string{"RS256"}
+	}
+	if provider.ClockSkew == 0 {
+		provider.ClockSkew = 30 * time.Second
+	}
+	if provider.RefreshInterval == 0 {
+		provider.RefreshInterval = time.Hour
+	}
+	if provider.MinRefreshInterval == 0 {
+		provider.MinRefreshInterval = 10 * time.Second
+	}
+
+	claims := &provider.Claims
+	if claims.UserID == "" {
+		claims.UserID = "sub"
+	}
+	if claims.Username == "" {
+		claims.Username = "preferred_username"
+	}
+	if claims.Email == "" {
+		claims.Email = "email"
+	}
+	if claims.Roles == "" {
+		claims.Roles = "roles"
+	}
+	if claims.Scope == "" {
+		claims.Scope = "scope"
+	}
+}
+
+// setUserStoreDefaults 设置用户存储的默认值
+func setUserStoreDefaults(users *UserStoreConfig) {
+	if users.Store == "" {
+		users.Store = "file"
+	}
+	if users.Store == "file" && users.File == "" {
+		users.File = "configs/users.yaml"
+	}
+	if users.ReloadInterval == 0 {
+		users.ReloadInterval = 5 * time.Second
+	}
+	if users.Timeout == 0 {
+		users.Timeout = 5 * time.Second
+	}
+	if users.CacheTTL == 0 {
+		users.CacheTTL = 30 * time.Second
+	}
+}
+
+// setLockoutDefaults 设置登录失败锁定的默认值
+func setLockoutDefaults(lockout *LockoutConfig) {
+	if lockout.MaxAttempts == 0 {
+		lockout.MaxAttempts = 5
+	}
+	if lockout.Window == 0 {
+		lockout.Window = 15 * time.Minute
+	}
+	if lockout.BaseDuration == 0 {
+		lockout.BaseDuration = time.Minute
+	}
+	if lockout.MaxDuration == 0 {
+		lockout.MaxDuration = time.Hour
+	}
+}
+
 // validate 验证配置
 func validate(config *Config) error {
+	if len(config.unresolved) > 0 {
+		ref := config.unresolved[0]
+		return fmt.Errorf("%s: %s", ref.path, ref.message)
+	}
+
 	if config.Server.Port < 1 || config.Server.Port > 65535 {
 		return fmt.Errorf("无效的服务器端口: %d", config.Server.Port)
 	}
 
-	if config.Auth.JWTSecret == "" {
-		return fmt.Errorf("JWT密钥不能为空")
+	if err := validateRateLimitConfig(&config.RateLimit); err != nil {
+		return err
+	}
+	if err := validateQuota(&config.Quota); err != nil {
+		return err
 	}
 
+	if err := validateTLS(&config.Server.TLS); err != nil {
+		return err
+	}
+	if err := validateAuth(&config.Auth); err != nil {
+		return err
+	}
+	if config.Auth.ClientCert.Enabled && config.Server.TLS.ClientCAFile == "" {
+		return fmt.Errorf("客户端证书认证需要配置 server.tls.client_ca_file")
+	}
+
+	ids := make(map[string]bool)
+	paths := make(map[string]bool)
 	for i, route := range config.Routes {
 		if route.Path == "" {
 			return fmt.Errorf("路由 %d 的路径不能为空", i)
 		}
+		if paths[route.Path] {
+			return fmt.Errorf("路由 %d 的路径 %s 重复", i, route.Path)
+		}
+		paths[route.Path] = true
+		if route.ID != "" {
+			if ids[route.ID] {
+				return fmt.Errorf("路由 %d 的标识 %s 重复", i, route.ID)
+			}
+			ids[route.ID] = true
+		}
 		if route.Method == "" {
 			return fmt.Errorf("路由 %d 的方法不能为空", i)
 		}
 		if len(route.Backends) == 0 {
 			return fmt.Errorf("路由 %d 必须至少有一个后端服务", i)
 		}
+		if route.Retries < -1 {
+			return fmt.Errorf("路由 %d 的重试次数不能小于-1", i)
+		}
+		if route.RetryPolicy.MaxBackoff < route.RetryPolicy.BaseBackoff {
+			return fmt.Errorf("路由 %d 的最大退避时间不能小于初始退避时间", i)
+		}
+		if route.MaxBodySize < 0 {
+			return fmt.Errorf("路由 %d 的请求体大小限制不能为负数", i)
+		}
+		if route.CircuitBreaker.ErrorRateThreshold < 0 || route.CircuitBreaker.ErrorRateThreshold > 1 {
+			return fmt.Errorf("路由 %d 的熔断错误率阈值必须在0到1之间", i)
+		}
+		if route.Concurrency.MaxConcurrent < 0 || route.Concurrency.QueueSize < 0 || route.Concurrency.QueueTimeout < 0 {
+			return fmt.Errorf("路由 %d 的并发限制不能为负数", i)
+		}
+		if route.Concurrency.Order != "" && route.Concurrency.Order != "fifo" && route.Concurrency.Order != "priority" {
+			return fmt.Errorf("路由 %d 不支持的排队顺序: %s", i, route.Concurrency.Order)
+		}
+		for j, rule := range route.MethodRules {
+			if len(rule.Methods) == 0 {
+				return fmt.Errorf("路由 %d 的方法规则 %d 必须指定方法", i, j)
+			}
+		}
+		if contains(route.Middleware, "forward_auth") && config.Auth.ForwardAuth.URL == "" {
+			return fmt.Errorf("路由 %d 使用了 forward_auth 中间件，但未配置授权服务地址", i)
+		}
+		if err := validateRateLimits(route, config.RateLimit.Plans); err != nil {
+			return fmt.Errorf("路由 %d: %w", i, err)
+		}
+		if _, exists := config.Quota.Groups[route.QuotaGroup]; route.QuotaGroup != "" && !exists {
+			return fmt.Errorf("路由 %d 的配额组 %s 不存在", i, route.QuotaGroup)
+		}
+		for field, header := range route.IdentityHeaders {
+			if !contains(IdentityFields, field) {
+				return fmt.Errorf("路由 %d 的身份头字段 %s 不支持", i, field)
+			}
+			if header == "" || strings.ContainsAny(header, " :\r\n") {
+				return fmt.Errorf("路由 %d 的身份头 %s 名称无效", i, field)
+			}
+			if strings.EqualFold(header, config.Auth.IdentitySigning.Header) {
+				return fmt.Errorf("路由 %d 的身份头 %s 与签名头重名", i, field)
+			}
+		}
 
+		urls := make(map[string]bool)
 		for j, backend := range route.Backends {
 			if backend.URL == "" {
 				return fmt.Errorf("路由 %d 的后端服务 %d URL不能为空", i, j)
 			}
+			if urls[backend.URL] {
+				return fmt.Errorf("路由 %d 的后端服务 %s 重复", i, backend.URL)
+			}
+			urls[backend.URL] = true
+			if (backend.TLS.CertFile == "") != (backend.TLS.KeyFile == "") {
+				return fmt.Errorf("路由 %d 的后端服务 %s 的客户端证书和私钥必须同时配置", i, backend.URL)
+			}
+			if _, err := ParseTLSVersion(backend.TLS.MinVersion); err != nil {
+				return fmt.Errorf("路由 %d 的后端服务 %s: %w", i, backend.URL, err)
+			}
 		}
 	}
 
 	return nil
 }
+
+// validateQuota 验证配额配置
+func validateQuota(cfg *QuotaConfig) error {
+	if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+		return fmt.Errorf("无效的配额时区 %s: %w", cfg.TimeZone, err)
+	}
+	if cfg.SnapshotInterval < 0 {
+		return fmt.Errorf("配额快照间隔不能为负数")
+	}
+	for name, group := range cfg.Groups {
+		// 配额组名称是计数键的一部分
+		if name == "" || strings.Contains(name, ":") {
+			return fmt.Errorf("配额组名称 %q 无效", name)
+		}
+		if group.Daily < 0 || group.Monthly < 0 {
+			return fmt.Errorf("配额组 %s 的配额不能为负数", name)
+		}
+		if group.Daily == 0 && group.Monthly == 0 {
+			return fmt.Errorf("配额组 %s 需要配置每日或每月配额", name)
+		}
+	}
+	return nil
+}
+
+// validateTLS 验证监听器TLS配置
+func validateTLS(cfg *TLSConfig) error {
+	if !cfg.Enabled {
+		if cfg.ClientCAFile != "" {
+			return fmt.Errorf("配置客户端证书CA需要启用TLS")
+		}
+		return nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return fmt.Errorf("启用TLS需要配置证书和私钥文件")
+	}
+	if cfg.ClientCAFile != "" && cfg.ClientAuth != "require" && cfg.ClientAuth != "verify_if_given" {
+		return fmt.Errorf("不支持的客户端证书验证方式: %s", cfg.ClientAuth)
+	}
+	if _, err := ParseTLSVersion(cfg.MinVersion); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseTLSVersion 解析TLS最低版本，为空时默认为TLS 1.2
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("不支持的TLS版本: %s", version)
+	}
+}
+
+// contains 检查字符串切片是否包含指定值
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAuth 验证认证配置
+func validateAuth(auth *AuthConfig) error {
+	if len(auth.SigningKeys) == 0 || contains(auth.Algorithms, "HS256") {
+		if auth.JWTSecret == "" {
+			return fmt.Errorf("JWT密钥不能为空")
+		}
+	}
+
+	ids := make(map[string]bool)
+	signable := false
+	for i, key := range auth.SigningKeys {
+		if key.ID == "" {
+			return fmt.Errorf("签名密钥 %d 的标识不能为空", i)
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("签名密钥 %d 的标识 %s 重复", i, key.ID)
+		}
+		ids[key.ID] = true
+		if key.Algorithm == "" {
+			return fmt.Errorf("签名密钥 %s 的算法不能为空", key.ID)
+		}
+		if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+			return fmt.Errorf("签名密钥 %s 必须配置私钥或公钥文件", key.ID)
+		}
+		signable = signable || key.PrivateKeyFile != ""
+	}
+	if len(auth.SigningKeys) > 0 && !signable {
+		return fmt.Errorf("签名密钥中至少需要一个配置私钥文件")
+	}
+
+	issuers := make(map[string]bool)
+	for i, provider := range auth.OIDC {
+		if provider.Issuer == "" {
+			return fmt.Errorf("OIDC提供方 %d 的issuer不能为空", i)
+		}
+		if issuers[provider.Issuer] {
+			return fmt.Errorf("OIDC提供方 %s 重复", provider.Issuer)
+		}
+		issuers[provider.Issuer] = true
+		if len(provider.Audiences) == 0 {
+			return fmt.Errorf("OIDC提供方 %s 必须配置audiences", provider.Issuer)
+		}
+		if provider.JWKSURL != "" && provider.JWKSFile != "" {
+			return fmt.Errorf("OIDC提供方 %s 不能同时配置jwks_url和jwks_file", provider.Issuer)
+		}
+	}
+
+	switch auth.APIKeys.Store {
+	case "", "cache":
+	case "file":
+		if auth.APIKeys.File == "" {
+			return fmt.Errorf("API密钥使用文件存储时必须配置file")
+		}
+	default:
+		return fmt.Errorf("无效的API密钥存储: %s", auth.APIKeys.Store)
+	}
+
+	switch auth.Users.Store {
+	case "mock":
+		if !auth.Users.AllowMock {
+			return fmt.Errorf("演示用户存储mock需要设置allow_mock，生产环境请使用file或http")
+		}
+	case "file":
+		if auth.Users.File == "" {
+			return fmt.Errorf("用户使用文件存储时必须配置file")
+		}
+	case "http":
+		if auth.Users.URL == "" {
+			return fmt.Errorf("用户使用http存储时必须配置url")
+		}
+	default:
+		return fmt.Errorf("无效的用户存储: %s", auth.Users.Store)
+	}
+	if auth.Lockout.MaxAttempts < 0 {
+		return fmt.Errorf("登录失败锁定次数不能为负数")
+	}
+
+	switch auth.ClientCert.UserIDFrom {
+	case "", "common_name", "san_dns", "san_email", "san_uri":
+	default:
+		return fmt.Errorf("无效的客户端证书用户标识来源: %s", auth.ClientCert.UserIDFrom)
+	}
+
+	if auth.SigningKeyID != "" {
+		found := false
+		for _, key := range auth.SigningKeys {
+			if key.ID == auth.SigningKeyID {
+				if key.PrivateKeyFile == "" {
+					return fmt.Errorf("签名密钥 %s 未配置私钥文件", key.ID)
+				}
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("签名密钥 %s 不存在", auth.SigningKeyID)
+		}
+	}
+	return nil
+}
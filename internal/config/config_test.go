@@ -0,0 +1,486 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadExpandsEnvironmentVariables(t *testing.T) {
+	t.Setenv("TEST_GATEWAY_PORT", "9090")
+	t.Setenv("TEST_JWT_SECRET", "from-env")
+
+	path := writeConfig(t, `
+server:
+  port: ${TEST_GATEWAY_PORT}
+  read_timeout: ${TEST_READ_TIMEOUT:-15s}
+redis:
+  password: "${TEST_REDIS_PASSWORD:-}"
+auth:
+  jwt_secret: "${TEST_JWT_SECRET}"
+  issuer: "$${LITERAL}"
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 9090, cfg.Server.Port)
+	assert.Equal(t, 15*time.Second, cfg.Server.ReadTimeout)
+	assert.Equal(t, "", cfg.Redis.Password)
+	assert.Equal(t, "from-env", cfg.Auth.JWTSecret)
+	assert.Equal(t, "${LITERAL}", cfg.Auth.Issuer)
+}
+
+func TestLoadReadsSecretFiles(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "jwt_secret")
+	require.NoError(t, os.WriteFile(secret, []byte("from-file\n"), 0600))
+	t.Setenv("TEST_JWT_SECRET_FILE", secret)
+
+	path := writeConfig(t, `
+auth:
+  jwt_secret: "file://${TEST_JWT_SECRET_FILE}"
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", cfg.Auth.JWTSecret)
+
+	path = writeConfig(t, `
+auth:
+  jwt_secret: "file:///nonexistent/jwt_secret"
+`)
+	_, err = Load(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "auth.jwt_secret")
+}
+
+func TestLoadUnresolvedReferenceFailsWithPath(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: "secret"
+routes:
+  - path: /api
+    method: GET
+    backends:
+      - url: "http://${TEST_MISSING_BACKEND_HOST}:3000"
+`)
+
+	_, err := Load(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "routes[0].backends[0].url")
+	assert.Contains(t, err.Error(), "TEST_MISSING_BACKEND_HOST")
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	t.Setenv("GATEWAY_SERVER_PORT", "7070")
+	t.Setenv("GATEWAY_AUTH_JWT_SECRET", "override")
+	t.Setenv("GATEWAY_AUTH_TOKEN_EXPIRY", "1h")
+	t.Setenv("GATEWAY_METRICS_ENABLED", "true")
+
+	path := writeConfig(t, `
+server:
+  port: 8080
+auth:
+  jwt_secret: "${TEST_MISSING_JWT_SECRET}"
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 7070, cfg.Server.Port)
+	assert.Equal(t, "override", cfg.Auth.JWTSecret)
+	assert.Equal(t, time.Hour, cfg.Auth.TokenExpiry)
+	assert.True(t, cfg.Metrics.Enabled)
+
+	t.Setenv("GATEWAY_SERVER_PORT", "not-a-port")
+	_, err = Load(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "GATEWAY_SERVER_PORT")
+}
+
+func TestSaveRoutesPreservesOtherSettings(t *testing.T) {
+	t.Setenv("TEST_JWT_SECRET", "from-env")
+	path := writeConfig(t, `
+# 认证配置
+auth:
+  jwt_secret: "${TEST_JWT_SECRET}"
+routes:
+  - path: /api
+    method: GET
+    backends:
+      - url: "http://localhost:3000"
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	cfg.Routes[0].Timeout = 5 * time.Second
+	cfg.Routes = append(cfg.Routes, RouteConfig{
+		Path:     "/orders",
+		Method:   "GET",
+		Backends: []BackendConfig{{URL: "http://localhost:3001"}},
+	})
+	require.NoError(t, SaveRoutes(path, cfg.Routes))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "${TEST_JWT_SECRET}")
+	assert.Contains(t, string(data), "# 认证配置")
+
+	saved, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, saved.Routes, 2)
+	assert.Equal(t, 5*time.Second, saved.Routes[0].Timeout)
+	assert.Equal(t, "orders", saved.Routes[1].ID)
+	assert.Equal(t, "from-env", saved.Auth.JWTSecret)
+}
+
+func TestRetryDefaults(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+routes:
+  - path: /api/v1/users
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    retry_policy:
+      base_backoff: 2s
+  - path: /api/v1/orders
+    method: POST
+    backends:
+      - url: http://localhost:3002
+    retries: -1
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, cfg.Routes[0].Retries)
+	assert.Equal(t, 2*time.Second, cfg.Routes[0].RetryPolicy.MaxBackoff)
+	assert.Equal(t, -1, cfg.Routes[1].Retries)
+	assert.Equal(t, time.Second, cfg.Routes[1].RetryPolicy.MaxBackoff)
+
+	cfg.Routes[1].Retries = -2
+	assert.ErrorContains(t, validate(cfg), "重试次数不能小于-1")
+}
+
+func TestValidateSigningKeys(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  signing_keys:
+    - id: "2026-01"
+      algorithm: RS256
+      private_key_file: /run/secrets/jwt_rs256.pem
+    - id: "2025-12"
+      algorithm: ES256
+      public_key_file: /run/secrets/jwt_es256.pub.pem
+`)
+
+	// 配置签名密钥时不再要求JWT密钥
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Auth.SigningKeys, 2)
+
+	cfg.Auth.SigningKeyID = "2025-12"
+	assert.ErrorContains(t, cfg.Validate(), "未配置私钥文件")
+
+	cfg.Auth.SigningKeyID = "missing"
+	assert.ErrorContains(t, cfg.Validate(), "不存在")
+
+	cfg.Auth.SigningKeyID = ""
+	cfg.Auth.Algorithms = []string{"RS256", "HS256"}
+	assert.ErrorContains(t, cfg.Validate(), "JWT密钥不能为空")
+}
+
+func TestValidateIdentityHeaders(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+routes:
+  - path: /api/v1/users
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    identity_headers:
+      user_id: X-User-ID
+      roles: X-User-Roles
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "X-Gateway-Signature", cfg.Auth.IdentitySigning.Header)
+
+	cfg.Routes[0].IdentityHeaders["password"] = "X-Password"
+	assert.ErrorContains(t, cfg.Validate(), "不支持")
+
+	delete(cfg.Routes[0].IdentityHeaders, "password")
+	cfg.Routes[0].IdentityHeaders["username"] = "x-gateway-signature"
+	assert.ErrorContains(t, cfg.Validate(), "签名头")
+}
+
+func TestValidateForwardAuth(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+routes:
+  - path: /api/v1/users
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    middleware: [forward_auth]
+`)
+
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "未配置授权服务地址")
+
+	t.Setenv("GATEWAY_AUTH_FORWARD_AUTH_URL", "http://localhost:9000/authorize")
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, cfg.Auth.ForwardAuth.Timeout)
+	assert.Equal(t, 5*time.Second, cfg.Auth.ForwardAuth.CacheTTL)
+}
+
+func TestValidateUserStore(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+`)
+
+	// 默认使用用户文件
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "file", cfg.Auth.Users.Store)
+	assert.Equal(t, "configs/users.yaml", cfg.Auth.Users.File)
+
+	// 演示用户需要显式允许
+	t.Setenv("GATEWAY_AUTH_USERS_STORE", "mock")
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "allow_mock")
+
+	t.Setenv("GATEWAY_AUTH_USERS_ALLOW_MOCK", "true")
+	cfg, err = Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "mock", cfg.Auth.Users.Store)
+}
+
+func TestValidateTLS(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Port: 8443, TLS: TLSConfig{Enabled: true, CertFile: "server.pem", KeyFile: "server-key.pem", ClientCAFile: "ca.pem"}},
+		Auth:   AuthConfig{JWTSecret: "test-secret", ClientCert: ClientCertAuthConfig{Enabled: true}},
+		Routes: []RouteConfig{{
+			Path:     "/api/v1/users",
+			Method:   "GET",
+			Backends: []BackendConfig{{URL: "https://localhost:3001", TLS: UpstreamTLS{CAFile: "ca.pem"}}},
+		}},
+	}
+	setDefaults(cfg)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "require", cfg.Server.TLS.ClientAuth)
+	assert.Equal(t, "common_name", cfg.Auth.ClientCert.UserIDFrom)
+
+	cfg.Server.TLS.MinVersion = "1.1"
+	assert.ErrorContains(t, cfg.Validate(), "不支持的TLS版本")
+	cfg.Server.TLS.MinVersion = ""
+
+	cfg.Routes[0].Backends[0].TLS.CertFile = "client.pem"
+	assert.ErrorContains(t, cfg.Validate(), "必须同时配置")
+	cfg.Routes[0].Backends[0].TLS.CertFile = ""
+
+	cfg.Server.TLS.ClientCAFile = ""
+	assert.ErrorContains(t, cfg.Validate(), "client_ca_file")
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+routes:
+  - path: /api/v1/users
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    rate_limit: 100
+    rate_limits:
+      - name: tenant
+        limit: 1000
+        window: 1m
+        key: [header:X-Tenant]
+      - name: network
+        limit: 50
+        key: [route, ip_prefix:/24]
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	rules := cfg.Routes[0].RateLimitRules()
+	require.Len(t, rules, 3)
+	assert.Equal(t, RateLimitRule{Name: "default", Limit: 100, Key: DefaultRateLimitKey}, rules[0])
+	assert.Equal(t, "tenant", rules[1].Name)
+	assert.Equal(t, time.Minute, rules[1].Window)
+
+	route := cfg.Routes[0]
+	route.RateLimit = 0
+	route.RateLimits = []RateLimitRule{{Limit: 10}}
+	assert.Equal(t, []RateLimitRule{{Name: "default", Limit: 10, Key: DefaultRateLimitKey}}, route.RateLimitRules())
+
+	tests := []struct {
+		rules []RateLimitRule
+		err   string
+	}{
+		{[]RateLimitRule{{Limit: 10}, {Limit: 20}}, "需要名称"},
+		{[]RateLimitRule{{Name: "a", Limit: 10}, {Name: "a", Limit: 20}}, "名称 a 重复"},
+		{[]RateLimitRule{{Name: "a"}}, "限额必须大于0"},
+		{[]RateLimitRule{{Name: "a", Limit: 1, Key: []string{"cookie:session"}}}, "不支持的限流键"},
+		{[]RateLimitRule{{Name: "a", Limit: 1, Key: []string{"header:"}}}, "缺少名称"},
+		{[]RateLimitRule{{Name: "a", Limit: 1, Key: []string{"ip_prefix:24"}}}, "无效的前缀长度"},
+		{[]RateLimitRule{{Name: "a", Limit: 1, Key: []string{"ip_prefix:/24,/129"}}}, "无效的前缀长度"},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		invalid.Routes = []RouteConfig{route}
+		invalid.Routes[0].RateLimits = tt.rules
+		assert.ErrorContains(t, validate(&invalid), tt.err)
+	}
+}
+
+func TestValidateRateLimitPlans(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+rate_limit:
+  window: 1m
+  anonymous_plan: free
+  default_plan: free
+  plans:
+    free:
+      rate: 10
+    pro:
+      rate: 1000
+      burst: 100
+      algorithm: gcra
+      roles: [pro]
+routes:
+  - path: /api/v1/users
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    rate_limits:
+      - plans: [free, pro]
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "tier", cfg.RateLimit.TierClaim)
+	assert.Equal(t, RateLimitPlan{Rate: 1000, Burst: 100, Algorithm: "gcra", Roles: []string{"pro"}}, cfg.RateLimit.Plans["pro"])
+	rules := cfg.Routes[0].RateLimitRules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, RateLimitRule{Name: "default", Plans: []string{"free", "pro"}, Key: DefaultRateLimitKey}, rules[0])
+
+	tests := []struct {
+		modify func(*Config)
+		err    string
+	}{
+		{func(c *Config) { c.RateLimit.Plans["free"] = RateLimitPlan{} }, "计划 free 的限额必须大于0"},
+		{func(c *Config) { c.RateLimit.Plans["free"] = RateLimitPlan{Rate: 1, Algorithm: "leaky"} }, "不支持的算法"},
+		{func(c *Config) { c.RateLimit.Plans["a;b"] = RateLimitPlan{Rate: 1} }, "名称 \"a;b\" 无效"},
+		{func(c *Config) { c.RateLimit.AnonymousPlan = "basic" }, "计划 basic 不存在"},
+		{func(c *Config) { c.Routes[0].RateLimits[0].Plans = []string{"enterprise"} }, "引用的计划 enterprise 不存在"},
+		{func(c *Config) { c.Routes[0].RateLimits[0].Limit = 5 }, "使用计划时不能配置限额"},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		invalid.RateLimit.Plans = map[string]RateLimitPlan{}
+		for name, plan := range cfg.RateLimit.Plans {
+			invalid.RateLimit.Plans[name] = plan
+		}
+		invalid.Routes = []RouteConfig{cfg.Routes[0]}
+		invalid.Routes[0].RateLimits = []RateLimitRule{{Plans: []string{"free", "pro"}}}
+		tt.modify(&invalid)
+		assert.ErrorContains(t, validate(&invalid), tt.err)
+	}
+}
+
+func TestValidateQuota(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+quota:
+  snapshot_file: /tmp/quotas.json
+  groups:
+    orders:
+      daily: 5000
+      monthly: 100000
+routes:
+  - path: /api/v1/orders
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    quota_group: orders
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "UTC", cfg.Quota.TimeZone)
+	assert.Equal(t, time.Minute, cfg.Quota.SnapshotInterval)
+	assert.Equal(t, QuotaGroup{Daily: 5000, Monthly: 100000}, cfg.Quota.Groups["orders"])
+
+	tests := []struct {
+		modify func(*Config)
+		err    string
+	}{
+		{func(c *Config) { c.Quota.TimeZone = "Mars/Olympus" }, "无效的配额时区"},
+		{func(c *Config) { c.Quota.Groups = map[string]QuotaGroup{"orders": {}} }, "需要配置每日或每月配额"},
+		{func(c *Config) { c.Quota.Groups = map[string]QuotaGroup{"orders": {Daily: -1, Monthly: 1}} }, "配额不能为负数"},
+		{func(c *Config) { c.Quota.Groups = map[string]QuotaGroup{"a:b": {Daily: 1}} }, "名称 \"a:b\" 无效"},
+		{func(c *Config) { c.Routes[0].QuotaGroup = "reports" }, "配额组 reports 不存在"},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		invalid.Routes = []RouteConfig{cfg.Routes[0]}
+		tt.modify(&invalid)
+		assert.ErrorContains(t, validate(&invalid), tt.err)
+	}
+}
+
+func TestValidateConcurrency(t *testing.T) {
+	path := writeConfig(t, `
+auth:
+  jwt_secret: test-secret
+routes:
+  - path: /api/v1/orders
+    method: GET
+    backends:
+      - url: http://localhost:3001
+    concurrency:
+      max_concurrent: 50
+      queue_size: 100
+      order: priority
+      priorities:
+        admin: 10
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, cfg.Routes[0].Concurrency.QueueTimeout)
+	assert.Equal(t, 10, cfg.Routes[0].Concurrency.Priorities["admin"])
+
+	tests := []struct {
+		modify func(*ConcurrencyConfig)
+		err    string
+	}{
+		{func(c *ConcurrencyConfig) { c.MaxConcurrent = -1 }, "并发限制不能为负数"},
+		{func(c *ConcurrencyConfig) { c.QueueTimeout = -time.Second }, "并发限制不能为负数"},
+		{func(c *ConcurrencyConfig) { c.Order = "lifo" }, "不支持的排队顺序: lifo"},
+	}
+	for _, tt := range tests {
+		invalid := *cfg
+		invalid.Routes = []RouteConfig{cfg.Routes[0]}
+		tt.modify(&invalid.Routes[0].Concurrency)
+		assert.ErrorContains(t, validate(&invalid), tt.err)
+	}
+}
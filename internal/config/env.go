@@ -0,0 +1,235 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvOverridePrefix 覆盖配置字段的环境变量前缀，例如 GATEWAY_SERVER_PORT
+	EnvOverridePrefix = "GATEWAY_"
+
+	// secretFilePrefix 从文件读取配置值的前缀
+	secretFilePrefix = "file://"
+)
+
+// envReference 匹配 ${VAR}、${VAR:-default} 以及转义的 $${...}
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// unresolvedReference 未能解析的配置引用
+type unresolvedReference struct {
+	path    string
+	message string
+}
+
+// interpolate 展开YAML中所有字符串值里的环境变量引用和 file:// 密钥文件引用，
+// 返回展开后的YAML和未能解析的引用
+func interpolate(data []byte) ([]byte, []unresolvedReference, error) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return nil, nil, err
+	}
+	if root.Kind == 0 {
+		return data, nil, nil
+	}
+
+	var unresolved []unresolvedReference
+	interpolateNode(&root, "", &unresolved)
+
+	out, err := yamlv3.Marshal(&root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, unresolved, nil
+}
+
+// interpolateNode 递归展开节点中的引用，path为节点在配置中的路径
+func interpolateNode(node *yamlv3.Node, path string, unresolved *[]unresolvedReference) {
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		for _, child := range node.Content {
+			interpolateNode(child, path, unresolved)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			interpolateNode(node.Content[i+1], key, unresolved)
+		}
+	case yamlv3.SequenceNode:
+		for i, child := range node.Content {
+			interpolateNode(child, fmt.Sprintf("%s[%d]", path, i), unresolved)
+		}
+	case yamlv3.ScalarNode:
+		if node.Tag != "!!str" {
+			return
+		}
+		value, changed, err := expandValue(node.Value)
+		if err != nil {
+			*unresolved = append(*unresolved, unresolvedReference{path: path, message: err.Error()})
+			return
+		}
+		if changed {
+			// 清除标签和引号，使展开后的值按目标字段类型解析
+			node.Value = value
+			node.Tag = ""
+			node.Style = 0
+		}
+	}
+}
+
+// expandValue 展开单个配置值中的环境变量引用，展开结果以 file:// 开头时读取对应文件
+func expandValue(value string) (string, bool, error) {
+	if !strings.Contains(value, "$") && !strings.HasPrefix(value, secretFilePrefix) {
+		return value, false, nil
+	}
+
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+
+		match := envReference.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(match[1]); ok && (v != "" || match[2] == "") {
+			return v
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		missing = append(missing, match[1])
+		return ""
+	})
+
+	if len(missing) > 0 {
+		return "", false, fmt.Errorf("环境变量 %s 未设置", strings.Join(missing, ", "))
+	}
+
+	expanded, err := readSecretFile(expanded)
+	if err != nil {
+		return "", false, err
+	}
+	return expanded, expanded != value, nil
+}
+
+// readSecretFile 读取 file:// 指向的密钥文件，去除末尾换行
+func readSecretFile(value string) (string, error) {
+	if !strings.HasPrefix(value, secretFilePrefix) {
+		return value, nil
+	}
+
+	path := strings.TrimPrefix(value, secretFilePrefix)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// applyEnvOverrides 使用 GATEWAY_ 前缀的环境变量覆盖配置字段，
+// 变量名由字段的yaml路径转换为大写并以下划线连接，例如 auth.jwt_secret 对应 GATEWAY_AUTH_JWT_SECRET。
+// 返回被覆盖的字段路径。
+func applyEnvOverrides(config *Config) ([]string, error) {
+	var overridden []string
+	err := applyEnvOverridesTo(reflect.ValueOf(config).Elem(), "", &overridden)
+	return overridden, err
+}
+
+// applyEnvOverridesTo 递归覆盖结构体字段，切片中的结构体不支持覆盖
+func applyEnvOverridesTo(v reflect.Value, path string, overridden *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnvOverridesTo(fv, fieldPath, overridden); err != nil {
+				return err
+			}
+			continue
+		}
+
+		envName := EnvOverridePrefix + strings.ToUpper(strings.ReplaceAll(fieldPath, ".", "_"))
+		raw, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+
+		raw, err := readSecretFile(raw)
+		if err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %w", envName, err)
+		}
+		if err := setFieldFromString(fv, raw); err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %w", envName, err)
+		}
+		*overridden = append(*overridden, fieldPath)
+	}
+	return nil
+}
+
+// setFieldFromString 将字符串转换为字段类型并赋值
+func setFieldFromString(fv reflect.Value, raw string) error {
+	if fv.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持的字段类型 %s", fv.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items).Convert(fv.Type()))
+	default:
+		return fmt.Errorf("不支持的字段类型 %s", fv.Type())
+	}
+	return nil
+}
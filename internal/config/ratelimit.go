@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultRateLimitKey 未配置键时的限流键：同一路由内按用户（未认证时按客户端IP）计数
+var DefaultRateLimitKey = []string{"route", "user"}
+
+// DefaultRateLimitName 只有一条限制且未命名时使用的策略名
+const DefaultRateLimitName = "default"
+
+// RateLimitKeyPart 解析后的限流键组成部分
+type RateLimitKeyPart struct {
+	Kind string // route、method、ip、ip_prefix、user、api_key、header 或 claim
+	Arg  string // header的请求头名称或claim的声明路径
+	// IPv4Prefix、IPv6Prefix ip_prefix的前缀长度，未配置IPv6前缀时为64
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// ParseRateLimitKey 解析限流键
+func ParseRateLimitKey(parts []string) ([]RateLimitKeyPart, error) {
+	result := make([]RateLimitKeyPart, 0, len(parts))
+	for _, part := range parts {
+		kind, arg, _ := strings.Cut(part, ":")
+		keyPart := RateLimitKeyPart{Kind: kind, Arg: arg}
+
+		switch kind {
+		case "route", "method", "ip", "user", "api_key":
+			if arg != "" {
+				return nil, fmt.Errorf("限流键 %s 不需要参数", part)
+			}
+		case "header", "claim":
+			if arg == "" {
+				return nil, fmt.Errorf("限流键 %s 缺少名称", part)
+			}
+		case "ip_prefix":
+			keyPart.Arg = ""
+			v4, v6, _ := strings.Cut(arg, ",")
+			var err error
+			if keyPart.IPv4Prefix, err = parsePrefixLength(v4, 32); err != nil {
+				return nil, fmt.Errorf("限流键 %s: %w", part, err)
+			}
+			keyPart.IPv6Prefix = 64
+			if v6 != "" {
+				if keyPart.IPv6Prefix, err = parsePrefixLength(v6, 128); err != nil {
+					return nil, fmt.Errorf("限流键 %s: %w", part, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("不支持的限流键: %s", part)
+		}
+		result = append(result, keyPart)
+	}
+	return result, nil
+}
+
+// parsePrefixLength 解析 /24 形式的前缀长度
+func parsePrefixLength(value string, max int) (int, error) {
+	bits, err := strconv.Atoi(strings.TrimPrefix(value, "/"))
+	if err != nil || !strings.HasPrefix(value, "/") || bits < 0 || bits > max {
+		return 0, fmt.Errorf("无效的前缀长度 %q", value)
+	}
+	return bits, nil
+}
+
+// RateLimitRules 返回路由生效的速率限制，rate_limit 转换为名为default的限制，并填充默认的名称和键
+func (r RouteConfig) RateLimitRules() []RateLimitRule {
+	rules := make([]RateLimitRule, 0, len(r.RateLimits)+1)
+	if r.RateLimit > 0 {
+		rules = append(rules, RateLimitRule{Name: DefaultRateLimitName, Limit: r.RateLimit})
+	}
+	rules = append(rules, r.RateLimits...)
+
+	for i := range rules {
+		if rules[i].Name == "" && len(rules) == 1 {
+			rules[i].Name = DefaultRateLimitName
+		}
+		if len(rules[i].Key) == 0 {
+			rules[i].Key = DefaultRateLimitKey
+		}
+	}
+	return rules
+}
+
+// validRateLimitAlgorithms 支持的速率限制算法
+var validRateLimitAlgorithms = []string{"token_bucket", "gcra", "sliding_window", "sliding_window_counter", "fixed_window"}
+
+// validateRateLimitConfig 验证全局速率限制配置和计划
+func validateRateLimitConfig(cfg *RateLimitConfig) error {
+	if cfg.Algorithm != "" && !contains(validRateLimitAlgorithms, cfg.Algorithm) {
+		return fmt.Errorf("不支持的速率限制算法: %s", cfg.Algorithm)
+	}
+	if cfg.Window < 0 || cfg.Burst < 0 {
+		return fmt.Errorf("速率限制的窗口和突发容量不能为负数")
+	}
+
+	for name, plan := range cfg.Plans {
+		if name == "" || strings.ContainsAny(name, "\",;\\") {
+			return fmt.Errorf("速率限制计划名称 %q 无效", name)
+		}
+		if plan.Rate <= 0 {
+			return fmt.Errorf("速率限制计划 %s 的限额必须大于0", name)
+		}
+		if plan.Window < 0 || plan.Burst < 0 {
+			return fmt.Errorf("速率限制计划 %s 的窗口和突发容量不能为负数", name)
+		}
+		if plan.Algorithm != "" && !contains(validRateLimitAlgorithms, plan.Algorithm) {
+			return fmt.Errorf("速率限制计划 %s 不支持的算法: %s", name, plan.Algorithm)
+		}
+	}
+	for _, name := range []string{cfg.DefaultPlan, cfg.AnonymousPlan} {
+		if _, exists := cfg.Plans[name]; name != "" && !exists {
+			return fmt.Errorf("速率限制计划 %s 不存在", name)
+		}
+	}
+	return nil
+}
+
+// validateRateLimits 验证路由的速率限制
+func validateRateLimits(route RouteConfig, plans map[string]RateLimitPlan) error {
+	if route.RateLimit < 0 {
+		return fmt.Errorf("速率限制不能为负数")
+	}
+
+	names := make(map[string]bool)
+	for j, rule := range route.RateLimitRules() {
+		if rule.Name == "" {
+			return fmt.Errorf("第 %d 条速率限制需要名称", j)
+		}
+		if strings.ContainsAny(rule.Name, "\",;\\") {
+			return fmt.Errorf("速率限制名称 %s 无效", rule.Name)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("速率限制名称 %s 重复", rule.Name)
+		}
+		names[rule.Name] = true
+		for _, plan := range rule.Plans {
+			if _, exists := plans[plan]; !exists {
+				return fmt.Errorf("速率限制 %s 引用的计划 %s 不存在", rule.Name, plan)
+			}
+		}
+		if len(rule.Plans) > 0 && (rule.Limit != 0 || rule.Window != 0 || rule.Burst != 0) {
+			return fmt.Errorf("速率限制 %s 使用计划时不能配置限额、窗口和突发容量", rule.Name)
+		}
+		if len(rule.Plans) == 0 && rule.Limit <= 0 {
+			return fmt.Errorf("速率限制 %s 的限额必须大于0", rule.Name)
+		}
+		if rule.Window < 0 || rule.Burst < 0 {
+			return fmt.Errorf("速率限制 %s 的窗口和突发容量不能为负数", rule.Name)
+		}
+		if _, err := ParseRateLimitKey(rule.Key); err != nil {
+			return fmt.Errorf("速率限制 %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// redactedValue 脱敏后的密钥占位符
+const redactedValue = "******"
+
+// RouteID 根据路由路径生成路由标识，例如 /api/v1/users 生成 api-v1-users
+func RouteID(path string) string {
+	id := strings.ReplaceAll(strings.Trim(path, "/"), "/", "-")
+	if id == "" {
+		return "root"
+	}
+	return id
+}
+
+// Clone 深拷贝配置
+func (c *Config) Clone() (*Config, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone Config
+	if err := yaml.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	clone.path = c.path
+	return &clone, nil
+}
+
+// Validate 为运行时修改的配置补全默认值并使用与加载时相同的规则校验
+func (c *Config) Validate() error {
+	setDefaults(c)
+	return validate(c)
+}
+
+// Redacted 返回隐藏密钥后的配置副本
+func (c *Config) Redacted() (*Config, error) {
+	clone, err := c.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	redact(&clone.Auth.JWTSecret)
+	redact(&clone.Redis.Password)
+	redact(&clone.Auth.Users.Token)
+	redact(&clone.Auth.IdentitySigning.Secret)
+	return clone, nil
+}
+
+// redact 隐藏非空的密钥
+func redact(value *string) {
+	if *value != "" {
+		*value = redactedValue
+	}
+}
+
+// ToPlain 将配置结构转换为以yaml字段名为键的通用结构，用于JSON输出
+func ToPlain(v interface{}) (interface{}, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	if err := yamlv3.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ParseRoute 解析YAML或JSON格式的路由配置，时间字段使用 30s 这样的格式
+func ParseRoute(data []byte) (RouteConfig, error) {
+	var route RouteConfig
+	if err := yaml.Unmarshal(data, &route); err != nil {
+		return RouteConfig{}, fmt.Errorf("解析路由配置失败: %w", err)
+	}
+	return route, nil
+}
+
+// ParseBackend 解析YAML或JSON格式的后端服务配置
+func ParseBackend(data []byte) (BackendConfig, error) {
+	var backend BackendConfig
+	if err := yaml.Unmarshal(data, &backend); err != nil {
+		return BackendConfig{}, fmt.Errorf("解析后端服务配置失败: %w", err)
+	}
+	return backend, nil
+}
+
+// SaveRoutes 将路由配置写回配置文件，文件中的其他内容（包括环境变量引用和注释）保持不变。
+// 路由中已展开的环境变量引用会以展开后的值写入。
+func SaveRoutes(path string, routes []RouteConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("解析配置文件失败: %w", err)
+	}
+	if root.Kind != yamlv3.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yamlv3.MappingNode {
+		return fmt.Errorf("配置文件格式无效")
+	}
+
+	// 使用yaml.v2编码以保持时间字段的可读格式
+	encoded, err := yaml.Marshal(routes)
+	if err != nil {
+		return err
+	}
+	var routesNode yamlv3.Node
+	if err := yamlv3.Unmarshal(encoded, &routesNode); err != nil {
+		return err
+	}
+
+	mapping := root.Content[0]
+	replaced := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "routes" {
+			mapping.Content[i+1] = routesNode.Content[0]
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		mapping.Content = append(mapping.Content,
+			&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "routes"},
+			routesNode.Content[0])
+	}
+
+	out, err := yamlv3.Marshal(&root)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免写入过程中被读取到不完整的配置
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// Watcher 轮询配置文件内容，文件变化时触发回调
+type Watcher struct {
+	path     string
+	interval time.Duration
+	checksum [sha256.Size]byte
+}
+
+// NewWatcher 创建配置文件监听器
+func NewWatcher(path string, interval time.Duration) *Watcher {
+	w := &Watcher{
+		path:     path,
+		interval: interval,
+	}
+	w.checksum, _ = w.sum()
+	return w
+}
+
+// Start 开始监听配置文件，直到ctx取消
+func (w *Watcher) Start(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checksum, err := w.sum()
+			if err != nil || checksum == w.checksum {
+				continue
+			}
+			w.checksum = checksum
+			onChange()
+		}
+	}
+}
+
+// sum 计算配置文件内容的校验和
+func (w *Watcher) sum() ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,400 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/config"
+	"api-gateway/internal/loadbalancer"
+	"api-gateway/internal/logger"
+)
+
+var (
+	// errRouteNotFound 路由不存在
+	errRouteNotFound = errors.New("路由未找到")
+	// errBackendNotFound 后端服务不存在
+	errBackendNotFound = errors.New("后端服务未找到")
+	// errBackendExists 后端服务已存在
+	errBackendExists = errors.New("后端服务已存在")
+)
+
+// configHandler 返回当前生效的配置，密钥已隐藏
+func (g *Gateway) configHandler(c *gin.Context) {
+	cfg, version, loadedAt := g.currentTable().snapshot()
+
+	redacted, err := cfg.Redacted()
+	if err != nil {
+		logger.Errorf("导出配置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+	view, err := config.ToPlain(redacted)
+	if err != nil {
+		logger.Errorf("导出配置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version":   version,
+		"loaded_at": loadedAt,
+		"config":    view,
+	})
+}
+
+// listRoutesHandler 列出所有路由
+func (g *Gateway) listRoutesHandler(c *gin.Context) {
+	cfg, _, _ := g.currentTable().snapshot()
+	writeConfigValue(c, http.StatusOK, cfg.Routes)
+}
+
+// getRouteHandler 获取单个路由
+func (g *Gateway) getRouteHandler(c *gin.Context) {
+	cfg, _, _ := g.currentTable().snapshot()
+	index := findRoute(cfg, c.Param("id"))
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errRouteNotFound.Error()})
+		return
+	}
+	writeConfigValue(c, http.StatusOK, cfg.Routes[index])
+}
+
+// createRouteHandler 创建路由
+func (g *Gateway) createRouteHandler(c *gin.Context) {
+	route, ok := bindRoute(c)
+	if !ok {
+		return
+	}
+
+	cfg, err := g.mutateConfig(func(cfg *config.Config) error {
+		cfg.Routes = append(cfg.Routes, route)
+		return nil
+	}, g.reload)
+	if err != nil {
+		writeMutationError(c, err)
+		return
+	}
+
+	writeConfigValue(c, http.StatusCreated, cfg.Routes[len(cfg.Routes)-1])
+}
+
+// updateRouteHandler 替换路由配置
+func (g *Gateway) updateRouteHandler(c *gin.Context) {
+	route, ok := bindRoute(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	var index int
+	cfg, err := g.mutateConfig(func(cfg *config.Config) error {
+		index = findRoute(cfg, id)
+		if index < 0 {
+			return errRouteNotFound
+		}
+		if route.ID == "" {
+			route.ID = id
+		}
+		cfg.Routes[index] = route
+		return nil
+	}, g.reload)
+	if err != nil {
+		writeMutationError(c, err)
+		return
+	}
+
+	writeConfigValue(c, http.StatusOK, cfg.Routes[index])
+}
+
+// deleteRouteHandler 删除路由
+func (g *Gateway) deleteRouteHandler(c *gin.Context) {
+	id := c.Param("id")
+	_, err := g.mutateConfig(func(cfg *config.Config) error {
+		index := findRoute(cfg, id)
+		if index < 0 {
+			return errRouteNotFound
+		}
+		cfg.Routes = append(cfg.Routes[:index], cfg.Routes[index+1:]...)
+		return nil
+	}, g.reload)
+	if err != nil {
+		writeMutationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "路由已删除"})
+}
+
+// createBackendHandler 为路由添加后端服务
+func (g *Gateway) createBackendHandler(c *gin.Context) {
+	backend, ok := bindBackend(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	_, err := g.mutateConfig(func(cfg *config.Config) error {
+		index := findRoute(cfg, id)
+		if index < 0 {
+			return errRouteNotFound
+		}
+		if findRouteBackend(cfg.Routes[index], backend.URL) >= 0 {
+			return errBackendExists
+		}
+		cfg.Routes[index].Backends = append(cfg.Routes[index].Backends, backend)
+		return nil
+	}, g.applyBackendChanges(id))
+	if err != nil {
+		writeMutationError(c, err)
+		return
+	}
+
+	writeConfigValue(c, http.StatusCreated, backend)
+}
+
+// updateBackendHandler 按URL替换路由的后端服务配置
+func (g *Gateway) updateBackendHandler(c *gin.Context) {
+	backend, ok := bindBackend(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	_, err := g.mutateConfig(func(cfg *config.Config) error {
+		index := findRoute(cfg, id)
+		if index < 0 {
+			return errRouteNotFound
+		}
+		backendIndex := findRouteBackend(cfg.Routes[index], backend.URL)
+		if backendIndex < 0 {
+			return errBackendNotFound
+		}
+		cfg.Routes[index].Backends[backendIndex] = backend
+		return nil
+	}, g.applyBackendChanges(id))
+	if err != nil {
+		writeMutationError(c, err)
+		return
+	}
+
+	writeConfigValue(c, http.StatusOK, backend)
+}
+
+// deleteBackendHandler 按URL删除路由的后端服务
+func (g *Gateway) deleteBackendHandler(c *gin.Context) {
+	backendURL := c.Query("url")
+	if backendURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少后端服务URL"})
+		return
+	}
+
+	id := c.Param("id")
+	_, err := g.mutateConfig(func(cfg *config.Config) error {
+		index := findRoute(cfg, id)
+		if index < 0 {
+			return errRouteNotFound
+		}
+		backendIndex := findRouteBackend(cfg.Routes[index], backendURL)
+		if backendIndex < 0 {
+			return errBackendNotFound
+		}
+		route := &cfg.Routes[index]
+		route.Backends = append(route.Backends[:backendIndex], route.Backends[backendIndex+1:]...)
+		return nil
+	}, g.applyBackendChanges(id))
+	if err != nil {
+		writeMutationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "后端服务已删除"})
+}
+
+// mutateConfig 在当前配置的副本上执行修改，校验通过后应用并按需写回配置文件
+func (g *Gateway) mutateConfig(mutate func(cfg *config.Config) error, apply func(cfg *config.Config) error) (*config.Config, error) {
+	g.reloadMutex.Lock()
+	defer g.reloadMutex.Unlock()
+
+	current, _, _ := g.currentTable().snapshot()
+	cfg, err := current.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mutate(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, &validationError{err}
+	}
+	if err := apply(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Admin.PersistRoutes && cfg.Path() != "" {
+		if err := config.SaveRoutes(cfg.Path(), cfg.Routes); err != nil {
+			logger.Errorf("写回配置文件失败: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// applyBackendChanges 在当前路由表上直接增删路由的后端服务，不重建路由
+func (g *Gateway) applyBackendChanges(id string) func(cfg *config.Config) error {
+	return func(cfg *config.Config) error {
+		table := g.currentTable()
+		current, _, _ := table.snapshot()
+
+		route := cfg.Routes[findRoute(cfg, id)]
+		previous := current.Routes[findRoute(current, id)]
+		lb := table.loadBalancers[route.Path]
+
+		// 先创建所有新的后端实例，失败时不修改路由表
+		added := make(map[string]*loadbalancer.Backend)
+		upstreams := make(map[string]*upstream)
+		for _, backendCfg := range route.Backends {
+			index := findRouteBackend(previous, backendCfg.URL)
+			if index >= 0 && reflect.DeepEqual(previous.Backends[index], backendCfg) {
+				continue
+			}
+			backend, err := g.newBackend(route, backendCfg)
+			if err != nil {
+				return &validationError{err}
+			}
+			previousUpstream, _ := table.upstream(route.Path, backendCfg.URL)
+			up, err := g.newUpstream(route, backendCfg, backend, previousUpstream)
+			if err != nil {
+				return &validationError{err}
+			}
+			added[backendCfg.URL] = backend
+			upstreams[backendCfg.URL] = up
+		}
+
+		for _, backendCfg := range previous.Backends {
+			if _, replaced := added[backendCfg.URL]; replaced || findRouteBackend(route, backendCfg.URL) < 0 {
+				lb.RemoveBackend(backendCfg.URL)
+				g.healthChecker.RemoveBackend(route.Path, backendCfg.URL)
+				if !replaced {
+					table.removeUpstream(route.Path, backendCfg.URL)
+				}
+				logger.Infof("移除后端服务: %s -> %s", route.Path, backendCfg.URL)
+			}
+		}
+
+		for _, backendCfg := range route.Backends {
+			backend, ok := added[backendCfg.URL]
+			if !ok {
+				continue
+			}
+			table.setUpstream(route.Path, backendCfg.URL, upstreams[backendCfg.URL])
+			lb.AddBackend(backend)
+			if backendCfg.HealthCheck.Enabled {
+				g.healthChecker.AddBackend(route.Path, backend, lb)
+			}
+			logger.Infof("添加后端服务: %s -> %s", route.Path, backendCfg.URL)
+		}
+
+		version := table.setConfig(cfg)
+		logger.Infof("后端服务已更新，配置版本: %d", version)
+		return nil
+	}
+}
+
+// validationError 配置校验失败
+type validationError struct {
+	err error
+}
+
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+// writeMutationError 根据错误类型写入管理接口的错误响应
+func writeMutationError(c *gin.Context, err error) {
+	var invalid *validationError
+	switch {
+	case errors.Is(err, errRouteNotFound), errors.Is(err, errBackendNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errBackendExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配置验证失败", "message": err.Error()})
+	default:
+		logger.Errorf("应用配置变更失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "应用配置变更失败", "message": err.Error()})
+	}
+}
+
+// writeConfigValue 以yaml字段名输出配置结构
+func writeConfigValue(c *gin.Context, statusCode int, value interface{}) {
+	view, err := config.ToPlain(value)
+	if err != nil {
+		logger.Errorf("导出配置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+	c.JSON(statusCode, view)
+}
+
+// bindRoute 解析请求体中的路由配置
+func bindRoute(c *gin.Context) (config.RouteConfig, bool) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取请求体失败"})
+		return config.RouteConfig{}, false
+	}
+
+	route, err := config.ParseRoute(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数", "message": err.Error()})
+		return config.RouteConfig{}, false
+	}
+	return route, true
+}
+
+// bindBackend 解析请求体中的后端服务配置
+func bindBackend(c *gin.Context) (config.BackendConfig, bool) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取请求体失败"})
+		return config.BackendConfig{}, false
+	}
+
+	backend, err := config.ParseBackend(data)
+	if err != nil || backend.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return config.BackendConfig{}, false
+	}
+	return backend, true
+}
+
+// findRoute 按标识查找路由，返回索引，不存在时返回-1
+func findRoute(cfg *config.Config, id string) int {
+	for i, route := range cfg.Routes {
+		routeID := route.ID
+		if routeID == "" {
+			routeID = config.RouteID(route.Path)
+		}
+		if routeID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRouteBackend 按URL查找路由的后端服务，返回索引，不存在时返回-1
+func findRouteBackend(route config.RouteConfig, backendURL string) int {
+	for i, backend := range route.Backends {
+		if backend.URL == backendURL {
+			return i
+		}
+	}
+	return -1
+}
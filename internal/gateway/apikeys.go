@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/logger"
+)
+
+// listAPIKeysHandler 列出API密钥，不返回密钥哈希
+func (g *Gateway) listAPIKeysHandler(c *gin.Context) {
+	if !g.requireAPIKeys(c) {
+		return
+	}
+
+	keys, err := g.apiKeys.List(c.Request.Context())
+	if err != nil {
+		logger.Errorf("列出API密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+
+	result := make([]*auth.APIKey, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, redactAPIKey(key))
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": result})
+}
+
+// createAPIKeyHandler 创建API密钥，明文只在此时返回
+func (g *Gateway) createAPIKeyHandler(c *gin.Context) {
+	if !g.requireAPIKeys(c) {
+		return
+	}
+
+	var req struct {
+		Owner     string     `json:"owner" binding:"required"`
+		Roles     []string   `json:"roles"`
+		Routes    []string   `json:"routes"`
+		Tier      string     `json:"tier"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	plaintext, key, err := g.apiKeys.Create(c.Request.Context(), auth.APIKey{
+		Owner:     req.Owner,
+		Roles:     req.Roles,
+		Routes:    req.Routes,
+		Tier:      req.Tier,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		logger.Errorf("创建API密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+
+	logger.Infof("已为 %s 创建API密钥 %s", key.Owner, key.ID)
+	c.JSON(http.StatusCreated, gin.H{"key": plaintext, "api_key": redactAPIKey(key)})
+}
+
+// rotateAPIKeyHandler 轮换API密钥，旧的明文立即失效
+func (g *Gateway) rotateAPIKeyHandler(c *gin.Context) {
+	if !g.requireAPIKeys(c) {
+		return
+	}
+
+	plaintext, key, err := g.apiKeys.Rotate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeAPIKeyError(c, err)
+		return
+	}
+
+	logger.Infof("已轮换API密钥 %s", key.ID)
+	c.JSON(http.StatusOK, gin.H{"key": plaintext, "api_key": redactAPIKey(key)})
+}
+
+// revokeAPIKeyHandler 吊销API密钥
+func (g *Gateway) revokeAPIKeyHandler(c *gin.Context) {
+	if !g.requireAPIKeys(c) {
+		return
+	}
+
+	id := c.Param("id")
+	if err := g.apiKeys.Revoke(c.Request.Context(), id); err != nil {
+		writeAPIKeyError(c, err)
+		return
+	}
+
+	logger.Infof("已吊销API密钥 %s", id)
+	c.JSON(http.StatusOK, gin.H{"message": "API密钥已吊销"})
+}
+
+// requireAPIKeys 检查是否启用了API密钥认证
+func (g *Gateway) requireAPIKeys(c *gin.Context) bool {
+	if g.apiKeys == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未启用API密钥认证"})
+		return false
+	}
+	return true
+}
+
+// writeAPIKeyError 将API密钥操作的错误转换为响应
+func writeAPIKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API密钥不存在"})
+	case errors.Is(err, auth.ErrRevokedAPIKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "API密钥已被吊销"})
+	default:
+		logger.Errorf("API密钥操作失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+	}
+}
+
+// redactAPIKey 返回不包含密钥哈希的副本
+func redactAPIKey(key *auth.APIKey) *auth.APIKey {
+	copied := *key
+	copied.Hash = ""
+	return &copied
+}
@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// errBodyTooLarge 请求体超过路由允许的最大大小
+var errBodyTooLarge = errors.New("请求体过大")
+
+// countingReadCloser 统计读取字节数的请求体包装器，请求体直接流式转发到上游
+type countingReadCloser struct {
+	rc    io.ReadCloser
+	limit int64 // 最大允许读取的字节数，0表示不限制
+	n     int64
+}
+
+// newCountingReadCloser 创建计数请求体
+func newCountingReadCloser(rc io.ReadCloser, limit int64) *countingReadCloser {
+	return &countingReadCloser{rc: rc, limit: limit}
+}
+
+// Read 读取请求体并统计字节数
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.n += int64(n)
+	if c.limit > 0 && c.n > c.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// Close 关闭请求体
+func (c *countingReadCloser) Close() error {
+	return c.rc.Close()
+}
+
+// BytesRead 返回已读取的字节数
+func (c *countingReadCloser) BytesRead() int64 {
+	return c.n
+}
+
+// bufferBody 在重试需要重放请求体时缓存请求体，超过maxSize时放弃缓存。
+// 返回值replay为可重放的请求体（未能缓存时为nil），rest为继续流式转发的请求体。
+func bufferBody(body io.ReadCloser, contentLength, maxSize int64) (replay []byte, rest io.ReadCloser, err error) {
+	if maxSize <= 0 || contentLength > maxSize {
+		return nil, body, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if int64(len(data)) <= maxSize {
+		body.Close()
+		return data, nil, nil
+	}
+
+	// 超过缓存上限，已读取的部分与剩余部分拼接后继续流式转发
+	return nil, &multiReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(data), body),
+		closer: body,
+	}, nil
+}
+
+// multiReadCloser 拼接已缓存部分和剩余请求体
+type multiReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+// Close 关闭原始请求体
+func (m *multiReadCloser) Close() error {
+	return m.closer.Close()
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/config"
+	"api-gateway/internal/loadbalancer"
+)
+
+// newQueue 创建路由的等待队列，name为route或backend，队列长度导出为指标
+func (g *Gateway) newQueue(route config.RouteConfig, name string) *loadbalancer.Queue {
+	queue := loadbalancer.NewQueue(route.Concurrency.QueueSize, route.Concurrency.QueueTimeout,
+		route.Concurrency.Order == "priority")
+	queue.SetDepthObserver(func(depth int) {
+		g.metricsCollector.GetMetrics().UpdateQueueDepth(route.Path, name, depth)
+	})
+	return queue
+}
+
+// concurrencyMiddleware 路由并发限制中间件，超过并发数的请求排队等待
+func (g *Gateway) concurrencyMiddleware(route config.RouteConfig, limiter *loadbalancer.ConcurrencyLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		waited, err := limiter.Acquire(c.Request.Context(), requestPriority(c, route.Concurrency))
+		g.recordQueue(route, "route", waited, err)
+		if err != nil {
+			writeQueueError(c, route, err)
+			c.Abort()
+			return
+		}
+		defer limiter.Release()
+
+		c.Next()
+	}
+}
+
+// reserveBackend 选择后端服务并占用一个连接
+func reserveBackend(lb loadbalancer.LoadBalancer, clientIP string, tried map[string]bool) (*loadbalancer.Backend, error) {
+	backend, err := nextUntriedBackend(lb, clientIP, tried)
+	if err != nil {
+		return nil, err
+	}
+	// 选择后被其他请求占满
+	if !backend.TryAddConnection() {
+		backend.ReleaseRequest()
+		return nil, loadbalancer.ErrNoBackendsAvailable
+	}
+	return backend, nil
+}
+
+// waitBackend 所有可用的后端都达到最大连接数时，在路由的后端等待队列中等待连接释放。
+// 没有配置队列或后端因为不健康、熔断而不可用时直接返回错误
+func (g *Gateway) waitBackend(ctx context.Context, c *gin.Context, table *routeTable, route config.RouteConfig, lb loadbalancer.LoadBalancer, tried map[string]bool) (*loadbalancer.Backend, error) {
+	queue := table.backendQueues[route.Path]
+	if queue == nil || !saturated(lb) {
+		return nil, loadbalancer.ErrNoBackendsAvailable
+	}
+
+	// 队列可能在其他请求释放连接时代为获取后端
+	clientIP := c.ClientIP()
+	var backend *loadbalancer.Backend
+	waited, err := queue.Wait(ctx, requestPriority(c, route.Concurrency), func() bool {
+		next, err := reserveBackend(lb, clientIP, tried)
+		backend = next
+		return err == nil
+	})
+	g.recordQueue(route, "backend", waited, err)
+	if err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
+
+// isQueueError 检查是否为排队失败的错误
+func isQueueError(err error) bool {
+	return errors.Is(err, loadbalancer.ErrQueueFull) || errors.Is(err, loadbalancer.ErrQueueTimeout) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// releaseBackend 释放后端连接并唤醒等待后端的请求
+func releaseBackend(table *routeTable, route config.RouteConfig, backend *loadbalancer.Backend) {
+	backend.RemoveConnection()
+	if queue := table.backendQueues[route.Path]; queue != nil {
+		queue.Notify()
+	}
+}
+
+// saturated 检查是否有后端只因达到最大连接数而不可用
+func saturated(lb loadbalancer.LoadBalancer) bool {
+	for _, backend := range lb.GetBackends() {
+		if backend.Saturated() {
+			return true
+		}
+	}
+	return false
+}
+
+// requestPriority 返回请求的排队优先级，取调用方角色和API密钥等级中最高的优先级，都未配置时为0
+func requestPriority(c *gin.Context, cfg config.ConcurrencyConfig) int {
+	if cfg.Order != "priority" {
+		return 0
+	}
+
+	// 不能追加到上下文中的角色切片上，其他中间件共享它的底层数组
+	roles := c.GetStringSlice("user_roles")
+	names := make([]string, 0, len(roles)+1)
+	names = append(append(names, roles...), c.GetString("rate_limit_tier"))
+
+	priority, found := 0, false
+	for _, name := range names {
+		if p, exists := cfg.Priorities[name]; exists && (!found || p > priority) {
+			priority, found = p, true
+		}
+	}
+	return priority
+}
+
+// recordQueue 记录排队的等待时间和失败原因
+func (g *Gateway) recordQueue(route config.RouteConfig, queue string, waited time.Duration, err error) {
+	m := g.metricsCollector.GetMetrics()
+	if waited > 0 {
+		m.RecordQueueWait(route.Path, queue, waited)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, loadbalancer.ErrQueueFull):
+		m.RecordQueueRejected(route.Path, queue, "full")
+	case errors.Is(err, context.Canceled):
+		m.RecordQueueRejected(route.Path, queue, "canceled")
+	default:
+		m.RecordQueueRejected(route.Path, queue, "timeout")
+	}
+}
+
+// writeQueueError 写入排队失败的503响应，Retry-After为排队超时时间。客户端已断开时不再写入
+func writeQueueError(c *gin.Context, route config.RouteConfig, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	retryAfter := int64(math.Ceil(route.Concurrency.QueueTimeout.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	if errors.Is(err, loadbalancer.ErrQueueFull) {
+		writeProxyError(c.Writer, http.StatusServiceUnavailable, "queue_full", "服务繁忙，请稍后再试")
+		return
+	}
+	writeProxyError(c.Writer, http.StatusServiceUnavailable, "queue_timeout", "排队等待超时，请稍后再试")
+}
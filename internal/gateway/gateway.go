@@ -3,16 +3,17 @@ package gateway
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-	"os"
 
 	"github.com/gin-gonic/gin"
 	"api-gateway/internal/auth"
@@ -23,24 +24,36 @@ import (
 	"api-gateway/internal/logger"
 	"api-gateway/internal/metrics"
 	"api-gateway/internal/middleware"
+	"api-gateway/internal/quota"
 	"api-gateway/internal/ratelimit"
 )
 
 // Gateway API网关核心结构
 type Gateway struct {
 	config            *config.Config
-	router            *gin.Engine
+	table             atomic.Pointer[routeTable]
+	reloadMutex       sync.Mutex
 	middlewareManager *middleware.MiddlewareManager
-	loadBalancers     map[string]loadbalancer.LoadBalancer
 	cache             cache.Cache
 	tokenService      *auth.TokenService
+	tokenValidator    auth.TokenValidator
+	apiKeys           *auth.APIKeyService
+	loginLockout      *auth.LoginLockout
+	identitySigner    atomic.Pointer[auth.IdentitySigner]
 	userService       auth.UserService
 	rateLimiter       ratelimit.RateLimiter
+	limiters          *ratelimit.LimiterManager
+	quotas            *quota.Manager
 	healthChecker     *healthcheck.BackendHealthChecker
 	systemChecker     *healthcheck.SystemHealthChecker
 	metricsCollector  *metrics.MetricsCollector
-	httpClient        *http.Client
 	server            *http.Server
+
+	// 后台任务在Start中启动，Stop时取消并等待退出
+	background     context.Context
+	stopBackground context.CancelFunc
+	tasks          sync.WaitGroup
+	started        atomic.Bool
 }
 
 // NewGateway 创建新的网关实例
@@ -66,11 +79,56 @@ func NewGateway(cfg *config.Config) (*Gateway, error) {
 	}
 
 	// 创建认证服务
-	tokenService := auth.NewTokenService(cfg.Auth)
-	userService := auth.NewMockUserService()
+	tokenService, err := auth.NewTokenService(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("初始化令牌服务失败: %w", err)
+	}
+	revocations := auth.NewRevocationStore(cacheInstance)
+	tokenService.SetRevocationStore(revocations)
+	userService, err := auth.NewUserService(cfg.Auth.Users)
+	if err != nil {
+		return nil, fmt.Errorf("初始化用户服务失败: %w", err)
+	}
+
+	// 同时接受外部身份提供方签发的令牌
+	var tokenValidator auth.TokenValidator = tokenService
+	if len(cfg.Auth.OIDC) > 0 {
+		oidcValidator, err := auth.NewOIDCValidator(cfg.Auth.OIDC)
+		if err != nil {
+			return nil, fmt.Errorf("初始化外部令牌验证器失败: %w", err)
+		}
+		oidcValidator.SetRevocationStore(revocations)
+		tokenValidator = auth.NewChainValidator(tokenService, oidcValidator)
+	}
+
+	// 创建API密钥服务
+	var apiKeys *auth.APIKeyService
+	if cfg.Auth.APIKeys.Enabled {
+		var store auth.APIKeyStore = auth.NewCacheAPIKeyStore(cacheInstance)
+		if cfg.Auth.APIKeys.Store == "file" {
+			fileStore, err := auth.NewFileAPIKeyStore(cfg.Auth.APIKeys.File)
+			if err != nil {
+				return nil, err
+			}
+			store = fileStore
+		}
+		apiKeys = auth.NewAPIKeyService(store)
+	}
 
 	// 创建速率限制器
-	rateLimiter := ratelimit.NewTokenBucketLimiter(cacheInstance)
+	// 使用Redis缓存时由所有实例共享限额，否则在本实例内限制
+	limiters := ratelimit.NewLimiterManager(cacheInstance)
+	rateLimiter := limiters.GetLimiter("default", ratelimit.LimiterConfig{
+		Type:      cfg.RateLimit.Algorithm,
+		Window:    cfg.RateLimit.Window,
+		BurstSize: cfg.RateLimit.Burst,
+	})
+
+	// 创建配额管理器，同样在使用Redis缓存时由所有实例共享
+	quotas, err := quota.NewManager(cfg.Quota, cacheInstance)
+	if err != nil {
+		return nil, fmt.Errorf("初始化配额管理器失败: %w", err)
+	}
 
 	// 创建健康检查器
 	healthChecker := healthcheck.NewBackendHealthChecker()
@@ -79,41 +137,33 @@ func NewGateway(cfg *config.Config) (*Gateway, error) {
 	// 创建指标收集器
 	metricsCollector := metrics.NewMetricsCollector()
 
-	// 创建HTTP客户端
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 20,
-			IdleConnTimeout:     90 * time.Second,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: false,
-			},
-		},
-	}
-
 	gateway := &Gateway{
 		config:            cfg,
 		middlewareManager: middleware.NewMiddlewareManager(),
-		loadBalancers:     make(map[string]loadbalancer.LoadBalancer),
 		cache:             cacheInstance,
 		tokenService:      tokenService,
+		tokenValidator:    tokenValidator,
+		apiKeys:           apiKeys,
 		userService:       userService,
+		loginLockout:      auth.NewLoginLockout(cacheInstance, cfg.Auth.Lockout),
 		rateLimiter:       rateLimiter,
+		limiters:          limiters,
+		quotas:            quotas,
 		healthChecker:     healthChecker,
 		systemChecker:     systemChecker,
 		metricsCollector:  metricsCollector,
-		httpClient:        httpClient,
 	}
 
+	gateway.identitySigner.Store(newIdentitySigner(cfg.Auth.IdentitySigning))
+	gateway.background, gateway.stopBackground = context.WithCancel(context.Background())
+
 	// 初始化中间件
 	gateway.initializeMiddlewares()
 
-	// 初始化路由
-	gateway.initializeRoutes()
-
-	// 初始化负载均衡器
-	gateway.initializeLoadBalancers()
+	// 初始化路由表
+	if err := gateway.initializeRouteTable(); err != nil {
+		return nil, err
+	}
 
 	// 添加系统依赖检查
 	gateway.addSystemDependencies()
@@ -135,114 +185,82 @@ func (g *Gateway) initializeMiddlewares() {
 		24*time.Hour,
 	))
 	g.middlewareManager.Register(middleware.NewCompressionMiddleware())
-	g.middlewareManager.Register(middleware.NewAuthMiddleware(
-		g.tokenService,
+	authMiddleware := middleware.NewAuthMiddleware(
+		g.tokenValidator,
 		g.userService,
 		[]string{"/health", "/metrics", "/auth"},
-	))
+	)
+	if g.apiKeys != nil {
+		authMiddleware.EnableAPIKeys(g.apiKeys, g.config.Auth.APIKeys.Header, g.config.Auth.APIKeys.QueryParam)
+	}
+	if g.config.Auth.ClientCert.Enabled {
+		authMiddleware.EnableClientCerts(g.config.Auth.ClientCert)
+	}
+	g.middlewareManager.Register(authMiddleware)
+	if g.config.Auth.ForwardAuth.URL != "" {
+		g.middlewareManager.Register(middleware.NewForwardAuthMiddleware(g.config.Auth.ForwardAuth, g.cache))
+	}
 	g.middlewareManager.Register(middleware.NewRateLimitMiddleware(g.rateLimiter, 100))
 	g.middlewareManager.Register(middleware.NewCacheMiddleware(g.cache, 5*time.Minute))
 }
 
-// initializeRoutes 初始化路由
-func (g *Gateway) initializeRoutes() {
-	g.router = gin.New()
-
-	// 基础中间件
-	g.router.Use(g.metricsMiddleware())
-	g.router.Use(g.middlewareManager.Get("logging").Handle())
-	g.router.Use(g.middlewareManager.Get("security").Handle())
-	g.router.Use(g.middlewareManager.Get("cors").Handle())
-	g.router.Use(gin.Recovery())
-
-	// 健康检查端点
-	g.router.GET("/health", g.healthCheckHandler)
-	g.router.GET("/health/detailed", g.detailedHealthCheckHandler)
-
-	// 认证端点
-	authGroup := g.router.Group("/auth")
-	{
-		authGroup.POST("/login", g.loginHandler)
-		authGroup.POST("/refresh", g.refreshTokenHandler)
-		authGroup.POST("/logout", g.logoutHandler)
-	}
-
-	// 管理端点
-	adminGroup := g.router.Group("/admin")
-	adminGroup.Use(g.middlewareManager.Get("auth").Handle())
-	{
-		adminGroup.GET("/status", g.statusHandler)
-		adminGroup.GET("/backends", g.backendsHandler)
-		adminGroup.POST("/backends/health", g.updateBackendHealthHandler)
-	}
-
-	// 代理路由
-	g.setupProxyRoutes()
-
-	// 前端静态资源 (如果存在 public 目录)
-	if _, err := os.Stat("public"); err == nil {
-		g.router.Static("/", "public")
-		// SPA fallback: 未匹配到API时返回 index.html
-		g.router.NoRoute(func(c *gin.Context) {
-			if strings.HasPrefix(c.Request.URL.Path, "/api") || strings.HasPrefix(c.Request.URL.Path, "/auth") || strings.HasPrefix(c.Request.URL.Path, "/admin") || strings.HasPrefix(c.Request.URL.Path, "/metrics") {
-				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-				return
-			}
-			c.File("public/index.html")
-		})
+// forwardAuthHandler 获取外部授权中间件，启动时未配置授权服务时拒绝请求
+func (g *Gateway) forwardAuthHandler() gin.HandlerFunc {
+	if m, exists := g.middlewareManager.Get("forward_auth"); exists {
+		return m.Handle()
+	}
+	logger.Warn("外部授权服务未启用，使用 forward_auth 中间件的路由将拒绝所有请求，请重启网关")
+	return func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "授权服务不可用"})
 	}
 }
 
-// setupProxyRoutes 设置代理路由
-func (g *Gateway) setupProxyRoutes() {
-	for _, route := range g.config.Routes {
-		routeGroup := g.router.Group(route.Path)
-
-		// 应用路由特定的中间件
-		if route.AuthRequired {
-			routeGroup.Use(g.middlewareManager.Get("auth").Handle())
-		}
-
-		if route.RateLimit > 0 {
-			routeGroup.Use(g.routeRateLimitMiddleware(route.RateLimit))
-		}
-
-		if route.CacheEnabled {
-			routeGroup.Use(g.middlewareManager.Get("cache").Handle())
-		}
-
-		// 应用自定义中间件
-		g.middlewareManager.Apply(routeGroup, route.Middleware)
-
-		// 注册路由处理器
-		routeGroup.Any("/*path", g.proxyHandler(route))
+// middlewareHandler 获取已注册中间件的处理函数
+func (g *Gateway) middlewareHandler(name string) gin.HandlerFunc {
+	m, exists := g.middlewareManager.Get(name)
+	if !exists {
+		logger.Warnf("中间件 %s 不存在", name)
+		return func(c *gin.Context) { c.Next() }
 	}
+	return m.Handle()
 }
 
-// initializeLoadBalancers 初始化负载均衡器
-func (g *Gateway) initializeLoadBalancers() {
-	for _, route := range g.config.Routes {
-		lb := loadbalancer.CreateLoadBalancer(route.LoadBalancer)
+// initializeRouteTable 初始化路由表和负载均衡器
+func (g *Gateway) initializeRouteTable() error {
+	table, err := g.buildRouteTable(g.config, nil)
+	if err != nil {
+		return err
+	}
+	table.version = 1
+	g.table.Store(table)
 
+	for _, route := range g.config.Routes {
 		for _, backendCfg := range route.Backends {
-			backend, err := loadbalancer.NewBackend(backendCfg)
-			if err != nil {
-				logger.Errorf("创建后端服务失败 %s: %v", backendCfg.URL, err)
-				continue
-			}
-
-			lb.AddBackend(backend)
-			
-			// 添加到健康检查器
-			if backendCfg.HealthCheck.Enabled {
-				g.healthChecker.AddBackend(route.Path, backend, lb)
-			}
-
 			logger.Infof("添加后端服务: %s -> %s", route.Path, backendCfg.URL)
 		}
-
-		g.loadBalancers[route.Path] = lb
 	}
+	g.syncHealthChecks(table, nil)
+	return nil
+}
+
+// authorizationHandler 创建授权中间件处理函数，拒绝的请求计入指标
+func (g *Gateway) authorizationHandler(route string, policy middleware.AuthorizationPolicy) gin.HandlerFunc {
+	return middleware.NewAuthorizationMiddleware(policy, func(c *gin.Context, reason string) {
+		userID, _ := c.Get("user_id")
+		logger.Warnf("拒绝未授权的请求 %s %s, 用户: %v, 原因: %s", c.Request.Method, c.Request.URL.Path, userID, reason)
+		g.metricsCollector.GetMetrics().RecordAuthorizationDenied(route, reason)
+	}).Handle()
+}
+
+// newCircuitBreaker 创建后端熔断器，状态变化时更新指标
+func (g *Gateway) newCircuitBreaker(route config.RouteConfig, backendURL string) *loadbalancer.CircuitBreaker {
+	cb := loadbalancer.NewCircuitBreaker(route.CircuitBreaker)
+	cb.OnStateChange(func(from, to loadbalancer.CircuitState) {
+		logger.Warnf("后端服务熔断器状态变化 %s -> %s: %s -> %s", route.Path, backendURL, from, to)
+		g.metricsCollector.GetMetrics().UpdateBackendCircuitState(route.Path, backendURL, int(to))
+	})
+	g.metricsCollector.GetMetrics().UpdateBackendCircuitState(route.Path, backendURL, int(loadbalancer.CircuitClosed))
+	return cb
 }
 
 // addSystemDependencies 添加系统依赖检查
@@ -256,65 +274,189 @@ func (g *Gateway) addSystemDependencies() {
 }
 
 // proxyHandler 代理处理器
-func (g *Gateway) proxyHandler(route config.RouteConfig) gin.HandlerFunc {
+func (g *Gateway) proxyHandler(table *routeTable, route config.RouteConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 
 		// 获取负载均衡器
-		lb, exists := g.loadBalancers[route.Path]
+		lb, exists := table.loadBalancers[route.Path]
 		if !exists {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "负载均衡器未找到"})
 			return
 		}
 
-		// 选择后端服务
-		backend, err := lb.NextBackend(c.ClientIP())
-		if err != nil {
-			g.metricsCollector.GetMetrics().RecordBackendRequest(
-				"unavailable", c.Request.Method, http.StatusServiceUnavailable, time.Since(start))
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后端服务不可用"})
+		// 拒绝声明长度超过限制的请求体
+		if route.MaxBodySize > 0 && c.Request.ContentLength > route.MaxBodySize {
+			writeBodyTooLarge(c.Writer)
 			return
 		}
 
-		// 增加连接计数
-		backend.AddConnection()
-		defer backend.RemoveConnection()
+		// 请求体流式转发到上游，同时统计读取的字节数
+		var counter *countingReadCloser
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			counter = newCountingReadCloser(c.Request.Body, route.MaxBodySize)
+			c.Request.Body = counter
+		}
 
-		// 创建反向代理
-		proxy := g.createReverseProxy(backend, route)
-		
-		// 记录请求大小
-		var requestSize int64
-		if c.Request.Body != nil {
-			if body, err := io.ReadAll(c.Request.Body); err == nil {
-				requestSize = int64(len(body))
+		// 仅在重试需要重放时缓存请求体，超过缓存上限则放弃重试
+		attempts := maxAttempts(route, c.Request.Method)
+		var body []byte
+		if attempts > 1 && counter != nil {
+			replay, rest, err := bufferBody(counter, c.Request.ContentLength, route.RetryPolicy.MaxBufferSize)
+			if err != nil {
+				if errors.Is(err, errBodyTooLarge) {
+					writeBodyTooLarge(c.Writer)
+				} else {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "读取请求体失败"})
+				}
+				return
+			}
+			if replay != nil {
+				body = replay
+			} else {
+				attempts = 1
+				c.Request.Body = rest
+			}
+		}
+
+		// 整个请求（包括所有重试）的超时时间
+		ctx := c.Request.Context()
+		if route.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+			defer cancel()
+		}
+		ctx = withIdentity(ctx, c)
+
+		tried := make(map[string]bool)
+
+		var backend *loadbalancer.Backend
+		var attempt *proxyAttempt
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				// 检查重试预算
+				wait := retryBackoff(route.RetryPolicy, i)
+				if time.Since(start)+wait > route.RetryPolicy.Budget {
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+
+			// 选择后端服务并占用连接，重试时优先选择未尝试过的后端
+			next, err := reserveBackend(lb, c.ClientIP(), tried)
+			if err != nil && backend == nil {
+				// 后端都达到最大连接数时排队等待
+				next, err = g.waitBackend(ctx, c, table, route, lb, tried)
+				if isQueueError(err) {
+					writeQueueError(c, route, err)
+					return
+				}
+			}
+			if err != nil {
+				if backend == nil {
+					g.metricsCollector.GetMetrics().RecordBackendRequest(
+						"unavailable", c.Request.Method, http.StatusServiceUnavailable, time.Since(start))
+					c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后端服务不可用"})
+					return
+				}
+				break
+			}
+
+			if backend != nil {
+				g.metricsCollector.GetMetrics().RecordBackendRetry(route.Path, backend.URL.String(), attempt.reason())
+			}
+			backend = next
+			tried[backend.URL.String()] = true
+
+			if body != nil {
 				c.Request.Body = io.NopCloser(bytes.NewReader(body))
 			}
+
+			attempt = &proxyAttempt{
+				final:   i == attempts-1,
+				retryOn: route.RetryPolicy.RetryOn,
+			}
+			g.serveAttempt(ctx, c, table, backend, route, attempt)
+
+			if attempt.served || !attempt.retryable() || ctx.Err() != nil {
+				break
+			}
+			logger.Warnf("代理请求失败，准备重试 %s: %s", backend.URL.String(), attempt.reason())
+		}
+
+		// 未再重试时，返回最后一次被拦截的响应或错误
+		if !attempt.served {
+			switch {
+			case attempt.response != nil:
+				attempt.response.writeTo(c.Writer)
+			case errors.Is(attempt.err, errBodyTooLarge):
+				writeBodyTooLarge(c.Writer)
+			case attempt.timedOut() || errors.Is(ctx.Err(), context.DeadlineExceeded):
+				writeGatewayTimeout(c.Writer)
+			default:
+				writeBadGateway(c.Writer)
+			}
 		}
 
-		// 代理请求
-		proxy.ServeHTTP(c.Writer, c.Request)
+		var requestSize int64
+		if counter != nil {
+			requestSize = counter.BytesRead()
+		}
 
-		// 记录指标
-		duration := time.Since(start)
-		g.metricsCollector.GetMetrics().RecordBackendRequest(
-			backend.URL.String(), c.Request.Method, c.Writer.Status(), duration)
-		
-		// 记录完整的请求指标
+		// 记录完整的请求指标，后端请求指标已在每次尝试时记录
 		g.metricsCollector.Record(metrics.RequestMetrics{
 			Method:       c.Request.Method,
 			Path:         c.Request.URL.Path,
 			StatusCode:   c.Writer.Status(),
-			Duration:     duration,
+			Duration:     time.Since(start),
 			RequestSize:  requestSize,
 			ResponseSize: int64(c.Writer.Size()),
-			Backend:      backend.URL.String(),
 		})
 	}
 }
 
-// createReverseProxy 创建反向代理
-func (g *Gateway) createReverseProxy(backend *loadbalancer.Backend, route config.RouteConfig) *httputil.ReverseProxy {
+// serveAttempt 向指定后端发起一次代理尝试
+func (g *Gateway) serveAttempt(ctx context.Context, c *gin.Context, table *routeTable, backend *loadbalancer.Backend, route config.RouteConfig, attempt *proxyAttempt) {
+	start := time.Now()
+
+	// 连接已在选择后端时占用
+	defer releaseBackend(table, route, backend)
+
+	// 单次尝试的超时时间
+	if backend.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, backend.Timeout)
+		defer cancel()
+	}
+
+	up := g.getUpstream(table, route, backend)
+	up.proxy.ServeHTTP(c.Writer, c.Request.WithContext(withAttempt(ctx, attempt)))
+
+	// 向熔断器反馈请求结果，客户端取消的请求不计入
+	if attempt.err != nil && errors.Is(attempt.err, context.Canceled) {
+		backend.ReleaseRequest()
+	} else {
+		backend.RecordResult(attempt.statusCode() < http.StatusInternalServerError)
+	}
+
+	if attempt.timedOut() {
+		g.metricsCollector.GetMetrics().RecordBackendTimeout(
+			backend.URL.String(), c.Request.Method, time.Since(start))
+		return
+	}
+	g.metricsCollector.GetMetrics().RecordBackendRequest(
+		backend.URL.String(), c.Request.Method, attempt.statusCode(), time.Since(start))
+}
+
+// createReverseProxy 创建反向代理，单次尝试的状态通过请求上下文传递
+func (g *Gateway) createReverseProxy(backend *loadbalancer.Backend, route config.RouteConfig, transport http.RoundTripper) *httputil.ReverseProxy {
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = backend.URL.Scheme
@@ -333,32 +475,91 @@ func (g *Gateway) createReverseProxy(backend *loadbalancer.Backend, route config
 			req.Header.Set("X-Forwarded-For", req.RemoteAddr)
 			req.Header.Set("X-Forwarded-Proto", req.URL.Scheme)
 			req.Header.Set("X-Gateway-Request-ID", generateRequestID())
+
+			// 转发调用方身份
+			g.forwardIdentity(req, route)
 		},
-		
-		Transport: &http.Transport{
-			MaxIdleConns:       100,
-			IdleConnTimeout:    90 * time.Second,
-			DisableCompression: false,
-		},
+
+
+		Transport: transport,
 
 		ModifyResponse: func(resp *http.Response) error {
+			attempt := attemptFromContext(resp.Request.Context())
+
+			// 可重试的状态码在非最后一次尝试时被拦截
+			if attempt.shouldRetryStatus(resp.StatusCode) {
+				if err := attempt.capture(resp); err != nil {
+					return err
+				}
+				return errRetryableStatus
+			}
+
 			// 添加响应头
 			resp.Header.Set("X-Gateway", "api-gateway")
 			resp.Header.Set("X-Backend", backend.URL.String())
+			attempt.served = true
+			attempt.status = resp.StatusCode
 			return nil
 		},
 
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, errRetryableStatus) {
+				return
+			}
+
+			attempt := attemptFromContext(r.Context())
+			attempt.err = err
+			if errors.Is(err, errBodyTooLarge) {
+				attempt.served = true
+				attempt.status = http.StatusRequestEntityTooLarge
+				writeBodyTooLarge(w)
+				return
+			}
+
 			logger.Errorf("代理请求失败: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write([]byte(`{"error": "后端服务错误"}`))
+			if attempt.final {
+				attempt.served = true
+				if attempt.timedOut() {
+					attempt.status = http.StatusGatewayTimeout
+					writeGatewayTimeout(w)
+				} else {
+					attempt.status = http.StatusBadGateway
+					writeBadGateway(w)
+				}
+			}
 		},
 	}
 
 	return proxy
 }
 
+// writeBadGateway 写入后端服务错误响应
+func writeBadGateway(w http.ResponseWriter) {
+	writeProxyError(w, http.StatusBadGateway, "upstream_error", "后端服务错误")
+}
+
+// writeGatewayTimeout 写入后端服务超时响应
+func writeGatewayTimeout(w http.ResponseWriter) {
+	writeProxyError(w, http.StatusGatewayTimeout, "upstream_timeout", "后端服务响应超时")
+}
+
+// writeBodyTooLarge 写入请求体过大响应
+func writeBodyTooLarge(w http.ResponseWriter) {
+	writeProxyError(w, http.StatusRequestEntityTooLarge, "body_too_large", "请求体过大")
+}
+
+// writeProxyError 写入结构化的代理错误响应
+func writeProxyError(w http.ResponseWriter, statusCode int, code, message string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error":  message,
+		"code":   code,
+		"status": statusCode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
 // metricsMiddleware 指标中间件
 func (g *Gateway) metricsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -383,42 +584,6 @@ func (g *Gateway) metricsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// routeRateLimitMiddleware 路由级别的速率限制中间件
-func (g *Gateway) routeRateLimitMiddleware(limit int) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		clientIP := c.ClientIP()
-		userID, _ := c.Get("user_id")
-		path := c.Request.URL.Path
-
-		key := ratelimit.GenerateRateLimitKey(clientIP, fmt.Sprintf("%v", userID), path)
-
-		allowed, err := g.rateLimiter.Allow(c.Request.Context(), key, limit)
-		if err != nil {
-			logger.Errorf("速率限制检查失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
-			c.Abort()
-			return
-		}
-
-		g.metricsCollector.GetMetrics().RecordRateLimit(allowed)
-
-		if !allowed {
-			c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
-			c.Header("X-RateLimit-Remaining", "0")
-			c.Header("Retry-After", "60")
-			
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":   "请求过于频繁",
-				"message": "请稍后再试",
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // healthCheckHandler 健康检查处理器
 func (g *Gateway) healthCheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -461,14 +626,45 @@ func (g *Gateway) loginHandler(c *gin.Context) {
 		return
 	}
 
+	// 检查账户是否因登录失败次数过多被锁定，锁定状态不可用时不阻止登录
+	ctx := c.Request.Context()
+	remaining, err := g.loginLockout.Locked(ctx, req.Username)
+	if err != nil {
+		logger.Errorf("检查登录锁定状态失败: %v", err)
+	}
+	if remaining > 0 {
+		g.metricsCollector.GetMetrics().RecordAuth(false)
+		retryAfter := int((remaining + time.Second - 1) / time.Second)
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "登录失败次数过多，请稍后再试", "retry_after": retryAfter})
+		return
+	}
+
 	// 验证用户凭据
 	user, err := g.userService.ValidateCredentials(req.Username, req.Password)
 	if err != nil {
 		g.metricsCollector.GetMetrics().RecordAuth(false)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			if duration, err := g.loginLockout.RecordFailure(ctx, req.Username); err != nil {
+				logger.Errorf("记录登录失败次数失败: %v", err)
+			} else if duration > 0 {
+				logger.Warnf("用户 %s 登录失败次数过多，锁定 %s", req.Username, duration)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		case errors.Is(err, auth.ErrInactiveUser):
+			c.JSON(http.StatusForbidden, gin.H{"error": "用户已被禁用"})
+		default:
+			logger.Errorf("验证用户凭据失败: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "用户服务暂不可用"})
+		}
 		return
 	}
 
+	if err := g.loginLockout.Reset(ctx, req.Username); err != nil {
+		logger.Errorf("清除登录失败次数失败: %v", err)
+	}
+
 	// 生成访问令牌
 	accessToken, err := g.tokenService.GenerateToken(user.ID, user.Username, user.Email, user.Roles)
 	if err != nil {
@@ -512,38 +708,156 @@ func (g *Gateway) refreshTokenHandler(c *gin.Context) {
 		return
 	}
 
-	// 刷新令牌
-	newAccessToken, err := g.tokenService.RefreshToken(req.RefreshToken)
+	// 刷新令牌，旧的刷新令牌随即失效
+	accessToken, refreshToken, err := g.tokenService.RefreshToken(req.RefreshToken, g.userService)
 	if err != nil {
-		g.metricsCollector.GetMetrics().RecordTokenValidation("invalid")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的刷新令牌"})
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			logger.Warn("检测到已使用的刷新令牌被再次使用，已吊销该令牌家族")
+			g.metricsCollector.GetMetrics().RecordTokenValidation("reused")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "刷新令牌已被使用"})
+		case errors.Is(err, auth.ErrRevokedToken):
+			g.metricsCollector.GetMetrics().RecordTokenValidation("revoked")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "刷新令牌已被吊销"})
+		case errors.Is(err, auth.ErrInactiveUser):
+			g.metricsCollector.GetMetrics().RecordTokenValidation("invalid")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户已被禁用"})
+		case errors.Is(err, auth.ErrInvalidToken):
+			g.metricsCollector.GetMetrics().RecordTokenValidation("invalid")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的刷新令牌"})
+		default:
+			logger.Errorf("刷新令牌失败: %v", err)
+			g.metricsCollector.GetMetrics().RecordTokenValidation("invalid")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的刷新令牌"})
+		}
 		return
 	}
 
 	g.metricsCollector.GetMetrics().RecordTokenValidation("valid")
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": newAccessToken,
-		"token_type":   "Bearer",
-		"expires_in":   int(g.config.Auth.TokenExpiry.Seconds()),
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(g.config.Auth.TokenExpiry.Seconds()),
 	})
 }
 
-// logoutHandler 登出处理器
+// jwksHandler 发布用于验证令牌的公钥
+func (g *Gateway) jwksHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, g.tokenService.JWKS())
+}
+
+// logoutHandler 登出处理器，吊销当前访问令牌和可选的刷新令牌
 func (g *Gateway) logoutHandler(c *gin.Context) {
-	// 在实际实现中，这里应该将令牌加入黑名单
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// 请求体可选
+	c.ShouldBindJSON(&req)
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || token == c.GetHeader("Authorization") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证令牌"})
+		return
+	}
+
+	// 与认证中间件使用相同的验证器，外部身份提供方签发的令牌也可以登出
+	claims, err := g.tokenValidator.ValidateToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
+		return
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "令牌缺少jti，无法吊销"})
+		return
+	}
+
+	if err := g.tokenService.RevokeTokenID(c.Request.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		logger.Errorf("吊销访问令牌失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+
+	if req.RefreshToken != "" {
+		if err := g.tokenService.RevokeToken(c.Request.Context(), req.RefreshToken); err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的刷新令牌"})
+				return
+			}
+			logger.Errorf("吊销刷新令牌失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "登出成功"})
 }
 
+// revokeTokenHandler 吊销指定令牌，可以提供完整令牌或jti
+func (g *Gateway) revokeTokenHandler(c *gin.Context) {
+	var req struct {
+		Token     string     `json:"token"`
+		JTI       string     `json:"jti"`
+		ExpiresAt *time.Time `json:"expires_at"` // 仅提供jti时使用，默认为令牌最长有效期
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Token == "" && req.JTI == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	var err error
+	if req.Token != "" {
+		err = g.tokenService.RevokeToken(c.Request.Context(), req.Token)
+	} else {
+		expiresAt := time.Now().Add(g.tokenService.MaxTokenLifetime())
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt
+		}
+		err = g.tokenService.RevokeTokenID(c.Request.Context(), req.JTI, expiresAt)
+	}
+
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的令牌"})
+			return
+		}
+		logger.Errorf("吊销令牌失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "令牌已吊销"})
+}
+
+// revokeUserTokensHandler 吊销用户此前签发的所有令牌
+func (g *Gateway) revokeUserTokensHandler(c *gin.Context) {
+	userID := c.Param("id")
+	if err := g.tokenService.RevokeUserTokens(c.Request.Context(), userID); err != nil {
+		logger.Errorf("吊销用户令牌失败 %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+		return
+	}
+
+	logger.Infof("已吊销用户 %s 的所有令牌", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "用户令牌已吊销"})
+}
+
 // statusHandler 状态处理器
 func (g *Gateway) statusHandler(c *gin.Context) {
+	table := g.currentTable()
+	_, version, loadedAt := table.snapshot()
 	status := map[string]interface{}{
-		"uptime":        time.Since(time.Now()).String(),
-		"load_balancers": make(map[string]interface{}),
-		"cache_stats":   "enabled",
+		"uptime":           time.Since(time.Now()).String(),
+		"load_balancers":   make(map[string]interface{}),
+		"cache_stats":      "enabled",
+		"config_version":   version,
+		"config_loaded_at": loadedAt,
 	}
 
-	for path, lb := range g.loadBalancers {
+	for path, lb := range table.loadBalancers {
 		backends := lb.GetBackends()
 		backendInfo := make([]map[string]interface{}, len(backends))
 		
@@ -566,18 +880,23 @@ func (g *Gateway) statusHandler(c *gin.Context) {
 func (g *Gateway) backendsHandler(c *gin.Context) {
 	backends := make(map[string][]map[string]interface{})
 
-	for path, lb := range g.loadBalancers {
+	for path, lb := range g.currentTable().loadBalancers {
 		backendList := lb.GetBackends()
 		backends[path] = make([]map[string]interface{}, len(backendList))
 		
 		for i, backend := range backendList {
-			backends[path][i] = map[string]interface{}{
+			info := map[string]interface{}{
 				"url":         backend.URL.String(),
 				"healthy":     backend.IsHealthy(),
 				"connections": backend.GetCurrentConnections(),
 				"weight":      backend.Weight,
 				"last_check":  backend.LastCheck,
+				"circuit":     backend.CircuitState().String(),
+			}
+			if cb := backend.GetCircuitBreaker(); cb != nil && !cb.StateChangedAt().IsZero() {
+				info["circuit_changed_at"] = cb.StateChangedAt()
 			}
+			backends[path][i] = info
 		}
 	}
 
@@ -598,7 +917,7 @@ func (g *Gateway) updateBackendHealthHandler(c *gin.Context) {
 
 	// 更新所有负载均衡器中的后端状态
 	updated := false
-	for _, lb := range g.loadBalancers {
+	for _, lb := range g.currentTable().loadBalancers {
 		lb.UpdateBackendHealth(req.Backend, req.Healthy)
 		updated = true
 	}
@@ -619,6 +938,19 @@ func (g *Gateway) Start() error {
 	// 启动健康检查器
 	go g.healthChecker.Start(context.Background())
 
+	// 用户文件变化时重新加载
+	if store, ok := g.userService.(*auth.FileUserStore); ok && g.config.Auth.Users.ReloadInterval > 0 {
+		g.runTask(func(ctx context.Context) {
+			store.Watch(ctx, g.config.Auth.Users.ReloadInterval)
+		})
+	}
+
+	// 定期保存配额快照，停止时保存最后一次
+	g.started.Store(true)
+	g.runTask(func(ctx context.Context) {
+		g.quotas.Run(ctx, g.config.Quota.SnapshotInterval)
+	})
+
 	// 定期更新系统指标
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -632,7 +964,7 @@ func (g *Gateway) Start() error {
 	// 创建HTTP服务器
 	g.server = &http.Server{
 		Addr:           fmt.Sprintf("%s:%d", g.config.Server.Host, g.config.Server.Port),
-		Handler:        g.router,
+		Handler:        g,
 		ReadTimeout:    g.config.Server.ReadTimeout,
 		WriteTimeout:   g.config.Server.WriteTimeout,
 		IdleTimeout:    g.config.Server.IdleTimeout,
@@ -643,7 +975,12 @@ func (g *Gateway) Start() error {
 
 	// 启动HTTPS或HTTP服务器
 	if g.config.Server.TLS.Enabled {
-		return g.server.ListenAndServeTLS(g.config.Server.TLS.CertFile, g.config.Server.TLS.KeyFile)
+		tlsConfig, err := newServerTLSConfig(g.config.Server.TLS)
+		if err != nil {
+			return err
+		}
+		g.server.TLSConfig = tlsConfig
+		return g.server.ListenAndServeTLS("", "")
 	}
 	
 	return g.server.ListenAndServe()
@@ -656,17 +993,41 @@ func (g *Gateway) Stop(ctx context.Context) error {
 	// 停止健康检查器
 	g.healthChecker.Stop()
 
+	// 关闭上游空闲连接
+	for transport := range g.currentTable().transports() {
+		transport.CloseIdleConnections()
+	}
+
+	// 优雅关闭HTTP服务器，等待处理中的请求完成
+	var err error
+	if g.server != nil {
+		err = g.server.Shutdown(ctx)
+	}
+
+	// 停止后台任务，配额快照在任务退出时保存；未启动时直接保存
+	g.stopBackground()
+	g.tasks.Wait()
+	if !g.started.Load() {
+		if err := g.quotas.Save(); err != nil {
+			logger.Errorf("保存配额快照失败: %v", err)
+		}
+	}
+
 	// 关闭缓存连接
 	if err := g.cache.Close(); err != nil {
 		logger.Errorf("关闭缓存连接失败: %v", err)
 	}
 
-	// 优雅关闭HTTP服务器
-	if g.server != nil {
-		return g.server.Shutdown(ctx)
-	}
+	return err
+}
 
-	return nil
+// runTask 在后台运行任务，Stop时取消ctx并等待任务退出
+func (g *Gateway) runTask(task func(ctx context.Context)) {
+	g.tasks.Add(1)
+	go func() {
+		defer g.tasks.Done()
+		task(g.background)
+	}()
 }
 
 // generateRequestID 生成请求ID
@@ -3,14 +3,38 @@ package gateway
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"api-gateway/internal/auth"
 	"api-gateway/internal/config"
 )
 
@@ -28,6 +52,7 @@ func TestGatewayCreation(t *testing.T) {
 			TokenExpiry:   24 * time.Hour,
 			RefreshExpiry: 7 * 24 * time.Hour,
 			Issuer:        "test-gateway",
+			Users:         config.UserStoreConfig{Store: "mock", AllowMock: true},
 		},
 		Logging: config.LoggingConfig{
 			Level:  "info",
@@ -46,7 +71,7 @@ func TestGatewayCreation(t *testing.T) {
 	require.NotNil(t, gateway)
 
 	assert.Equal(t, cfg, gateway.config)
-	assert.NotNil(t, gateway.router)
+	assert.NotNil(t, gateway.currentTable().router)
 	assert.NotNil(t, gateway.cache)
 	assert.NotNil(t, gateway.tokenService)
 	assert.NotNil(t, gateway.userService)
@@ -59,7 +84,7 @@ func TestHealthCheckEndpoint(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/health", nil)
-	gateway.router.ServeHTTP(w, req)
+	gateway.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	
@@ -84,7 +109,7 @@ func TestLoginEndpoint(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
-	gateway.router.ServeHTTP(w, req)
+	gateway.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	
@@ -105,7 +130,7 @@ func TestLoginEndpoint(t *testing.T) {
 	w = httptest.NewRecorder()
 	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
-	gateway.router.ServeHTTP(w, req)
+	gateway.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
@@ -118,7 +143,7 @@ func TestAuthMiddleware(t *testing.T) {
 	// 测试无token访问需要认证的端点
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/admin/status", nil)
-	gateway.router.ServeHTTP(w, req)
+	gateway.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 
@@ -129,13 +154,18 @@ func TestAuthMiddleware(t *testing.T) {
 	w = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/admin/status", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
-	gateway.router.ServeHTTP(w, req)
+	gateway.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
 func TestGracefulShutdown(t *testing.T) {
 	cfg := createTestConfig()
+	cfg.Quota = config.QuotaConfig{
+		TimeZone:         "UTC",
+		SnapshotFile:     filepath.Join(t.TempDir(), "quotas.json"),
+		SnapshotInterval: time.Hour,
+	}
 	gateway, err := NewGateway(cfg)
 	require.NoError(t, err)
 
@@ -153,6 +183,1796 @@ func TestGracefulShutdown(t *testing.T) {
 
 	err = gateway.Stop(ctx)
 	assert.NoError(t, err)
+
+	// 停止时取消后台任务，配额快照在任务退出前保存
+	assert.FileExists(t, cfg.Quota.SnapshotFile)
+}
+
+func TestProxyRetriesOnDifferentBackend(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	var healthyHits int
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits++
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer healthy.Close()
+
+	cfg := createTestConfig()
+	cfg.Routes = []config.RouteConfig{createRetryTestRoute(failing.URL, healthy.URL)}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	// GET请求在503后重试到另一个后端
+	for i := 0; i < 2; i++ {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/retry/items", nil)
+		gateway.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// PUT请求体在重试时被重放
+	w := newProxyRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/retry/items", bytes.NewBufferString("payload"))
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "payload", w.Body.String())
+	assert.Equal(t, 3, healthyHits)
+}
+
+func TestProxyDoesNotRetryNonIdempotentByDefault(t *testing.T) {
+	var hits int
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	cfg := createTestConfig()
+	cfg.Routes = []config.RouteConfig{createRetryTestRoute(failing.URL, failing.URL+"/")}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	w := newProxyRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/retry/items", bytes.NewBufferString("{}"))
+	gateway.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 1, hits)
+}
+
+func TestProxyBackendTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	cfg := createTestConfig()
+	route := createRetryTestRoute(slow.URL)
+	route.Backends[0].Timeout = 20 * time.Millisecond
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	w := newProxyRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/retry/items", nil)
+	gateway.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "upstream_timeout", response["code"])
+}
+
+func TestProxyMaxBodySize(t *testing.T) {
+	var received int
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		received = len(body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer echo.Close()
+
+	cfg := createTestConfig()
+	route := createRetryTestRoute(echo.URL, echo.URL+"/")
+	route.MaxBodySize = 1024
+	route.RetryPolicy.MaxBufferSize = 16
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	// 声明长度超过限制
+	w := newProxyRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/retry/items", bytes.NewReader(make([]byte, 2048)))
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// 未声明长度的请求体在流式转发时超过限制
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/retry/items", io.MultiReader(bytes.NewReader(make([]byte, 2048))))
+	req.ContentLength = -1
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// 超过重放缓存上限的请求体直接流式转发，不再重试
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/retry/items", bytes.NewReader(make([]byte, 512)))
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 512, received)
+}
+
+func TestReloadSwapsRoutes(t *testing.T) {
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+	}))
+	defer first.Close()
+
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("second"))
+	}))
+	defer second.Close()
+
+	cfg := createTestConfig()
+	cfg.Routes = []config.RouteConfig{createRetryTestRoute(first.URL)}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), gateway.configVersion())
+
+	w := newProxyRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/retry/items", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, "first", w.Body.String())
+
+	// 新配置替换路由和后端服务
+	reloaded := createTestConfig()
+	route := createRetryTestRoute(second.URL)
+	route.Path = "/api/v2/retry"
+	reloaded.Routes = []config.RouteConfig{route}
+	require.NoError(t, gateway.Reload(reloaded))
+	assert.Equal(t, int64(2), gateway.configVersion())
+
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("GET", "/api/v2/retry/items", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, "second", w.Body.String())
+
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/retry/items", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 校验失败的配置不生效
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  port: 70000\n"), 0644))
+	assert.Error(t, gateway.ReloadFromFile(path))
+	assert.Equal(t, int64(2), gateway.configVersion())
+
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("GET", "/api/v2/retry/items", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, "second", w.Body.String())
+
+	// 版本号通过管理接口公开
+	token, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("GET", "/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	gateway.ServeHTTP(w, req)
+
+	var status map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, float64(2), status["config_version"])
+}
+
+func TestAdminRouteManagement(t *testing.T) {
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+	}))
+	defer first.Close()
+
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("second"))
+	}))
+	defer second.Close()
+
+	cfg := createTestConfig()
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	token, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	admin := func(method, path, body string) *proxyRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		gateway.ServeHTTP(w, req)
+		return w
+	}
+
+	// 创建路由
+	w := admin("POST", "/admin/routes", `{"path": "/api/v1/orders", "method": "GET", "timeout": "5s", "backends": [{"url": "`+first.URL+`", "weight": 1}]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var route map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &route))
+	assert.Equal(t, "api-v1-orders", route["id"])
+	assert.Equal(t, "5s", route["timeout"])
+
+	w = newProxyRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/orders/1", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, "first", w.Body.String())
+
+	// 校验失败的路由不生效
+	w = admin("POST", "/admin/routes", `{"path": "/api/v1/orders", "method": "GET", "backends": [{"url": "`+second.URL+`"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 替换后端服务
+	w = admin("POST", "/admin/routes/api-v1-orders/backends", `{"url": "`+second.URL+`", "weight": 1}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = admin("DELETE", "/admin/routes/api-v1-orders/backends?url="+first.URL, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	for i := 0; i < 2; i++ {
+		w = newProxyRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/orders/1", nil)
+		gateway.ServeHTTP(w, req)
+		assert.Equal(t, "second", w.Body.String())
+	}
+
+	w = admin("DELETE", "/admin/routes/api-v1-orders/backends?url="+first.URL, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 删除路由
+	w = admin("DELETE", "/admin/routes/api-v1-orders", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = newProxyRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/orders/1", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 配置中的密钥被隐藏
+	w = admin("GET", "/admin/config", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var effective struct {
+		Version int64                  `json:"version"`
+		Config  map[string]interface{} `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &effective))
+	assert.Equal(t, int64(5), effective.Version)
+	assert.Equal(t, "******", effective.Config["auth"].(map[string]interface{})["jwt_secret"])
+	assert.NotContains(t, w.Body.String(), "test-secret")
+}
+
+func TestAuthorization(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	route := createRetryTestRoute(backend.URL)
+	route.RequiredRoles = []string{"user", "admin"}
+	route.MethodRules = []config.MethodRule{
+		{Methods: []string{"DELETE"}, RequiredRoles: []string{"admin"}},
+	}
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	userToken, err := gateway.tokenService.GenerateToken("2", "user", "user@example.com", []string{"user"})
+	require.NoError(t, err)
+	guestToken, err := gateway.tokenService.GenerateToken("2", "user", "user@example.com", []string{"guest"})
+	require.NoError(t, err)
+
+	request := func(method, path, token string) int {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString("{}"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		gateway.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 管理端点需要admin角色
+	assert.Equal(t, http.StatusForbidden, request("POST", "/admin/backends/health", userToken))
+	assert.Equal(t, http.StatusForbidden, request("GET", "/admin/status", userToken))
+
+	// 路由级角色和方法规则
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/v1/retry/items", ""))
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/v1/retry/items", guestToken))
+	assert.Equal(t, http.StatusOK, request("GET", "/api/v1/retry/items", userToken))
+	assert.Equal(t, http.StatusForbidden, request("DELETE", "/api/v1/retry/items", userToken))
+}
+
+func TestTokenRevocation(t *testing.T) {
+	cfg := createTestConfig()
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		gateway.ServeHTTP(w, req)
+		return w
+	}
+	login := func() (string, string) {
+		w := request("POST", "/auth/login", "", map[string]string{"username": "admin", "password": "password123"})
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response["access_token"].(string), response["refresh_token"].(string)
+	}
+
+	// 登出后访问令牌和刷新令牌均失效
+	accessToken, refreshToken := login()
+	assert.Equal(t, http.StatusOK, request("GET", "/admin/status", accessToken, nil).Code)
+	assert.Equal(t, http.StatusOK, request("POST", "/auth/logout", accessToken, map[string]string{"refresh_token": refreshToken}).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/status", accessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}).Code)
+
+	// 管理员吊销指定令牌
+	accessToken, refreshToken = login()
+	otherToken, _ := login()
+	assert.Equal(t, http.StatusOK, request("POST", "/admin/tokens/revoke", accessToken, map[string]string{"token": otherToken}).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/status", otherToken, nil).Code)
+	assert.Equal(t, http.StatusOK, request("GET", "/admin/status", accessToken, nil).Code)
+
+	// 吊销用户的所有令牌，签发时间按毫秒比较
+	assert.Equal(t, http.StatusOK, request("POST", "/admin/users/1/revoke-tokens", accessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/admin/status", accessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/auth/refresh", "", map[string]string{"refresh_token": refreshToken}).Code)
+
+	// 吊销之后签发的令牌不受影响
+	time.Sleep(2 * time.Millisecond)
+	accessToken, _ = login()
+	assert.Equal(t, http.StatusOK, request("GET", "/admin/status", accessToken, nil).Code)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	cfg := createTestConfig()
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	request := func(path, token string, body interface{}) (int, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		gateway.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	refresh := func(token string) (int, map[string]interface{}) {
+		return request("/auth/refresh", "", map[string]string{"refresh_token": token})
+	}
+
+	code, response := request("/auth/login", "", map[string]string{"username": "admin", "password": "password123"})
+	require.Equal(t, http.StatusOK, code)
+	accessToken := response["access_token"].(string)
+	firstRefresh := response["refresh_token"].(string)
+
+	// 令牌类型不能混用
+	code, _ = request("/admin/backends/health", firstRefresh, nil)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = refresh(accessToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// 刷新后保留用户信息并轮换刷新令牌
+	code, response = refresh(firstRefresh)
+	require.Equal(t, http.StatusOK, code)
+	secondRefresh := response["refresh_token"].(string)
+	assert.NotEqual(t, firstRefresh, secondRefresh)
+
+	claims, err := gateway.tokenService.ValidateToken(response["access_token"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Username)
+	assert.Equal(t, "admin@example.com", claims.Email)
+	assert.ElementsMatch(t, []string{"admin", "user"}, claims.Roles)
+
+	code, response = refresh(secondRefresh)
+	require.Equal(t, http.StatusOK, code)
+	thirdRefresh := response["refresh_token"].(string)
+
+	// 再次使用已轮换的刷新令牌时吊销整个家族
+	code, _ = refresh(firstRefresh)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = refresh(thirdRefresh)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestAsymmetricSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+		return path
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	rsaFile := writePEM("rsa.pem", "PRIVATE KEY", der)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edFile := writePEM("ed25519.pem", "PRIVATE KEY", der)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+	ecFile := writePEM("ec.pub.pem", "PUBLIC KEY", der)
+
+	cfg := createTestConfig()
+	cfg.Auth.SigningKeys = []config.SigningKeyConfig{
+		{ID: "old", Algorithm: "EdDSA", PrivateKeyFile: edFile},
+		{ID: "new", Algorithm: "RS256", PrivateKeyFile: rsaFile},
+		{ID: "ec", Algorithm: "ES256", PublicKeyFile: ecFile},
+	}
+	cfg.Auth.SigningKeyID = "new"
+	require.NoError(t, cfg.Validate())
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	// 使用指定密钥签发，令牌头部包含kid
+	token, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", parsed.Header["kid"])
+	assert.Equal(t, "RS256", parsed.Method.Alg())
+	_, err = gateway.tokenService.ValidateToken(token)
+	require.NoError(t, err)
+
+	// 轮换中的旧密钥签发的令牌仍然有效
+	oldCfg := cfg.Auth
+	oldCfg.SigningKeyID = "old"
+	oldKeyring, err := auth.NewKeyring(oldCfg)
+	require.NoError(t, err)
+	claims := auth.Claims{
+		UserID: "1",
+		Type:   auth.TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	oldToken, err := oldKeyring.Sign(claims)
+	require.NoError(t, err)
+	_, err = gateway.tokenService.ValidateToken(oldToken)
+	require.NoError(t, err)
+
+	// 未配置的算法不被接受
+	hsKeyring, err := auth.NewKeyring(config.AuthConfig{JWTSecret: cfg.Auth.JWTSecret})
+	require.NoError(t, err)
+	hsToken, err := hsKeyring.Sign(claims)
+	require.NoError(t, err)
+	_, err = gateway.tokenService.ValidateToken(hsToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	// JWKS只发布公钥
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	gateway.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var jwks auth.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 3)
+	assert.Equal(t, "EC", jwks.Keys[0].KeyType)
+	assert.Equal(t, "P-256", jwks.Keys[0].Curve)
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	assert.Equal(t, "OKP", jwks.Keys[2].KeyType)
+	assert.NotContains(t, w.Body.String(), `"d"`)
+
+	// 移除旧密钥后其签发的令牌失效
+	newCfg, err := cfg.Clone()
+	require.NoError(t, err)
+	newCfg.Auth.SigningKeys = newCfg.Auth.SigningKeys[1:]
+	require.NoError(t, gateway.Reload(newCfg))
+	_, err = gateway.tokenService.ValidateToken(oldToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	_, err = gateway.tokenService.ValidateToken(token)
+	assert.NoError(t, err)
+}
+
+func TestExternalOIDCTokens(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	rsaJWK := func(kid string, key *rsa.PrivateKey) auth.JSONWebKey {
+		return auth.JSONWebKey{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:         "AQAB",
+		}
+	}
+	firstKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	secondKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var mutex sync.Mutex
+	jwks := auth.JSONWebKeySet{Keys: []auth.JSONWebKey{rsaJWK("k1", firstKey)}}
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "jwks_uri": idp.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+
+	// 第二个身份提供方使用本地JWKS文件
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	data, err := json.Marshal(auth.JSONWebKeySet{Keys: []auth.JSONWebKey{{
+		KeyType: "OKP", KeyID: "ed", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPublic),
+	}}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(jwksFile, data, 0644))
+
+	cfg := createTestConfig()
+	route := createRetryTestRoute(backend.URL)
+	route.RequiredRoles = []string{"user"}
+	cfg.Routes = []config.RouteConfig{route}
+	cfg.Auth.OIDC = []config.OIDCConfig{
+		{
+			Issuer:     idp.URL,
+			Audiences:  []string{"api-gateway"},
+			Algorithms: []string{"RS256"},
+			ClockSkew:  30 * time.Second,
+			Claims:     config.ClaimMapping{UserID: "sub", Roles: "realm_access.roles", Scope: "scope"},
+		},
+		{
+			Issuer:     "https://files.example.com",
+			Audiences:  []string{"orders", "api-gateway"},
+			Algorithms: []string{"EdDSA"},
+			JWKSFile:   jwksFile,
+			Claims:     config.ClaimMapping{UserID: "uid", Roles: "groups"},
+		},
+	}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	idpClaims := func(modify func(jwt.MapClaims)) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss":          idp.URL,
+			"aud":          []string{"api-gateway"},
+			"sub":          "external-user",
+			"exp":          time.Now().Add(time.Hour).Unix(),
+			"realm_access": map[string]interface{}{"roles": []string{"user"}},
+		}
+		if modify != nil {
+			modify(claims)
+		}
+		return claims
+	}
+	request := func(token string) int {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/retry/items", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		gateway.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(nil))))
+
+	claims, err := gateway.tokenValidator.ValidateToken(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["scope"] = "orders:read orders:write"
+	})))
+	require.NoError(t, err)
+	assert.Equal(t, "external-user", claims.UserID)
+	assert.Equal(t, []string{"user"}, claims.Roles)
+	assert.Equal(t, []string{"orders:read", "orders:write"}, claims.Scopes())
+
+	// aud、iss、exp和nbf校验，允许时钟偏差
+	assert.Equal(t, http.StatusUnauthorized, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["aud"] = "other-service"
+	}))))
+	assert.Equal(t, http.StatusUnauthorized, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["iss"] = "https://unknown.example.com"
+	}))))
+	assert.Equal(t, http.StatusOK, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	}))))
+	assert.Equal(t, http.StatusUnauthorized, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+	}))))
+	assert.Equal(t, http.StatusUnauthorized, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["nbf"] = time.Now().Add(2 * time.Minute).Unix()
+	}))))
+	assert.Equal(t, http.StatusUnauthorized, request(sign(jwt.SigningMethodRS256, "k1", secondKey, idpClaims(nil))))
+
+	// 映射的角色用于授权
+	assert.Equal(t, http.StatusForbidden, request(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["realm_access"] = map[string]interface{}{"roles": []string{"guest"}}
+	}))))
+
+	// 身份提供方轮换密钥后遇到未知kid时重新获取JWKS
+	mutex.Lock()
+	jwks.Keys = append(jwks.Keys, rsaJWK("k2", secondKey))
+	mutex.Unlock()
+	assert.Equal(t, http.StatusOK, request(sign(jwt.SigningMethodRS256, "k2", secondKey, idpClaims(nil))))
+
+	// 第二个身份提供方
+	assert.Equal(t, http.StatusOK, request(sign(jwt.SigningMethodEdDSA, "ed", edKey, jwt.MapClaims{
+		"iss":    "https://files.example.com",
+		"aud":    "orders",
+		"uid":    "file-user",
+		"groups": "user auditor",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})))
+
+	// 外部令牌登出后失效，缺少jti的令牌无法吊销
+	logout := func(token string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/auth/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		gateway.ServeHTTP(w, req)
+		return w.Code
+	}
+	externalToken := sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(func(c jwt.MapClaims) {
+		c["jti"] = "external-jti"
+	}))
+	assert.Equal(t, http.StatusOK, logout(externalToken))
+	assert.Equal(t, http.StatusUnauthorized, request(externalToken))
+	assert.Equal(t, http.StatusBadRequest, logout(sign(jwt.SigningMethodRS256, "k1", firstKey, idpClaims(nil))))
+
+	// 网关自己签发的令牌仍然有效
+	localToken, err := gateway.tokenService.GenerateToken("2", "user", "user@example.com", []string{"user"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(localToken))
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	var forwarded atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(r.Header.Get("X-API-Key") + "|" + r.URL.RawQuery)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "api-keys.json")
+	cfg := createTestConfig()
+	route := createRetryTestRoute(backend.URL)
+	route.RequiredRoles = []string{"service"}
+	cfg.Routes = []config.RouteConfig{route}
+	cfg.Auth.APIKeys = config.APIKeyConfig{
+		Enabled:    true,
+		Header:     "X-API-Key",
+		QueryParam: "api_key",
+		Store:      "file",
+		File:       keyFile,
+	}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	adminToken, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	admin := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		gateway.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	request := func(path, key string) int {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		gateway.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	code, response := admin("POST", "/admin/api-keys", map[string]interface{}{
+		"owner":  "billing-service",
+		"roles":  []string{"service"},
+		"routes": []string{"/api/v1/retry"},
+		"tier":   "gold",
+	})
+	require.Equal(t, http.StatusCreated, code)
+	key := response["key"].(string)
+	id := response["api_key"].(map[string]interface{})["id"].(string)
+	assert.NotContains(t, response["api_key"], "hash")
+
+	// 密钥不会转发给后端
+	assert.Equal(t, http.StatusOK, request("/api/v1/retry/items", key))
+	assert.Equal(t, "|", forwarded.Load())
+	assert.Equal(t, http.StatusOK, request("/api/v1/retry/items?api_key="+key+"&page=2", ""))
+	assert.Equal(t, "|page=2", forwarded.Load())
+
+	// 限制可访问的路由
+	assert.Equal(t, http.StatusForbidden, request("/admin/status", key))
+	assert.Equal(t, http.StatusUnauthorized, request("/api/v1/retry/items", "gw_"+id+"_wrong"))
+
+	// 文件只保存哈希
+	data, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), key)
+	assert.Contains(t, string(data), "billing-service")
+
+	code, response = admin("GET", "/admin/api-keys", nil)
+	require.Equal(t, http.StatusOK, code)
+	keys := response["api_keys"].([]interface{})
+	require.Len(t, keys, 1)
+	assert.Equal(t, "gold", keys[0].(map[string]interface{})["tier"])
+	assert.NotContains(t, keys[0], "hash")
+
+	// 轮换后旧密钥失效
+	code, response = admin("POST", "/admin/api-keys/"+id+"/rotate", nil)
+	require.Equal(t, http.StatusOK, code)
+	rotated := response["key"].(string)
+	assert.Equal(t, http.StatusUnauthorized, request("/api/v1/retry/items", key))
+	assert.Equal(t, http.StatusOK, request("/api/v1/retry/items", rotated))
+
+	// 重新加载文件后密钥仍然有效
+	store, err := auth.NewFileAPIKeyStore(keyFile)
+	require.NoError(t, err)
+	authenticated, err := auth.NewAPIKeyService(store).Authenticate(context.Background(), rotated)
+	require.NoError(t, err)
+	assert.Equal(t, "billing-service", authenticated.Owner)
+
+	// 吊销
+	code, _ = admin("DELETE", "/admin/api-keys/"+id, nil)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusUnauthorized, request("/api/v1/retry/items", rotated))
+	code, _ = admin("DELETE", "/admin/api-keys/missing", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestFileUserStoreAndLockout(t *testing.T) {
+	adminHash, err := auth.HashPassword("admin-secret")
+	require.NoError(t, err)
+	salt := []byte("0123456789abcdef")
+	argonHash := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("ops-secret"), salt, 1, 1024, 1, 32)))
+
+	usersFile := filepath.Join(t.TempDir(), "users.yaml")
+	writeUsers := func(extra string) {
+		content := fmt.Sprintf(`users:
+  - id: "1"
+    username: admin
+    roles: [admin]
+    password_hash: "%s"
+  - id: "2"
+    username: ops
+    roles: [user]
+    password_hash: "%s"
+%s`, adminHash, argonHash, extra)
+		require.NoError(t, os.WriteFile(usersFile, []byte(content), 0600))
+	}
+	writeUsers("")
+
+	cfg := createTestConfig()
+	cfg.Auth.Users = config.UserStoreConfig{Store: "file", File: usersFile}
+	cfg.Auth.Lockout = config.LockoutConfig{MaxAttempts: 3, Window: time.Minute, BaseDuration: time.Minute, MaxDuration: time.Hour}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	post := func(path, token string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		gateway.ServeHTTP(w, req)
+		return w
+	}
+	login := func(username, password string) *httptest.ResponseRecorder {
+		return post("/auth/login", "", map[string]string{"username": username, "password": password})
+	}
+
+	// bcrypt和argon2id哈希
+	w := login("admin", "admin-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	adminToken := response["access_token"].(string)
+	assert.Equal(t, http.StatusOK, login("ops", "ops-secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("admin", "password123").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("nobody", "password123").Code)
+
+	// 连续失败后锁定，正确的密码也被拒绝
+	assert.Equal(t, http.StatusUnauthorized, login("ops", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("ops", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("ops", "wrong").Code)
+	w = login("ops", "ops-secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// 再次被锁定时锁定时长翻倍
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		_, err := gateway.loginLockout.RecordFailure(ctx, "OPS")
+		require.NoError(t, err)
+	}
+	duration, err := gateway.loginLockout.RecordFailure(ctx, "ops")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, duration)
+
+	// 管理员创建和禁用用户
+	w = post("/admin/users", adminToken, map[string]interface{}{"username": "carol", "password": "carol-secret", "roles": []string{"admin"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	carolID := response["id"].(string)
+	assert.Equal(t, http.StatusConflict, post("/admin/users", adminToken, map[string]interface{}{"username": "carol", "password": "carol-secret"}).Code)
+
+	w = login("carol", "carol-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	carolToken := response["access_token"].(string)
+
+	data, err := os.ReadFile(usersFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "carol")
+	assert.NotContains(t, string(data), "carol-secret")
+
+	assert.Equal(t, http.StatusOK, post("/admin/users/"+carolID+"/disable", adminToken, nil).Code)
+	assert.Equal(t, http.StatusForbidden, login("carol", "carol-secret").Code)
+	active, err := gateway.userService.IsUserActive(carolID)
+	require.NoError(t, err)
+	assert.False(t, active)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer "+carolToken)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusNotFound, post("/admin/users/missing/disable", adminToken, nil).Code)
+
+	// 用户文件变化后重新加载
+	daveHash, err := auth.HashPassword("dave-secret")
+	require.NoError(t, err)
+	writeUsers(fmt.Sprintf(`  - username: dave
+    roles: [user]
+    password_hash: "%s"
+`, daveHash))
+	require.NoError(t, gateway.userService.(*auth.FileUserStore).Reload())
+	assert.Equal(t, http.StatusOK, login("dave", "dave-secret").Code)
+}
+
+func TestHTTPUserStore(t *testing.T) {
+	users := map[string]auth.User{
+		"u-1": {ID: "u-1", Username: "alice", Roles: []string{"admin"}, Active: true},
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer upstream-token", r.Header.Get("Authorization"))
+		switch {
+		case r.URL.Path == "/authenticate":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["username"] != "alice" || req["password"] != "alice-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(users["u-1"])
+		case strings.HasPrefix(r.URL.Path, "/users/"):
+			user, ok := users[strings.TrimPrefix(r.URL.Path, "/users/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(user)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := createTestConfig()
+	cfg.Auth.Users = config.UserStoreConfig{Store: "http", URL: upstream.URL, Token: "upstream-token", Timeout: time.Second}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(map[string]string{"username": "alice", "password": password})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		gateway.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+	w := login("alice-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// 认证中间件通过上游用户服务检查用户状态
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer "+response["access_token"].(string))
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 上游用户存储不支持管理用户
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/users", bytes.NewBufferString(`{"username":"bob","password":"bob-secret"}`))
+	req.Header.Set("Authorization", "Bearer "+response["access_token"].(string))
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestIdentityHeaders(t *testing.T) {
+	verifier := auth.NewIdentitySigner("identity-secret", "X-Gateway-Signature")
+	var forwarded atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(r.Header.Clone())
+		if err := verifier.Verify(r, time.Minute); err != nil {
+			w.Header().Set("X-Verify-Error", err.Error())
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	headers := map[string]string{"user_id": "X-User-ID", "username": "X-Username", "roles": "X-User-Roles"}
+	cfg := createTestConfig()
+	cfg.Auth.IdentitySigning = config.IdentitySigningConfig{Secret: "identity-secret", Header: "X-Gateway-Signature"}
+	private := createRetryTestRoute(backend.URL)
+	private.AuthRequired = true
+	private.IdentityHeaders = headers
+	public := createRetryTestRoute(backend.URL)
+	public.Path = "/api/v1/public"
+	public.IdentityHeaders = headers
+	cfg.Routes = []config.RouteConfig{private, public}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	token, err := gateway.tokenService.GenerateToken("2", "alice", "alice@example.com", []string{"user", "ops"})
+	require.NoError(t, err)
+	request := func(path, token string) (*httptest.ResponseRecorder, http.Header) {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("X-User-ID", "999")
+		req.Header.Set("X-User-Roles", "admin")
+		req.Header.Set("X-Gateway-Signature", "t=0,h=x-user-id,v1=forged")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		forwarded.Store(http.Header{})
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder, forwarded.Load().(http.Header)
+	}
+
+	// 认证后的身份覆盖客户端伪造的身份头，并附带可验证的签名
+	w, got := request("/api/v1/retry/orders", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Verify-Error"))
+	assert.Equal(t, "2", got.Get("X-User-ID"))
+	assert.Equal(t, "alice", got.Get("X-Username"))
+	assert.Equal(t, "user,ops", got.Get("X-User-Roles"))
+	assert.Contains(t, got.Get("X-Gateway-Signature"), "h=x-user-id;x-user-roles;x-username")
+
+	// 修改身份头后签名失效
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header = got.Clone()
+	req.Header.Set("X-User-Roles", "admin")
+	assert.ErrorIs(t, verifier.Verify(req, time.Minute), auth.ErrInvalidIdentitySignature)
+	req.Header = got.Clone()
+	req.URL.Path = "/admin"
+	assert.ErrorIs(t, verifier.Verify(req, time.Minute), auth.ErrInvalidIdentitySignature)
+
+	// 未认证的请求只移除客户端提供的身份头
+	w, got = request("/api/v1/public/orders", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, got.Get("X-User-ID"))
+	assert.Empty(t, got.Get("X-User-Roles"))
+	assert.Empty(t, got.Get("X-Gateway-Signature"))
+}
+
+func TestForwardAuth(t *testing.T) {
+	var calls int32
+	authorizer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req struct {
+			Method  string            `json:"method"`
+			Path    string            `json:"path"`
+			Headers map[string]string `json:"headers"`
+			Claims  struct {
+				UserID string   `json:"user_id"`
+				Roles  []string `json:"roles"`
+			} `json:"claims"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "GET", req.Method)
+		assert.Equal(t, "1", req.Claims.UserID)
+		assert.NotContains(t, req.Headers, "Authorization")
+
+		switch req.Path {
+		case "/api/v1/retry/denied":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"reason":"policy"}`))
+		case "/api/v1/retry/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api/v1/retry/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.Header().Set("X-Policy-Tenant", req.Headers["X-Tenant"])
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer authorizer.Close()
+
+	var tenant atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant.Store(r.Header.Get("X-Policy-Tenant"))
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	cfg.Auth.ForwardAuth = config.ForwardAuthConfig{
+		URL:             authorizer.URL,
+		Timeout:         50 * time.Millisecond,
+		CacheTTL:        time.Minute,
+		RequestHeaders:  []string{"X-Tenant"},
+		IncludeClaims:   true,
+		UpstreamHeaders: []string{"X-Policy-Tenant"},
+	}
+	route := createRetryTestRoute(backend.URL)
+	route.AuthRequired = true
+	route.Middleware = []string{"forward_auth"}
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	token, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	request := func(path string) *httptest.ResponseRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Tenant", "acme")
+		req.Header.Set("X-Policy-Tenant", "forged")
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	// 授权通过时将授权服务返回的头复制到上游请求，相同请求使用缓存的结果
+	w := request("/api/v1/retry/orders")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "acme", tenant.Load())
+	w = request("/api/v1/retry/orders")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 拒绝的响应透传给客户端
+	w = request("/api/v1/retry/denied")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"reason":"policy"}`, w.Body.String())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	// 授权服务出错或超时时拒绝请求
+	assert.Equal(t, http.StatusServiceUnavailable, request("/api/v1/retry/broken").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, request("/api/v1/retry/slow").Code)
+
+	// 未通过认证的请求不会发送给授权服务
+	atomic.StoreInt32(&calls, 0)
+	w = newProxyRecorder().ResponseRecorder
+	req, _ := http.NewRequest("GET", "/api/v1/retry/orders", nil)
+	gateway.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestRouteRateLimitHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	cfg.RateLimit = config.RateLimitConfig{Algorithm: "token_bucket", Window: time.Minute}
+	route := createRetryTestRoute(backend.URL)
+	route.RateLimit = 2
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	request := func() *httptest.ResponseRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/retry/orders", nil)
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	// 成功的响应也带有准确的限流响应头
+	w := request()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, `"default";q=2;w=60`, w.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, `"default";r=1;t=30`, w.Header().Get("RateLimit"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	w = request()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	// 被拒绝时Retry-After为下一个令牌补充的时间
+	w = request()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, `"default";r=0;t=60`, w.Header().Get("RateLimit"))
+}
+
+func TestRouteRateLimitKeys(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	cfg.RateLimit = config.RateLimitConfig{Algorithm: "fixed_window", Window: time.Minute}
+	route := createRetryTestRoute(backend.URL)
+	route.RateLimits = []config.RateLimitRule{
+		{Name: "user", Limit: 2},
+		{Name: "tenant", Limit: 3, Key: []string{"header:X-Tenant"}},
+	}
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	request := func(path, clientIP, tenant string) *httptest.ResponseRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = clientIP + ":40000"
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	// 同一路由下不同的路径共享计数，没有租户头时只检查用户限制
+	w := request("/api/v1/retry/users/1", "10.0.0.1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"user";q=2;w=60`, w.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, http.StatusOK, request("/api/v1/retry/users/2", "10.0.0.1", "").Code)
+	w = request("/api/v1/retry/users/3", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Header().Get("RateLimit"), `"user";r=0;t=`)
+
+	// 租户限制由多个用户共享，两条限制都需要满足
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		w = request("/api/v1/retry/orders", ip, "acme")
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, `"user";q=2;w=60, "tenant";q=3;w=60`, w.Header().Get("RateLimit-Policy"))
+	assert.Regexp(t, `^"user";r=1;t=\d+, "tenant";r=0;t=\d+$`, w.Header().Get("RateLimit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	w = request("/api/v1/retry/orders", "10.0.0.5", "acme")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, http.StatusOK, request("/api/v1/retry/orders", "10.0.0.5", "globex").Code)
+
+	// 按令牌中的自定义声明计数
+	route.RateLimits = []config.RateLimitRule{{Name: "org", Limit: 1, Key: []string{"claim:org_id"}}}
+	route.AuthRequired = true
+	cfg.Routes = []config.RouteConfig{route}
+	require.NoError(t, gateway.Reload(cfg))
+
+	keyring, err := auth.NewKeyring(cfg.Auth)
+	require.NoError(t, err)
+	token := func(userID string) string {
+		signed, err := keyring.Sign(jwt.MapClaims{
+			"user_id": userID,
+			"typ":     auth.TokenTypeAccess,
+			"org_id":  "acme",
+			"exp":     time.Now().Add(time.Hour).Unix(),
+		})
+		require.NoError(t, err)
+		return signed
+	}
+	authorized := func(userID string) *httptest.ResponseRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/retry/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+token(userID))
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+	assert.Equal(t, http.StatusOK, authorized("1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, authorized("2").Code)
+}
+
+func TestRouteRateLimitPlans(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	cfg.RateLimit = config.RateLimitConfig{
+		Algorithm:     "fixed_window",
+		Window:        time.Minute,
+		TierClaim:     "tier",
+		AnonymousPlan: "anonymous",
+		DefaultPlan:   "free",
+		Plans: map[string]config.RateLimitPlan{
+			"anonymous": {Rate: 1},
+			"free":      {Rate: 2},
+			"pro":       {Rate: 3, Roles: []string{"pro"}},
+			"gold":      {Rate: 4},
+		},
+	}
+	cfg.Auth.APIKeys = config.APIKeyConfig{
+		Enabled: true,
+		Header:  "X-API-Key",
+		Store:   "file",
+		File:    filepath.Join(t.TempDir(), "api-keys.json"),
+	}
+	plans := []config.RateLimitRule{{Plans: []string{"anonymous", "free", "pro", "gold"}}}
+	private := createRetryTestRoute(backend.URL)
+	private.AuthRequired = true
+	private.RateLimits = plans
+	public := createRetryTestRoute(backend.URL)
+	public.Path = "/api/v1/public"
+	public.RateLimits = plans
+	cfg.Routes = []config.RouteConfig{private, public}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	keyring, err := auth.NewKeyring(cfg.Auth)
+	require.NoError(t, err)
+	token := func(userID string, claims jwt.MapClaims) string {
+		claims["user_id"] = userID
+		claims["typ"] = auth.TokenTypeAccess
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signed, err := keyring.Sign(claims)
+		require.NoError(t, err)
+		return signed
+	}
+	request := func(path string, header http.Header) *httptest.ResponseRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	// exhaust 发送请求直到被限制，返回放行的请求数和最后一个响应
+	exhaust := func(path string, header http.Header) (int, *httptest.ResponseRecorder) {
+		for allowed := 0; allowed < 10; allowed++ {
+			if w := request(path, header); w.Code != http.StatusOK {
+				return allowed, w
+			}
+		}
+		return 10, nil
+	}
+	denied := func(plan string) float64 {
+		return testutil.ToFloat64(gateway.metricsCollector.GetMetrics().RateLimitRequestsTotal.WithLabelValues("denied", plan))
+	}
+
+	// 未认证的请求使用anonymous_plan
+	before := denied("anonymous")
+	w := request("/api/v1/public/items", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"anonymous";q=1;w=60`, w.Header().Get("RateLimit-Policy"))
+	allowed, w := exhaust("/api/v1/public/items", nil)
+	assert.Equal(t, 0, allowed)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, before+1, denied("anonymous"))
+
+	// 没有匹配的角色和等级时使用default_plan，按角色匹配计划，等级声明优先于角色。不同计划分别计数
+	allowed, w = exhaust("/api/v1/retry/items", bearer(token("1", jwt.MapClaims{})))
+	assert.Equal(t, 2, allowed)
+	assert.Equal(t, `"free";q=2;w=60`, w.Header().Get("RateLimit-Policy"))
+	allowed, w = exhaust("/api/v1/retry/items", bearer(token("2", jwt.MapClaims{"roles": []string{"pro"}})))
+	assert.Equal(t, 3, allowed)
+	assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+	allowed, w = exhaust("/api/v1/retry/items", bearer(token("1", jwt.MapClaims{"roles": []string{"pro"}, "tier": "gold"})))
+	assert.Equal(t, 4, allowed)
+	assert.Contains(t, w.Header().Get("RateLimit"), `"gold";r=0;t=`)
+
+	// API密钥的等级
+	adminToken, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	data, _ := json.Marshal(map[string]interface{}{"owner": "billing-service", "tier": "gold"})
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/api-keys", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	gateway.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+
+	before = denied("gold")
+	allowed, _ = exhaust("/api/v1/retry/items", http.Header{"X-Api-Key": {response["key"].(string)}})
+	assert.Equal(t, 4, allowed)
+	assert.Equal(t, before+1, denied("gold"))
+}
+
+func TestQuotas(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	cfg.Quota = config.QuotaConfig{
+		TimeZone:     "UTC",
+		SnapshotFile: filepath.Join(t.TempDir(), "quotas.json"),
+		Groups:       map[string]config.QuotaGroup{"orders": {Daily: 2, Monthly: 100}},
+	}
+	route := createRetryTestRoute(backend.URL)
+	route.AuthRequired = true
+	route.QuotaGroup = "orders"
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	userToken, err := gateway.tokenService.GenerateToken("2", "user", "user@example.com", []string{"user"})
+	require.NoError(t, err)
+	adminToken, err := gateway.tokenService.GenerateToken("1", "admin", "admin@example.com", []string{"admin"})
+	require.NoError(t, err)
+	request := func() *httptest.ResponseRecorder {
+		w := newProxyRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/retry/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+userToken)
+		gateway.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+	admin := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		gateway.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	w := request()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-Quota-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-Quota-Remaining"))
+	assert.Equal(t, http.StatusOK, request().Code)
+
+	// 配额用尽时返回与速率限制不同的错误码
+	w = request()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "quota_exceeded", response["code"])
+
+	code, response := admin("GET", "/admin/quotas/2", nil)
+	require.Equal(t, http.StatusOK, code)
+	quotas := response["quotas"].([]interface{})
+	require.Len(t, quotas, 2)
+	daily := quotas[0].(map[string]interface{})
+	assert.Equal(t, "daily", daily["period"])
+	assert.Equal(t, float64(2), daily["used"])
+	assert.Equal(t, float64(0), daily["remaining"])
+	assert.Equal(t, float64(2), quotas[1].(map[string]interface{})["used"])
+
+	// 授予额外配额
+	code, response = admin("POST", "/admin/quotas/2/grant", map[string]interface{}{"group": "orders", "period": "daily", "amount": 1})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), response["quota"].(map[string]interface{})["remaining"])
+	assert.Equal(t, http.StatusOK, request().Code)
+	assert.Equal(t, http.StatusTooManyRequests, request().Code)
+
+	// 重置
+	code, _ = admin("POST", "/admin/quotas/2/reset", map[string]interface{}{"group": "orders"})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusOK, request().Code)
+
+	code, _ = admin("POST", "/admin/quotas/2/reset", map[string]interface{}{"group": "billing"})
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = admin("POST", "/admin/quotas/2/grant", map[string]interface{}{"group": "orders", "period": "hourly", "amount": 1})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 停止时保存快照
+	require.NoError(t, gateway.Stop(context.Background()))
+	data, err := os.ReadFile(cfg.Quota.SnapshotFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"consumer": "2"`)
+}
+
+func TestConcurrencyQueues(t *testing.T) {
+	release := make(chan struct{})
+	var inflight int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		<-release
+	}))
+	defer slow.Close()
+
+	cfg := createTestConfig()
+	backendRoute := createRetryTestRoute(slow.URL)
+	backendRoute.Path = "/api/v1/backend-queue"
+	backendRoute.Backends[0].MaxConnections = 1
+	backendRoute.Concurrency = config.ConcurrencyConfig{QueueSize: 1, QueueTimeout: time.Second}
+	routeLimited := createRetryTestRoute(slow.URL)
+	routeLimited.Path = "/api/v1/route-queue"
+	routeLimited.Concurrency = config.ConcurrencyConfig{MaxConcurrent: 1, QueueSize: 1, QueueTimeout: 50 * time.Millisecond}
+	cfg.Routes = []config.RouteConfig{backendRoute, routeLimited}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+	m := gateway.metricsCollector.GetMetrics()
+	table := gateway.table.Load()
+
+	// 指标为全局单例，比较测试前后的增量
+	rejected := func(route, queue, reason string) float64 {
+		return testutil.ToFloat64(m.QueueRejectedTotal.WithLabelValues(route, queue, reason))
+	}
+	full := rejected("/api/v1/backend-queue", "backend", "full")
+	timeout := rejected("/api/v1/route-queue", "route", "timeout")
+	canceledBefore := rejected("/api/v1/route-queue", "route", "canceled")
+
+	serve := func(path string) <-chan *proxyRecorder {
+		done := make(chan *proxyRecorder, 1)
+		go func() {
+			w := newProxyRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			gateway.ServeHTTP(w, req)
+			done <- w
+		}()
+		return done
+	}
+
+	// 后端达到最大连接数后排队，队列已满时返回503和Retry-After
+	first := serve("/api/v1/backend-queue/items")
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&inflight) == 1 }, time.Second, time.Millisecond)
+	queued := serve("/api/v1/backend-queue/items")
+	require.Eventually(t, func() bool {
+		return table.backendQueues["/api/v1/backend-queue"].Len() == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.QueueDepth.WithLabelValues("/api/v1/backend-queue", "backend")))
+
+	w := <-serve("/api/v1/backend-queue/items")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "queue_full", response["code"])
+
+	// 连接释放后排队的请求被转发
+	release <- struct{}{}
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&inflight) == 1 }, time.Second, time.Millisecond)
+	release <- struct{}{}
+	assert.Equal(t, http.StatusOK, (<-queued).Code)
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.QueueDepth.WithLabelValues("/api/v1/backend-queue", "backend")))
+	assert.Equal(t, full+1, rejected("/api/v1/backend-queue", "backend", "full"))
+
+	// 超过路由并发数的请求排队超时
+	first = serve("/api/v1/route-queue/items")
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&inflight) == 1 }, time.Second, time.Millisecond)
+	w = <-serve("/api/v1/route-queue/items")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "queue_timeout", response["code"])
+	assert.Equal(t, timeout+1, rejected("/api/v1/route-queue", "route", "timeout"))
+
+	// 客户端断开时离开队列，不写入响应
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan *proxyRecorder, 1)
+	go func() {
+		w := newProxyRecorder()
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/api/v1/route-queue/items", nil)
+		gateway.ServeHTTP(w, req)
+		canceled <- w
+	}()
+	limiter := table.concurrency["/api/v1/route-queue"]
+	require.Eventually(t, func() bool { return limiter.Queue().Len() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	w = <-canceled
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, 0, limiter.Queue().Len())
+	assert.Equal(t, canceledBefore+1, rejected("/api/v1/route-queue", "route", "canceled"))
+
+	release <- struct{}{}
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.Equal(t, int64(0), limiter.Active())
+}
+
+func TestRequestPriority(t *testing.T) {
+	cfg := config.ConcurrencyConfig{Order: "priority", Priorities: map[string]int{"admin": 10, "user": 1, "pro": 5}}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	// 角色切片有剩余容量时也不能被修改
+	roles := make([]string, 1, 2)
+	roles[0] = "user"
+	c.Set("user_roles", roles)
+	c.Set("rate_limit_tier", "pro")
+	assert.Equal(t, 5, requestPriority(c, cfg))
+	assert.Equal(t, "", roles[:2][1])
+
+	c.Set("user_roles", []string{"admin", "user"})
+	assert.Equal(t, 10, requestPriority(c, cfg))
+
+	cfg.Order = "fifo"
+	assert.Equal(t, 0, requestPriority(c, cfg))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+	writes := 0
+	writeCert := func(name string, template *x509.Certificate) (string, string) {
+		certPEM, keyPEM := issueTestCert(t, ca, caKey, template)
+		certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+		require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+		require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+		// 确保重写后的文件修改时间发生变化
+		writes++
+		modTime := time.Now().Add(time.Duration(writes) * time.Second)
+		require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		return certFile, keyFile
+	}
+	serverCert := func(cn string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	}
+	clientCert := func(cn string, ou ...string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn, OrganizationalUnit: ou},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	}
+
+	// 要求网关提供客户端证书的后端
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	backendCert, backendKey := writeCert("backend", serverCert("backend"))
+	backendPair, err := tls.LoadX509KeyPair(backendCert, backendKey)
+	require.NoError(t, err)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = &tls.Config{Certificates: []tls.Certificate{backendPair}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	backend.StartTLS()
+	defer backend.Close()
+
+	upstreamCert, upstreamKey := writeCert("upstream", clientCert("gateway"))
+	listenerCert, listenerKey := writeCert("listener", serverCert("gateway-listener"))
+
+	cfg := createTestConfig()
+	cfg.Server.TLS = config.TLSConfig{Enabled: true, CertFile: listenerCert, KeyFile: listenerKey,
+		ClientCAFile: caFile, ClientAuth: "verify_if_given"}
+	cfg.Auth.ClientCert = config.ClientCertAuthConfig{Enabled: true, UserIDFrom: "common_name", RolesFromOU: true}
+	route := createRetryTestRoute(backend.URL)
+	route.RequiredRoles = []string{"service"}
+	route.Backends[0].TLS = config.UpstreamTLS{CAFile: caFile, CertFile: upstreamCert, KeyFile: upstreamKey, MinVersion: "1.3"}
+	cfg.Routes = []config.RouteConfig{route}
+	gateway, err := NewGateway(cfg)
+	require.NoError(t, err)
+
+	serverTLS, err := newServerTLSConfig(cfg.Server.TLS)
+	require.NoError(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	require.NoError(t, err)
+	server := &http.Server{Handler: gateway}
+	go server.Serve(listener)
+	defer server.Close()
+
+	request := func(certs ...tls.Certificate) (*http.Response, string) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/api/v1/retry/whoami")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// 客户端证书映射为用户身份，组织单位作为角色
+	callerCert, callerKey := writeCert("caller", clientCert("svc-orders", "service"))
+	caller, err := tls.LoadX509KeyPair(callerCert, callerKey)
+	require.NoError(t, err)
+	resp, body := request(caller)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gateway", body)
+	assert.Equal(t, "gateway-listener", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	otherCert, otherKey := writeCert("other", clientCert("svc-reports", "reporting"))
+	other, err := tls.LoadX509KeyPair(otherCert, otherKey)
+	require.NoError(t, err)
+	resp, _ = request(other)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = request()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 证书文件更新后，新连接使用新的证书
+	writeCert("upstream", clientCert("gateway-rotated"))
+	writeCert("listener", serverCert("gateway-listener-rotated"))
+	for transport := range gateway.currentTable().transports() {
+		transport.CloseIdleConnections()
+	}
+	resp, body = request(caller)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gateway-rotated", body)
+	assert.Equal(t, "gateway-listener-rotated", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// 后端证书不受信任时拒绝连接
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+	route.Backends = []config.BackendConfig{{URL: untrusted.URL, Weight: 1, TLS: config.UpstreamTLS{CAFile: caFile}}}
+	route.Retries = 0
+	reloaded := *cfg
+	reloaded.Routes = []config.RouteConfig{route}
+	require.NoError(t, gateway.Reload(&reloaded))
+	resp, _ = request(caller)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+// newTestCA 创建测试用的CA证书
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return ca, key
+}
+
+// issueTestCert 使用测试CA签发证书，返回PEM格式的证书和私钥
+func issueTestCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, template *x509.Certificate) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func BenchmarkProxyConnectionReuse(b *testing.B) {
+	var newConns int64
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&newConns, 1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	cfg := createTestConfig()
+	cfg.Routes = []config.RouteConfig{createRetryTestRoute(backend.URL)}
+	gateway, err := NewGateway(cfg)
+	require.NoError(b, err)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w := newProxyRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/retry/items", nil)
+			gateway.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				b.Errorf("unexpected status: %d", w.Code)
+			}
+		}
+	})
+	b.StopTimer()
+
+	// 连接被复用时，新建连接数远小于请求数
+	b.ReportMetric(float64(atomic.LoadInt64(&newConns)), "conns")
+	b.ReportMetric(float64(atomic.LoadInt64(&newConns))/float64(b.N), "conns/op")
+}
+
+// proxyRecorder 为httputil.ReverseProxy提供CloseNotifier支持
+type proxyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func newProxyRecorder() *proxyRecorder {
+	return &proxyRecorder{httptest.NewRecorder()}
+}
+
+func (r *proxyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func createRetryTestRoute(backendURLs ...string) config.RouteConfig {
+	route := config.RouteConfig{
+		Path:         "/api/v1/retry",
+		Method:       "GET",
+		Timeout:      30 * time.Second,
+		Retries:      2,
+		LoadBalancer: config.RoundRobin,
+		RetryPolicy: config.RetryPolicy{
+			RetryOn:       []int{502, 503, 504},
+			BaseBackoff:   time.Millisecond,
+			MaxBackoff:    5 * time.Millisecond,
+			Budget:        time.Second,
+			MaxBufferSize: 1 << 20,
+		},
+	}
+	for _, u := range backendURLs {
+		route.Backends = append(route.Backends, config.BackendConfig{URL: u, Weight: 1})
+	}
+	return route
 }
 
 func createTestConfig() *config.Config {
@@ -169,6 +1989,7 @@ func createTestConfig() *config.Config {
 			TokenExpiry:   24 * time.Hour,
 			RefreshExpiry: 7 * 24 * time.Hour,
 			Issuer:        "test-gateway",
+			Users:         config.UserStoreConfig{Store: "mock", AllowMock: true},
 		},
 		Logging: config.LoggingConfig{
 			Level:  "info",
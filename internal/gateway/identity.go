@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/config"
+)
+
+// identityContextKey 请求上下文中保存调用方身份的键
+type identityContextKey struct{}
+
+// withIdentity 将认证中间件识别出的调用方身份保存到上下文
+func withIdentity(ctx context.Context, c *gin.Context) context.Context {
+	if _, exists := c.Get("user_id"); !exists {
+		return ctx
+	}
+
+	identity := map[string]string{
+		"user_id":    c.GetString("user_id"),
+		"username":   c.GetString("username"),
+		"roles":      strings.Join(c.GetStringSlice("user_roles"), ","),
+		"scopes":     strings.Join(c.GetStringSlice("user_scopes"), ","),
+		"api_key_id": c.GetString("api_key_id"),
+	}
+	if claims, ok := c.Get("claims"); ok {
+		if claims, ok := claims.(*auth.Claims); ok {
+			identity["email"] = claims.Email
+		}
+	}
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// identityFromContext 从上下文获取调用方身份，未认证时返回nil
+func identityFromContext(ctx context.Context) map[string]string {
+	identity, _ := ctx.Value(identityContextKey{}).(map[string]string)
+	return identity
+}
+
+// forwardIdentity 移除客户端伪造的身份头，按路由配置写入调用方身份并签名
+func (g *Gateway) forwardIdentity(req *http.Request, route config.RouteConfig) {
+	signer := g.identitySigner.Load()
+	if signer != nil {
+		req.Header.Del(signer.Header())
+	}
+	if len(route.IdentityHeaders) == 0 {
+		return
+	}
+	for _, header := range route.IdentityHeaders {
+		req.Header.Del(header)
+	}
+
+	identity := identityFromContext(req.Context())
+	if identity == nil {
+		return
+	}
+
+	var headers []string
+	for field, header := range route.IdentityHeaders {
+		if value := identity[field]; value != "" {
+			req.Header.Set(header, value)
+			headers = append(headers, header)
+		}
+	}
+	if signer == nil || len(headers) == 0 {
+		return
+	}
+	sort.Strings(headers)
+	signer.Sign(req, headers, time.Now())
+}
+
+// newIdentitySigner 根据配置创建身份头签名器，未配置密钥时返回nil
+func newIdentitySigner(cfg config.IdentitySigningConfig) *auth.IdentitySigner {
+	if cfg.Secret == "" {
+		return nil
+	}
+	return auth.NewIdentitySigner(cfg.Secret, cfg.Header)
+}
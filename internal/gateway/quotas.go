@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/logger"
+	"api-gateway/internal/quota"
+)
+
+// quotaView 管理接口返回的用量
+type quotaView struct {
+	quota.Usage
+	Remaining int64 `json:"remaining"`
+}
+
+// quotaMiddleware 配额中间件，按用户标识（API密钥为其所有者）计数，未认证的请求不计入配额。
+// 配额用尽时返回429和错误码quota_exceeded，与速率限制的拒绝区分
+func (g *Gateway) quotaMiddleware(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		consumer := c.GetString("user_id")
+		if consumer == "" {
+			c.Next()
+			return
+		}
+
+		usages, allowed, err := g.quotas.Consume(c.Request.Context(), consumer, group)
+		if err != nil {
+			logger.Errorf("配额检查失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+			c.Abort()
+			return
+		}
+
+		quota.SetHeaders(c.Writer.Header(), usages, allowed, time.Now())
+		if !allowed {
+			writeProxyError(c.Writer, http.StatusTooManyRequests, "quota_exceeded", "配额已用尽")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// getQuotaHandler 查询调用方在所有配额组当前窗口内的用量
+func (g *Gateway) getQuotaHandler(c *gin.Context) {
+	consumer := c.Param("consumer")
+	usages, err := g.quotas.Get(c.Request.Context(), consumer)
+	if err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	quotas := make([]quotaView, 0, len(usages))
+	for _, usage := range usages {
+		quotas = append(quotas, quotaView{Usage: usage, Remaining: usage.Remaining()})
+	}
+	c.JSON(http.StatusOK, gin.H{"consumer": consumer, "quotas": quotas})
+}
+
+// resetQuotaHandler 重置调用方在当前窗口内的用量，未指定窗口时重置配额组的所有窗口
+func (g *Gateway) resetQuotaHandler(c *gin.Context) {
+	var req struct {
+		Group  string       `json:"group" binding:"required"`
+		Period quota.Period `json:"period"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	consumer := c.Param("consumer")
+	if err := g.quotas.Reset(c.Request.Context(), consumer, req.Group, req.Period); err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	logger.Infof("已重置 %s 在配额组 %s 的用量", consumer, req.Group)
+	c.JSON(http.StatusOK, gin.H{"message": "配额已重置"})
+}
+
+// grantQuotaHandler 为调用方在当前窗口内授予额外的配额，amount为负数时收回
+func (g *Gateway) grantQuotaHandler(c *gin.Context) {
+	var req struct {
+		Group  string       `json:"group" binding:"required"`
+		Period quota.Period `json:"period" binding:"required"`
+		Amount int64        `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	consumer := c.Param("consumer")
+	usage, err := g.quotas.Grant(c.Request.Context(), consumer, req.Group, req.Period, req.Amount)
+	if err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	logger.Infof("已为 %s 在配额组 %s 的%s窗口授予 %d 次额外配额", consumer, req.Group, req.Period, req.Amount)
+	c.JSON(http.StatusOK, gin.H{"quota": quotaView{Usage: usage, Remaining: usage.Remaining()}})
+}
+
+// writeQuotaError 将配额操作的错误转换为响应
+func writeQuotaError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, quota.ErrUnknownGroup):
+		c.JSON(http.StatusNotFound, gin.H{"error": "配额组不存在"})
+	case errors.Is(err, quota.ErrUnknownPeriod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配额组没有配置该窗口"})
+	default:
+		logger.Errorf("配额操作失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+	}
+}
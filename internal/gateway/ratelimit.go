@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/ratelimit"
+)
+
+// routeLimit 路由的一条速率限制
+type routeLimit struct {
+	name    string
+	limit   int
+	key     ratelimit.KeyTemplate
+	limiter ratelimit.RateLimiter
+	plans   []limitPlan // 可选的计划，为空时使用limit和limiter
+}
+
+// limitPlan 速率限制可选的一个计划
+type limitPlan struct {
+	name    string
+	limit   int
+	roles   []string
+	limiter ratelimit.RateLimiter
+}
+
+// routeLimits 创建路由的速率限制，窗口和突发容量未配置时使用全局配置。
+// 算法、窗口和突发容量相同的限制共用一个限制器
+func (g *Gateway) routeLimits(global config.RateLimitConfig, route config.RouteConfig) []routeLimit {
+	rules := route.RateLimitRules()
+	limits := make([]routeLimit, 0, len(rules))
+	for _, rule := range rules {
+		key, err := ratelimit.NewKeyTemplate(rule.Key)
+		if err != nil {
+			// 配置验证时已经检查过限流键
+			logger.Errorf("路由 %s 的速率限制 %s 无效: %v", route.Path, rule.Name, err)
+			continue
+		}
+
+		limit := routeLimit{name: rule.Name, key: key}
+		if len(rule.Plans) == 0 {
+			limit.limit = rule.Limit
+			limit.limiter = g.limiter(global, "", rule.Window, rule.Burst)
+		}
+		for _, name := range rule.Plans {
+			plan := global.Plans[name]
+			limit.plans = append(limit.plans, limitPlan{
+				name:    name,
+				limit:   plan.Rate,
+				roles:   plan.Roles,
+				limiter: g.limiter(global, plan.Algorithm, plan.Window, plan.Burst),
+			})
+		}
+		limits = append(limits, limit)
+	}
+	return limits
+}
+
+// limiter 返回指定算法、窗口和突发容量的限制器，未配置的部分使用全局配置
+func (g *Gateway) limiter(global config.RateLimitConfig, algorithm string, window time.Duration, burst int) ratelimit.RateLimiter {
+	limiterConfig := ratelimit.LimiterConfig{
+		Type:      algorithm,
+		Window:    window,
+		BurstSize: burst,
+	}
+	if limiterConfig.Type == "" {
+		limiterConfig.Type = global.Algorithm
+	}
+	if limiterConfig.Window == 0 {
+		limiterConfig.Window = global.Window
+	}
+	if limiterConfig.BurstSize == 0 {
+		limiterConfig.BurstSize = global.Burst
+	}
+	name := fmt.Sprintf("%s:%s:%d", limiterConfig.Type, limiterConfig.Window, limiterConfig.BurstSize)
+	return g.limiters.GetLimiter(name, limiterConfig)
+}
+
+// selectPlan 选择请求适用的计划：先按API密钥的等级或令牌的等级声明，再按角色匹配限额最高的计划，
+// 都没有匹配时未认证的请求使用anonymous_plan，已认证的请求使用default_plan。
+// 选中的计划不在限制的计划列表中时返回false
+func (l routeLimit) selectPlan(global config.RateLimitConfig, req ratelimit.KeyRequest) (limitPlan, bool) {
+	tier := req.Tier
+	if tier == "" && req.Claim != nil {
+		tier = req.Claim(global.TierClaim)
+	}
+	if plan, ok := l.plan(tier); ok {
+		return plan, true
+	}
+
+	if req.UserID == "" {
+		return l.plan(global.AnonymousPlan)
+	}
+
+	var selected limitPlan
+	for _, plan := range l.plans {
+		if plan.limit > selected.limit && hasAnyRole(req.Roles, plan.roles) {
+			selected = plan
+		}
+	}
+	if selected.limiter != nil {
+		return selected, true
+	}
+	return l.plan(global.DefaultPlan)
+}
+
+// plan 按名称查找限制的计划
+func (l routeLimit) plan(name string) (limitPlan, bool) {
+	if name == "" {
+		return limitPlan{}, false
+	}
+	for _, plan := range l.plans {
+		if plan.name == name {
+			return plan, true
+		}
+	}
+	return limitPlan{}, false
+}
+
+// hasAnyRole 检查是否具有任一角色
+func hasAnyRole(roles, required []string) bool {
+	for _, role := range required {
+		for _, r := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeRateLimitMiddleware 路由级别的速率限制中间件，依次检查每条限制，任一限制拒绝时返回429，
+// 之后的限制不再计数。计数键按路由模式而不是请求路径生成。
+// 使用计划的限制以计划名作为响应头中的策略名，不同计划分别计数
+func (g *Gateway) routeRateLimitMiddleware(global config.RateLimitConfig, route config.RouteConfig, limits []routeLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := middleware.RateLimitKeyRequest(c, route.Path)
+
+		results := make([]ratelimit.Result, 0, len(limits))
+		for _, limit := range limits {
+			key, ok := limit.key.Build(req)
+			if !ok {
+				continue
+			}
+
+			plan := limitPlan{name: limit.name, limit: limit.limit, limiter: limit.limiter}
+			if len(limit.plans) > 0 {
+				if plan, ok = limit.selectPlan(global, req); !ok {
+					continue
+				}
+				key = plan.name + "|" + key
+			}
+
+			result, err := plan.limiter.Allow(c.Request.Context(), limit.name+"|"+key, plan.limit)
+			if err != nil {
+				logger.Errorf("速率限制检查失败: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+				c.Abort()
+				return
+			}
+			result.Policy = plan.name
+			results = append(results, result)
+
+			g.metricsCollector.GetMetrics().RecordRateLimit(plan.name, result.Allowed)
+			if !result.Allowed {
+				break
+			}
+		}
+
+		// 成功和被拒绝的响应都带有限流响应头
+		ratelimit.SetHeaders(c.Writer.Header(), results...)
+		if len(results) > 0 && !results[len(results)-1].Allowed {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":   "请求过于频繁",
+				"message": "请稍后再试",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/loadbalancer"
+)
+
+// maxCapturedResponseSize 被拦截的可重试响应体最大缓存大小
+const maxCapturedResponseSize = 64 << 10
+
+// errRetryableStatus 上游返回了可重试的状态码
+var errRetryableStatus = errors.New("上游返回可重试状态码")
+
+// proxyAttempt 单次代理尝试的状态
+type proxyAttempt struct {
+	final    bool              // 是否为最后一次尝试，最后一次尝试的响应直接返回给客户端
+	retryOn  []int             // 触发重试的状态码
+	served   bool              // 响应是否已写回客户端
+	status   int               // 写回客户端的状态码
+	err      error             // 连接错误
+	response *capturedResponse // 被拦截的可重试响应
+}
+
+// retryable 判断本次尝试是否需要重试
+func (a *proxyAttempt) retryable() bool {
+	if a.response != nil {
+		return true
+	}
+	return a.err != nil && !errors.Is(a.err, context.Canceled) && !errors.Is(a.err, errBodyTooLarge)
+}
+
+// timedOut 判断本次尝试是否因超时失败
+func (a *proxyAttempt) timedOut() bool {
+	return a.err != nil && isTimeoutError(a.err)
+}
+
+// reason 返回重试原因，用于指标标签
+func (a *proxyAttempt) reason() string {
+	if a.response != nil {
+		return "status_" + strconv.Itoa(a.response.statusCode)
+	}
+	if a.timedOut() {
+		return "timeout"
+	}
+	return "connection_error"
+}
+
+// statusCode 返回本次尝试对应的状态码，用于指标记录
+func (a *proxyAttempt) statusCode() int {
+	switch {
+	case a.status != 0:
+		return a.status
+	case a.response != nil:
+		return a.response.statusCode
+	case a.timedOut():
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// isTimeoutError 判断错误是否为超时错误
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// shouldRetryStatus 判断状态码是否需要重试
+func (a *proxyAttempt) shouldRetryStatus(statusCode int) bool {
+	if a.final {
+		return false
+	}
+	for _, code := range a.retryOn {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// capture 拦截可重试的上游响应，以便在不再重试时原样返回给客户端
+func (a *proxyAttempt) capture(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCapturedResponseSize))
+	if err != nil {
+		return err
+	}
+
+	a.response = &capturedResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header.Clone(),
+		body:       body,
+	}
+	return nil
+}
+
+// capturedResponse 被拦截的上游响应
+type capturedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+// writeTo 将拦截的响应写回客户端
+func (r *capturedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range r.header {
+		// 响应体可能被截断，长度由下游重新计算
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(r.statusCode)
+	w.Write(r.body)
+}
+
+// isIdempotentMethod 判断HTTP方法是否幂等
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// maxAttempts 计算请求允许的最大尝试次数
+func maxAttempts(route config.RouteConfig, method string) int {
+	if route.Retries <= 0 {
+		return 1
+	}
+	if !isIdempotentMethod(method) && !route.RetryPolicy.RetryNonIdempotent {
+		return 1
+	}
+	return route.Retries + 1
+}
+
+// retryBackoff 计算第retry次重试前的等待时间（带抖动的指数退避）
+func retryBackoff(policy config.RetryPolicy, retry int) time.Duration {
+	backoff := policy.MaxBackoff
+	if retry-1 < 32 {
+		if d := policy.BaseBackoff << uint(retry-1); d > 0 && d < policy.MaxBackoff {
+			backoff = d
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// nextUntriedBackend 从负载均衡器中选择一个尚未尝试过且熔断器放行的后端服务
+func nextUntriedBackend(lb loadbalancer.LoadBalancer, clientIP string, tried map[string]bool) (*loadbalancer.Backend, error) {
+	backends := lb.GetBackends()
+	rejected := make(map[string]bool)
+	for i := 0; i < len(backends); i++ {
+		backend, err := lb.NextBackend(clientIP)
+		if err != nil {
+			return nil, err
+		}
+		key := backend.URL.String()
+		if tried[key] || rejected[key] {
+			continue
+		}
+		if backend.AllowRequest() {
+			return backend, nil
+		}
+		rejected[key] = true
+	}
+
+	// IP哈希等策略可能总是返回同一个后端，回退到遍历可用后端
+	for _, backend := range backends {
+		key := backend.URL.String()
+		if tried[key] || rejected[key] || !backend.CanAcceptConnection() {
+			continue
+		}
+		if backend.AllowRequest() {
+			return backend, nil
+		}
+	}
+
+	return nil, loadbalancer.ErrNoBackendsAvailable
+}
@@ -0,0 +1,474 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/config"
+	"api-gateway/internal/loadbalancer"
+	"api-gateway/internal/logger"
+	"api-gateway/internal/middleware"
+)
+
+// routeTable 可原子替换的路由表，包含某一版本配置对应的路由、负载均衡器和上游代理。
+// 配置重载时构建新的路由表并整体替换，正在处理的请求继续使用旧路由表。
+// 仅修改后端服务时在原路由表上更新配置和版本。
+type routeTable struct {
+	version       int64
+	loadedAt      time.Time
+	config        *config.Config
+	router        *gin.Engine
+	loadBalancers map[string]loadbalancer.LoadBalancer
+	upstreams     map[string]*upstream
+	// concurrency、backendQueues 按路由路径索引的并发限制器和等待后端的队列，未配置时不存在
+	concurrency   map[string]*loadbalancer.ConcurrencyLimiter
+	backendQueues map[string]*loadbalancer.Queue
+	mutex         sync.RWMutex
+}
+
+// snapshot 获取路由表当前的配置、版本和加载时间
+func (t *routeTable) snapshot() (*config.Config, int64, time.Time) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.config, t.version, t.loadedAt
+}
+
+// setConfig 更新路由表的配置并递增版本
+func (t *routeTable) setConfig(cfg *config.Config) int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.config = cfg
+	t.version++
+	t.loadedAt = time.Now()
+	return t.version
+}
+
+// upstream 获取后端服务的反向代理
+func (t *routeTable) upstream(routePath, backendURL string) (*upstream, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	up, exists := t.upstreams[upstreamKey(routePath, backendURL)]
+	return up, exists
+}
+
+// setUpstream 注册后端服务的反向代理，替换已有的代理
+func (t *routeTable) setUpstream(routePath, backendURL string, up *upstream) {
+	t.mutex.Lock()
+	old := t.upstreams[upstreamKey(routePath, backendURL)]
+	t.upstreams[upstreamKey(routePath, backendURL)] = up
+	t.mutex.Unlock()
+
+	if old != nil && old.transport != up.transport {
+		old.close()
+	}
+}
+
+// removeUpstream 移除后端服务的反向代理并关闭空闲连接
+func (t *routeTable) removeUpstream(routePath, backendURL string) {
+	t.mutex.Lock()
+	old := t.upstreams[upstreamKey(routePath, backendURL)]
+	delete(t.upstreams, upstreamKey(routePath, backendURL))
+	t.mutex.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+// transports 返回路由表使用的所有传输层
+func (t *routeTable) transports() map[*http.Transport]bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	result := make(map[*http.Transport]bool, len(t.upstreams))
+	for _, up := range t.upstreams {
+		result[up.transport] = true
+	}
+	return result
+}
+
+// backendConfigs 按路由和后端URL索引路由表中的后端配置
+func (t *routeTable) backendConfigs() map[string]config.BackendConfig {
+	cfg, _, _ := t.snapshot()
+	result := make(map[string]config.BackendConfig)
+	for _, route := range cfg.Routes {
+		for _, backendCfg := range route.Backends {
+			result[upstreamKey(route.Path, backendCfg.URL)] = backendCfg
+		}
+	}
+	return result
+}
+
+// routeConfig 按路径查找路由配置
+func (t *routeTable) routeConfig(path string) (config.RouteConfig, bool) {
+	cfg, _, _ := t.snapshot()
+	for _, route := range cfg.Routes {
+		if route.Path == path {
+			return route, true
+		}
+	}
+	return config.RouteConfig{}, false
+}
+
+// currentTable 获取当前生效的路由表
+func (g *Gateway) currentTable() *routeTable {
+	return g.table.Load()
+}
+
+// configVersion 获取当前生效的配置版本
+func (g *Gateway) configVersion() int64 {
+	_, version, _ := g.currentTable().snapshot()
+	return version
+}
+
+// ServeHTTP 使用当前路由表处理请求
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.currentTable().router.ServeHTTP(w, r)
+}
+
+// buildRouteTable 根据配置构建路由表，未变化的后端服务和连接池从previous中复用
+func (g *Gateway) buildRouteTable(cfg *config.Config, previous *routeTable) (*routeTable, error) {
+	table := &routeTable{
+		loadedAt:      time.Now(),
+		config:        cfg,
+		loadBalancers: make(map[string]loadbalancer.LoadBalancer),
+		upstreams:     make(map[string]*upstream),
+		concurrency:   make(map[string]*loadbalancer.ConcurrencyLimiter),
+		backendQueues: make(map[string]*loadbalancer.Queue),
+	}
+
+	var previousBackends map[string]config.BackendConfig
+	if previous != nil {
+		previousBackends = previous.backendConfigs()
+	}
+
+	for _, route := range cfg.Routes {
+		lb := loadbalancer.CreateLoadBalancer(route.LoadBalancer)
+
+		var previousRoute config.RouteConfig
+		var previousLB loadbalancer.LoadBalancer
+		if previous != nil {
+			previousRoute, _ = previous.routeConfig(route.Path)
+			previousLB = previous.loadBalancers[route.Path]
+		}
+
+		for _, backendCfg := range route.Backends {
+			key := upstreamKey(route.Path, backendCfg.URL)
+
+			// 配置未变化的后端服务沿用原实例，保留连接计数、健康和熔断状态
+			var backend *loadbalancer.Backend
+			if previousLB != nil && reflect.DeepEqual(previousBackends[key], backendCfg) &&
+				reflect.DeepEqual(previousRoute.CircuitBreaker, route.CircuitBreaker) {
+				backend = findBackend(previousLB, backendCfg.URL)
+			}
+
+			if backend == nil {
+				var err error
+				backend, err = g.newBackend(route, backendCfg)
+				if err != nil {
+					return nil, fmt.Errorf("创建后端服务失败 %s: %w", backendCfg.URL, err)
+				}
+			}
+
+			var previousUpstream *upstream
+			if previous != nil {
+				previousUpstream, _ = previous.upstream(route.Path, backendCfg.URL)
+			}
+
+			up, err := g.newUpstream(route, backendCfg, backend, previousUpstream)
+			if err != nil {
+				return nil, fmt.Errorf("创建后端服务失败 %s: %w", backendCfg.URL, err)
+			}
+			lb.AddBackend(backend)
+			table.upstreams[key] = up
+		}
+
+		table.loadBalancers[route.Path] = lb
+
+		// 并发限制配置未变化时沿用原实例，保留正在处理和排队的请求
+		if previous != nil && reflect.DeepEqual(previousRoute.Concurrency, route.Concurrency) {
+			if limiter := previous.concurrency[route.Path]; limiter != nil {
+				table.concurrency[route.Path] = limiter
+			}
+			if queue := previous.backendQueues[route.Path]; queue != nil {
+				table.backendQueues[route.Path] = queue
+			}
+		} else {
+			if route.Concurrency.MaxConcurrent > 0 {
+				table.concurrency[route.Path] = loadbalancer.NewConcurrencyLimiter(
+					route.Concurrency.MaxConcurrent, g.newQueue(route, "route"))
+			}
+			if route.Concurrency.QueueSize > 0 {
+				table.backendQueues[route.Path] = g.newQueue(route, "backend")
+			}
+		}
+	}
+
+	table.router = g.buildRouter(table)
+	return table, nil
+}
+
+// newBackend 创建后端服务实例
+func (g *Gateway) newBackend(route config.RouteConfig, backendCfg config.BackendConfig) (*loadbalancer.Backend, error) {
+	backend, err := loadbalancer.NewBackend(backendCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// 启用熔断器
+	if route.CircuitBreaker.Enabled {
+		backend.SetCircuitBreaker(g.newCircuitBreaker(route, backendCfg.URL))
+	}
+
+	return backend, nil
+}
+
+// findBackend 在负载均衡器中查找后端服务
+func findBackend(lb loadbalancer.LoadBalancer, backendURL string) *loadbalancer.Backend {
+	for _, backend := range lb.GetBackends() {
+		if backend.URL.String() == backendURL {
+			return backend
+		}
+	}
+	return nil
+}
+
+// buildRouter 为路由表构建Gin路由
+func (g *Gateway) buildRouter(table *routeTable) *gin.Engine {
+	router := gin.New()
+
+	// 基础中间件
+	router.Use(g.metricsMiddleware())
+	router.Use(g.middlewareHandler("logging"))
+	router.Use(g.middlewareHandler("security"))
+	router.Use(g.middlewareHandler("cors"))
+	router.Use(gin.Recovery())
+
+	// 健康检查端点
+	router.GET("/health", g.healthCheckHandler)
+	router.GET("/health/detailed", g.detailedHealthCheckHandler)
+	router.GET("/.well-known/jwks.json", g.jwksHandler)
+
+	// 认证端点
+	authGroup := router.Group("/auth")
+	{
+		authGroup.POST("/login", g.loginHandler)
+		authGroup.POST("/refresh", g.refreshTokenHandler)
+		authGroup.POST("/logout", g.logoutHandler)
+	}
+
+	// 管理端点
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(g.middlewareHandler("auth"))
+	adminGroup.Use(g.authorizationHandler("/admin", middleware.AuthorizationPolicy{
+		RequiredRoles: []string{"admin"},
+	}))
+	{
+		adminGroup.GET("/status", g.statusHandler)
+		adminGroup.GET("/backends", g.backendsHandler)
+		adminGroup.POST("/backends/health", g.updateBackendHealthHandler)
+		adminGroup.GET("/config", g.configHandler)
+		adminGroup.GET("/routes", g.listRoutesHandler)
+		adminGroup.POST("/routes", g.createRouteHandler)
+		adminGroup.GET("/routes/:id", g.getRouteHandler)
+		adminGroup.PUT("/routes/:id", g.updateRouteHandler)
+		adminGroup.DELETE("/routes/:id", g.deleteRouteHandler)
+		adminGroup.POST("/routes/:id/backends", g.createBackendHandler)
+		adminGroup.PUT("/routes/:id/backends", g.updateBackendHandler)
+		adminGroup.DELETE("/routes/:id/backends", g.deleteBackendHandler)
+		adminGroup.POST("/tokens/revoke", g.revokeTokenHandler)
+		adminGroup.POST("/users", g.createUserHandler)
+		adminGroup.POST("/users/:id/disable", g.setUserActiveHandler(false))
+		adminGroup.POST("/users/:id/enable", g.setUserActiveHandler(true))
+		adminGroup.POST("/users/:id/revoke-tokens", g.revokeUserTokensHandler)
+		adminGroup.GET("/api-keys", g.listAPIKeysHandler)
+		adminGroup.POST("/api-keys", g.createAPIKeyHandler)
+		adminGroup.POST("/api-keys/:id/rotate", g.rotateAPIKeyHandler)
+		adminGroup.DELETE("/api-keys/:id", g.revokeAPIKeyHandler)
+		adminGroup.GET("/quotas/:consumer", g.getQuotaHandler)
+		adminGroup.POST("/quotas/:consumer/reset", g.resetQuotaHandler)
+		adminGroup.POST("/quotas/:consumer/grant", g.grantQuotaHandler)
+	}
+
+	// 代理路由
+	g.setupProxyRoutes(router, table)
+
+	// 前端静态资源 (如果存在 public 目录)
+	if _, err := os.Stat("public"); err == nil {
+		router.Static("/", "public")
+		// SPA fallback: 未匹配到API时返回 index.html
+		router.NoRoute(func(c *gin.Context) {
+			if strings.HasPrefix(c.Request.URL.Path, "/api") || strings.HasPrefix(c.Request.URL.Path, "/auth") || strings.HasPrefix(c.Request.URL.Path, "/admin") || strings.HasPrefix(c.Request.URL.Path, "/metrics") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.File("public/index.html")
+		})
+	}
+
+	return router
+}
+
+// setupProxyRoutes 设置代理路由
+func (g *Gateway) setupProxyRoutes(router *gin.Engine, table *routeTable) {
+	for _, route := range table.config.Routes {
+		routeGroup := router.Group(route.Path)
+
+		// 应用路由特定的中间件，配置了授权规则的路由必须先认证
+		if route.AuthRequired || route.RequiresAuthorization() {
+			routeGroup.Use(g.middlewareHandler("auth"))
+		}
+
+		if route.RequiresAuthorization() {
+			routeGroup.Use(g.authorizationHandler(route.Path, middleware.AuthorizationPolicy{
+				RequiredRoles:  route.RequiredRoles,
+				RequiredScopes: route.RequiredScopes,
+				MethodRules:    route.MethodRules,
+			}))
+		}
+
+		// 外部授权需要在缓存之前执行，避免未经授权读取缓存的响应
+		customMiddleware := make([]string, 0, len(route.Middleware))
+		for _, name := range route.Middleware {
+			if name == "forward_auth" {
+				routeGroup.Use(g.forwardAuthHandler())
+				continue
+			}
+			customMiddleware = append(customMiddleware, name)
+		}
+
+		if limits := g.routeLimits(table.config.RateLimit, route); len(limits) > 0 {
+			routeGroup.Use(g.routeRateLimitMiddleware(table.config.RateLimit, route, limits))
+		}
+
+		if route.QuotaGroup != "" {
+			routeGroup.Use(g.quotaMiddleware(route.QuotaGroup))
+		}
+
+		if route.CacheEnabled {
+			routeGroup.Use(g.middlewareHandler("cache"))
+		}
+
+		// 缓存命中的请求不占用并发名额
+		if limiter := table.concurrency[route.Path]; limiter != nil {
+			routeGroup.Use(g.concurrencyMiddleware(route, limiter))
+		}
+
+		// 应用自定义中间件
+		g.middlewareManager.Apply(routeGroup, customMiddleware)
+
+		// 注册路由处理器
+		routeGroup.Any("/*path", g.proxyHandler(table, route))
+	}
+}
+
+// syncHealthChecks 将健康检查目标与路由表保持一致
+func (g *Gateway) syncHealthChecks(table, previous *routeTable) {
+	cfg, _, _ := table.snapshot()
+	enabled := make(map[string]bool)
+	for _, route := range cfg.Routes {
+		lb := table.loadBalancers[route.Path]
+		for _, backendCfg := range route.Backends {
+			if !backendCfg.HealthCheck.Enabled {
+				continue
+			}
+			if backend := findBackend(lb, backendCfg.URL); backend != nil {
+				g.healthChecker.AddBackend(route.Path, backend, lb)
+				enabled[upstreamKey(route.Path, backendCfg.URL)] = true
+			}
+		}
+	}
+
+	if previous == nil {
+		return
+	}
+	previousCfg, _, _ := previous.snapshot()
+	for _, route := range previousCfg.Routes {
+		for _, backendCfg := range route.Backends {
+			if !enabled[upstreamKey(route.Path, backendCfg.URL)] {
+				g.healthChecker.RemoveBackend(route.Path, backendCfg.URL)
+			}
+		}
+	}
+}
+
+// Reload 使用新配置重建路由表并原子替换，正在处理的请求继续使用旧路由表
+func (g *Gateway) Reload(cfg *config.Config) error {
+	g.reloadMutex.Lock()
+	defer g.reloadMutex.Unlock()
+
+	// 重新读取签名密钥文件，轮换密钥时无需重启
+	keyring, err := auth.NewKeyring(cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("加载签名密钥失败: %w", err)
+	}
+	if err := g.reload(cfg); err != nil {
+		return err
+	}
+	g.tokenService.SetKeyring(keyring)
+	g.identitySigner.Store(newIdentitySigner(cfg.Auth.IdentitySigning))
+	return nil
+}
+
+// reload 重建并替换路由表，调用方需持有reloadMutex
+func (g *Gateway) reload(cfg *config.Config) error {
+	previous := g.currentTable()
+	previousCfg, previousVersion, _ := previous.snapshot()
+	table, err := g.buildRouteTable(cfg, previous)
+	if err != nil {
+		return err
+	}
+	if err := g.quotas.Configure(cfg.Quota); err != nil {
+		return err
+	}
+	table.version = previousVersion + 1
+	g.table.Store(table)
+
+	g.syncHealthChecks(table, previous)
+
+	// 关闭不再使用的传输层的空闲连接
+	current := table.transports()
+	for transport := range previous.transports() {
+		if !current[transport] {
+			transport.CloseIdleConnections()
+		}
+	}
+
+	if !reflect.DeepEqual(previousCfg.Server, cfg.Server) {
+		logger.Warn("服务器配置变更需要重启网关才能生效")
+	}
+	if !reflect.DeepEqual(previousCfg.Auth.OIDC, cfg.Auth.OIDC) {
+		logger.Warn("OIDC配置变更需要重启网关才能生效")
+	}
+	if !reflect.DeepEqual(previousCfg.Auth.ForwardAuth, cfg.Auth.ForwardAuth) {
+		logger.Warn("外部授权配置变更需要重启网关才能生效")
+	}
+	if !reflect.DeepEqual(previousCfg.Auth.ClientCert, cfg.Auth.ClientCert) {
+		logger.Warn("客户端证书认证配置变更需要重启网关才能生效")
+	}
+
+	logger.Infof("配置重载成功，版本: %d，路由数: %d", table.version, len(cfg.Routes))
+	return nil
+}
+
+// ReloadFromFile 从配置文件重新加载配置，加载或校验失败时保留当前配置
+func (g *Gateway) ReloadFromFile(path string) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		logger.Errorf("重载配置失败，继续使用版本 %d 的配置: %v", g.configVersion(), err)
+		return err
+	}
+
+	if err := g.Reload(cfg); err != nil {
+		logger.Errorf("应用新配置失败，继续使用版本 %d 的配置: %v", g.configVersion(), err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+// keyPairLoader 证书和私钥文件，文件修改后在下次握手时重新加载，加载失败时继续使用已加载的证书
+type keyPairLoader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newKeyPairLoader 创建证书加载器并立即加载一次
+func newKeyPairLoader(certFile, keyFile string) (*keyPairLoader, error) {
+	l := &keyPairLoader{certFile: certFile, keyFile: keyFile}
+	if _, err := l.get(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// get 返回当前证书，文件修改时间变化时重新加载
+func (l *keyPairLoader) get() (*tls.Certificate, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	modTime, err := latestModTime(l.certFile, l.keyFile)
+	if err == nil && l.cert != nil && modTime.Equal(l.modTime) {
+		return l.cert, nil
+	}
+
+	cert, loadErr := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		if l.cert != nil {
+			logger.Errorf("重新加载证书 %s 失败，继续使用当前证书: %v", l.certFile, err)
+			return l.cert, nil
+		}
+		return nil, fmt.Errorf("加载证书 %s 失败: %w", l.certFile, err)
+	}
+
+	if l.cert != nil {
+		logger.Infof("证书 %s 已重新加载", l.certFile)
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return l.cert, nil
+}
+
+// certPoolLoader CA证书文件，文件修改后在下次握手时重新加载
+type certPoolLoader struct {
+	file string
+
+	mutex   sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// newCertPoolLoader 创建CA证书加载器并立即加载一次
+func newCertPoolLoader(file string) (*certPoolLoader, error) {
+	l := &certPoolLoader{file: file}
+	if _, err := l.get(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// get 返回当前CA证书池，文件修改时间变化时重新加载
+func (l *certPoolLoader) get() (*x509.CertPool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	modTime, err := latestModTime(l.file)
+	if err == nil && l.pool != nil && modTime.Equal(l.modTime) {
+		return l.pool, nil
+	}
+
+	var pool *x509.CertPool
+	if err == nil {
+		pool, err = readCertPool(l.file)
+	}
+	if err != nil {
+		if l.pool != nil {
+			logger.Errorf("重新加载CA证书 %s 失败，继续使用当前证书: %v", l.file, err)
+			return l.pool, nil
+		}
+		return nil, fmt.Errorf("加载CA证书 %s 失败: %w", l.file, err)
+	}
+
+	if l.pool != nil {
+		logger.Infof("CA证书 %s 已重新加载", l.file)
+	}
+	l.pool = pool
+	l.modTime = modTime
+	return l.pool, nil
+}
+
+// readCertPool 读取PEM格式的CA证书
+func readCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("文件中没有有效的证书")
+	}
+	return pool, nil
+}
+
+// latestModTime 返回多个文件中最近的修改时间
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// newServerTLSConfig 创建监听器的TLS配置，证书和客户端CA证书修改后无需重启即可生效
+func newServerTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	minVersion, err := config.ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := newKeyPairLoader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: minVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.get()
+		},
+	}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	clientCAs, err := newCertPoolLoader(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.RequireAndVerifyClientCert
+	if cfg.ClientAuth == "verify_if_given" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := clientCAs.get()
+		if err != nil {
+			return nil, err
+		}
+		perConn := tlsConfig.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.ClientAuth = clientAuth
+		perConn.ClientCAs = pool
+		return perConn, nil
+	}
+	return tlsConfig, nil
+}
+
+// newUpstreamTLSConfig 创建连接后端的TLS配置，host为后端地址的主机名。
+// 配置了CA证书时由VerifyConnection使用最新加载的CA证书验证后端，以便CA证书轮换后无需重启。
+func newUpstreamTLSConfig(cfg config.UpstreamTLS, host string) (*tls.Config, error) {
+	minVersion, err := config.ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CertFile != "" {
+		certs, err := newKeyPairLoader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certs.get()
+		}
+	}
+
+	if cfg.CAFile != "" && !cfg.InsecureSkipVerify {
+		roots, err := newCertPoolLoader(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		serverName := cfg.ServerName
+		if serverName == "" {
+			serverName = host
+		}
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			pool, err := roots.get()
+			if err != nil {
+				return err
+			}
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("后端未提供证书")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: intermediates,
+				DNSName:       serverName,
+			})
+			return err
+		}
+	}
+	return tlsConfig, nil
+}
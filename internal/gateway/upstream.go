@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"reflect"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/loadbalancer"
+)
+
+// attemptContextKey 请求上下文中保存代理尝试状态的键
+type attemptContextKey struct{}
+
+// withAttempt 将代理尝试状态保存到上下文
+func withAttempt(ctx context.Context, attempt *proxyAttempt) context.Context {
+	return context.WithValue(ctx, attemptContextKey{}, attempt)
+}
+
+// attemptFromContext 从上下文获取代理尝试状态
+func attemptFromContext(ctx context.Context) *proxyAttempt {
+	if attempt, ok := ctx.Value(attemptContextKey{}).(*proxyAttempt); ok {
+		return attempt
+	}
+	// 没有尝试状态时按单次请求处理
+	return &proxyAttempt{final: true}
+}
+
+// upstream 后端服务对应的长连接反向代理
+type upstream struct {
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	pool      config.ConnectionPool
+	tls       config.UpstreamTLS
+}
+
+// close 关闭上游的空闲连接
+func (u *upstream) close() {
+	u.transport.CloseIdleConnections()
+}
+
+// upstreamKey 生成上游代理的索引键
+func upstreamKey(routePath, backendURL string) string {
+	return routePath + "|" + backendURL
+}
+
+// newTransport 根据连接池和TLS配置创建上游传输层
+func newTransport(pool config.ConnectionPool, tlsConfig *tls.Config) *http.Transport {
+	config.SetConnectionPoolDefaults(&pool)
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          pool.MaxIdleConns,
+		MaxIdleConnsPerHost:   pool.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       pool.MaxConnsPerHost,
+		IdleConnTimeout:       pool.IdleConnTimeout,
+		ForceAttemptHTTP2:     pool.EnableHTTP2,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+}
+
+// newUpstream 为后端服务创建长连接反向代理，连接池和TLS配置未变化时复用已有的传输层
+func (g *Gateway) newUpstream(route config.RouteConfig, backendCfg config.BackendConfig, backend *loadbalancer.Backend, previous *upstream) (*upstream, error) {
+	var transport *http.Transport
+	if previous != nil && reflect.DeepEqual(previous.pool, backendCfg.Pool) && reflect.DeepEqual(previous.tls, backendCfg.TLS) {
+		transport = previous.transport
+	} else {
+		tlsConfig, err := newUpstreamTLSConfig(backendCfg.TLS, backend.URL.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		transport = newTransport(backendCfg.Pool, tlsConfig)
+	}
+
+	return &upstream{
+		proxy:     g.createReverseProxy(backend, route, transport),
+		transport: transport,
+		pool:      backendCfg.Pool,
+		tls:       backendCfg.TLS,
+	}, nil
+}
+
+// getUpstream 获取后端服务的反向代理，不存在时使用默认连接池创建
+func (g *Gateway) getUpstream(table *routeTable, route config.RouteConfig, backend *loadbalancer.Backend) *upstream {
+	if up, exists := table.upstream(route.Path, backend.URL.String()); exists {
+		return up
+	}
+
+	// 默认配置不包含证书文件，创建不会失败
+	up, _ := g.newUpstream(route, config.BackendConfig{URL: backend.URL.String()}, backend, nil)
+	table.setUpstream(route.Path, backend.URL.String(), up)
+	return up
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/logger"
+)
+
+// minPasswordLength 管理员创建用户时的最短密码长度
+const minPasswordLength = 8
+
+// createUserHandler 创建用户
+func (g *Gateway) createUserHandler(c *gin.Context) {
+	manager, ok := g.userManager(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ID       string   `json:"id"`
+		Username string   `json:"username" binding:"required"`
+		Password string   `json:"password" binding:"required"`
+		Email    string   `json:"email"`
+		Roles    []string `json:"roles"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码长度至少为8位"})
+		return
+	}
+
+	user, err := manager.CreateUser(auth.User{
+		ID:       req.ID,
+		Username: req.Username,
+		Email:    req.Email,
+		Roles:    req.Roles,
+	}, req.Password)
+	if err != nil {
+		writeUserError(c, err)
+		return
+	}
+
+	logger.Infof("已创建用户 %s (%s)", user.Username, user.ID)
+	c.JSON(http.StatusCreated, user)
+}
+
+// setUserActiveHandler 启用或禁用用户，禁用的用户无法登录，已签发的令牌也随即失效
+func (g *Gateway) setUserActiveHandler(active bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		manager, ok := g.userManager(c)
+		if !ok {
+			return
+		}
+
+		id := c.Param("id")
+		if err := manager.SetUserActive(id, active); err != nil {
+			writeUserError(c, err)
+			return
+		}
+
+		logger.Infof("用户 %s 的启用状态已设置为 %t", id, active)
+		c.JSON(http.StatusOK, gin.H{"id": id, "active": active})
+	}
+}
+
+// userManager 获取支持管理用户的用户服务
+func (g *Gateway) userManager(c *gin.Context) (auth.UserManager, bool) {
+	manager, ok := g.userService.(auth.UserManager)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "当前用户存储不支持管理用户"})
+		return nil, false
+	}
+	return manager, true
+}
+
+// writeUserError 将用户管理操作的错误转换为响应
+func writeUserError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+	case errors.Is(err, auth.ErrUserExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "用户已存在"})
+	default:
+		logger.Errorf("用户管理操作失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
+	}
+}
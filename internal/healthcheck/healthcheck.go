@@ -7,7 +7,6 @@ import (
 	"sync"
 	"time"
 
-	"api-gateway/internal/config"
 	"api-gateway/internal/loadbalancer"
 	"api-gateway/internal/logger"
 )
@@ -0,0 +1,242 @@
+package loadbalancer
+
+import (
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+// circuitWindowBuckets 错误率统计窗口的分桶数量
+const circuitWindowBuckets = 10
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+// String 返回熔断器状态名称
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half_open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// windowBucket 错误率统计分桶
+type windowBucket struct {
+	start    int64
+	requests int
+	failures int
+}
+
+// CircuitBreaker 后端熔断器
+type CircuitBreaker struct {
+	cfg   config.CircuitBreakerConfig
+	state CircuitState
+
+	consecutiveFailures int
+	buckets             [circuitWindowBuckets]windowBucket
+	openedAt            time.Time
+	changedAt           time.Time
+	halfOpenInFlight    int
+	halfOpenSuccesses   int
+
+	onStateChange func(from, to CircuitState)
+	now           func() time.Time
+	mutex         sync.Mutex
+}
+
+// NewCircuitBreaker 创建熔断器
+func NewCircuitBreaker(cfg config.CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		cfg:   cfg,
+		state: CircuitClosed,
+		now:   time.Now,
+	}
+}
+
+// OnStateChange 设置状态变化回调，回调在持有熔断器锁时执行，不能再调用熔断器方法
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to CircuitState)) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.onStateChange = fn
+}
+
+// State 获取当前状态
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+	return cb.state
+}
+
+// StateChangedAt 获取最近一次状态变化的时间
+func (cb *CircuitBreaker) StateChangedAt() time.Time {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+	return cb.changedAt
+}
+
+// Ready 检查熔断器是否可能放行请求（不占用半开探测名额）
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+
+	switch cb.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return cb.halfOpenInFlight < cb.cfg.HalfOpenMaxRequests
+	default:
+		return true
+	}
+}
+
+// Allow 检查是否放行请求，半开状态下会占用一个探测名额
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+
+	switch cb.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if cb.halfOpenInFlight >= cb.cfg.HalfOpenMaxRequests {
+			return false
+		}
+		cb.halfOpenInFlight++
+		return true
+	default:
+		return true
+	}
+}
+
+// Release 释放已放行但未产生结果的请求（例如客户端取消）
+func (cb *CircuitBreaker) Release() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == CircuitHalfOpen && cb.halfOpenInFlight > 0 {
+		cb.halfOpenInFlight--
+	}
+}
+
+// RecordResult 记录请求结果
+func (cb *CircuitBreaker) RecordResult(success bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.refresh()
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		if cb.halfOpenInFlight > 0 {
+			cb.halfOpenInFlight--
+		}
+		if !success {
+			cb.setState(CircuitOpen)
+			return
+		}
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.cfg.HalfOpenMaxRequests {
+			cb.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		cb.record(success)
+		if cb.shouldTrip() {
+			cb.setState(CircuitOpen)
+		}
+	}
+}
+
+// record 记录关闭状态下的请求结果
+func (cb *CircuitBreaker) record(success bool) {
+	if success {
+		cb.consecutiveFailures = 0
+	} else {
+		cb.consecutiveFailures++
+	}
+
+	if cb.cfg.Window <= 0 {
+		return
+	}
+
+	bucketSize := int64(cb.cfg.Window) / circuitWindowBuckets
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	start := cb.now().UnixNano() / bucketSize * bucketSize
+	bucket := &cb.buckets[(start/bucketSize)%circuitWindowBuckets]
+	if bucket.start != start {
+		*bucket = windowBucket{start: start}
+	}
+	bucket.requests++
+	if !success {
+		bucket.failures++
+	}
+}
+
+// shouldTrip 判断是否需要打开熔断器
+func (cb *CircuitBreaker) shouldTrip() bool {
+	if cb.cfg.ConsecutiveFailures > 0 && cb.consecutiveFailures >= cb.cfg.ConsecutiveFailures {
+		return true
+	}
+
+	if cb.cfg.ErrorRateThreshold <= 0 || cb.cfg.Window <= 0 {
+		return false
+	}
+
+	windowStart := cb.now().Add(-cb.cfg.Window).UnixNano()
+	requests, failures := 0, 0
+	for _, bucket := range cb.buckets {
+		if bucket.start > windowStart {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+
+	if requests == 0 || requests < cb.cfg.MinRequests {
+		return false
+	}
+	return float64(failures)/float64(requests) >= cb.cfg.ErrorRateThreshold
+}
+
+// refresh 打开状态超时后进入半开状态
+func (cb *CircuitBreaker) refresh() {
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.cfg.OpenTimeout {
+		cb.setState(CircuitHalfOpen)
+	}
+}
+
+// setState 切换状态并重置统计
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	if cb.state == state {
+		return
+	}
+
+	from := cb.state
+	cb.state = state
+	cb.consecutiveFailures = 0
+	cb.halfOpenInFlight = 0
+	cb.halfOpenSuccesses = 0
+	cb.buckets = [circuitWindowBuckets]windowBucket{}
+	cb.changedAt = cb.now()
+	if state == CircuitOpen {
+		cb.openedAt = cb.changedAt
+	}
+
+	if cb.onStateChange != nil {
+		cb.onStateChange(from, state)
+	}
+}
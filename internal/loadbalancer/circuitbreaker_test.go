@@ -0,0 +1,113 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"api-gateway/internal/config"
+)
+
+func newTestCircuitBreaker(cfg config.CircuitBreakerConfig) (*CircuitBreaker, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	cb := NewCircuitBreaker(cfg)
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	cb, now := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 3,
+		OpenTimeout:         10 * time.Second,
+		HalfOpenMaxRequests: 1,
+	})
+
+	var transitions []CircuitState
+	cb.OnStateChange(func(from, to CircuitState) {
+		transitions = append(transitions, to)
+	})
+
+	cb.RecordResult(false)
+	cb.RecordResult(false)
+	assert.Equal(t, CircuitClosed, cb.State())
+	cb.RecordResult(false)
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.False(t, cb.Ready())
+	assert.False(t, cb.Allow())
+
+	// 超时后进入半开状态，只放行一个探测请求
+	*now = now.Add(10 * time.Second)
+	assert.True(t, cb.Ready())
+	assert.True(t, cb.Allow())
+	assert.False(t, cb.Allow())
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+
+	// 探测失败重新打开
+	cb.RecordResult(false)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// 探测成功关闭
+	*now = now.Add(10 * time.Second)
+	assert.True(t, cb.Allow())
+	cb.RecordResult(true)
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, transitions)
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	cb, now := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		Enabled:            true,
+		ErrorRateThreshold: 0.5,
+		MinRequests:        4,
+		Window:             10 * time.Second,
+		OpenTimeout:        time.Second,
+	})
+
+	// 交替失败不会触发连续失败阈值，错误率达到阈值后熔断
+	cb.RecordResult(true)
+	cb.RecordResult(false)
+	cb.RecordResult(true)
+	assert.Equal(t, CircuitClosed, cb.State())
+	cb.RecordResult(false)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// 窗口外的旧请求不计入错误率
+	cb, now = newTestCircuitBreaker(config.CircuitBreakerConfig{
+		Enabled:            true,
+		ErrorRateThreshold: 0.5,
+		MinRequests:        4,
+		Window:             10 * time.Second,
+	})
+	cb.RecordResult(false)
+	cb.RecordResult(false)
+	*now = now.Add(20 * time.Second)
+	cb.RecordResult(true)
+	cb.RecordResult(true)
+	cb.RecordResult(true)
+	cb.RecordResult(false)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestBackendExcludesOpenCircuit(t *testing.T) {
+	backend, err := NewBackend(config.BackendConfig{URL: "http://localhost:3001", Weight: 1})
+	assert.NoError(t, err)
+
+	cb, _ := newTestCircuitBreaker(config.CircuitBreakerConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+	})
+	backend.SetCircuitBreaker(cb)
+
+	lb := NewRoundRobinBalancer()
+	lb.AddBackend(backend)
+
+	assert.True(t, backend.CanAcceptConnection())
+	backend.RecordResult(false)
+	assert.False(t, backend.CanAcceptConnection())
+
+	_, err = lb.NextBackend("127.0.0.1")
+	assert.ErrorIs(t, err, ErrNoBackendsAvailable)
+}
@@ -22,9 +22,11 @@ type Backend struct {
 	URL            *url.URL
 	Weight         int
 	MaxConnections int
+	Timeout        time.Duration
 	CurrentConns   int64
 	Healthy        bool
 	LastCheck      time.Time
+	breaker        *CircuitBreaker
 	mutex          sync.RWMutex
 }
 
@@ -39,6 +41,7 @@ func NewBackend(cfg config.BackendConfig) (*Backend, error) {
 		URL:            u,
 		Weight:         cfg.Weight,
 		MaxConnections: cfg.MaxConnections,
+		Timeout:        cfg.Timeout,
 		Healthy:        true,
 		LastCheck:      time.Now(),
 	}, nil
@@ -59,10 +62,60 @@ func (b *Backend) SetHealthy(healthy bool) {
 	b.LastCheck = time.Now()
 }
 
+// SetCircuitBreaker 设置后端熔断器
+func (b *Backend) SetCircuitBreaker(cb *CircuitBreaker) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.breaker = cb
+}
+
+// GetCircuitBreaker 获取后端熔断器，未启用时返回nil
+func (b *Backend) GetCircuitBreaker() *CircuitBreaker {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.breaker
+}
+
+// CircuitState 获取熔断器状态
+func (b *Backend) CircuitState() CircuitState {
+	if cb := b.GetCircuitBreaker(); cb != nil {
+		return cb.State()
+	}
+	return CircuitClosed
+}
+
+// AllowRequest 检查熔断器是否放行请求，半开状态下会占用探测名额
+func (b *Backend) AllowRequest() bool {
+	if cb := b.GetCircuitBreaker(); cb != nil {
+		return cb.Allow()
+	}
+	return true
+}
+
+// RecordResult 记录请求结果，用于熔断判断
+func (b *Backend) RecordResult(success bool) {
+	if cb := b.GetCircuitBreaker(); cb != nil {
+		cb.RecordResult(success)
+	}
+}
+
+// ReleaseRequest 释放已放行但未产生结果的请求
+func (b *Backend) ReleaseRequest() {
+	if cb := b.GetCircuitBreaker(); cb != nil {
+		cb.Release()
+	}
+}
+
 // CanAcceptConnection 检查是否可以接受新连接
 func (b *Backend) CanAcceptConnection() bool {
 	currentConns := atomic.LoadInt64(&b.CurrentConns)
-	return b.IsHealthy() && (b.MaxConnections == 0 || currentConns < int64(b.MaxConnections))
+	if !b.IsHealthy() || (b.MaxConnections != 0 && currentConns >= int64(b.MaxConnections)) {
+		return false
+	}
+
+	// 排除熔断打开的后端
+	cb := b.GetCircuitBreaker()
+	return cb == nil || cb.Ready()
 }
 
 // AddConnection 增加连接计数
@@ -70,6 +123,28 @@ func (b *Backend) AddConnection() {
 	atomic.AddInt64(&b.CurrentConns, 1)
 }
 
+// TryAddConnection 在未达到最大连接数时增加连接计数
+func (b *Backend) TryAddConnection() bool {
+	for {
+		currentConns := atomic.LoadInt64(&b.CurrentConns)
+		if b.MaxConnections != 0 && currentConns >= int64(b.MaxConnections) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.CurrentConns, currentConns, currentConns+1) {
+			return true
+		}
+	}
+}
+
+// Saturated 检查后端是否只因达到最大连接数而不能接受新连接
+func (b *Backend) Saturated() bool {
+	if !b.IsHealthy() || b.MaxConnections == 0 || atomic.LoadInt64(&b.CurrentConns) < int64(b.MaxConnections) {
+		return false
+	}
+	cb := b.GetCircuitBreaker()
+	return cb == nil || cb.Ready()
+}
+
 // RemoveConnection 减少连接计数
 func (b *Backend) RemoveConnection() {
 	atomic.AddInt64(&b.CurrentConns, -1)
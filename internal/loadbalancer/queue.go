@@ -0,0 +1,218 @@
+package loadbalancer
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull 等待队列已满
+	ErrQueueFull = errors.New("等待队列已满")
+	// ErrQueueTimeout 排队等待超时
+	ErrQueueTimeout = errors.New("排队等待超时")
+)
+
+// Queue 有界的等待队列。资源释放时调用 Notify，按顺序为队首的等待者获取资源，
+// FIFO模式按到达顺序，优先级模式先按优先级再按到达顺序
+type Queue struct {
+	size     int
+	timeout  time.Duration
+	priority bool
+	waiters  waiterHeap
+	seq      uint64
+	observer func(depth int)
+	mutex    sync.Mutex
+}
+
+// waiter 队列中的一个等待者
+type waiter struct {
+	priority int
+	seq      uint64
+	acquire  func() bool
+	ready    chan struct{}
+	index    int // 在堆中的位置，出队后为-1
+}
+
+// NewQueue 创建等待队列，timeout为0时一直等待到请求取消
+func NewQueue(size int, timeout time.Duration, priority bool) *Queue {
+	return &Queue{size: size, timeout: timeout, priority: priority}
+}
+
+// SetDepthObserver 设置队列长度变化时的回调，回调在持有队列锁时调用
+func (q *Queue) SetDepthObserver(observer func(depth int)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.observer = observer
+}
+
+// Len 返回排队的请求数
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.waiters)
+}
+
+// Wait 通过acquire获取资源，没有其他等待者且获取成功时立即返回，否则排队直到被 Notify 唤醒、
+// 超时或ctx取消。队列已满时返回 ErrQueueFull，返回值包含排队等待的时间
+func (q *Queue) Wait(ctx context.Context, priority int, acquire func() bool) (time.Duration, error) {
+	q.mutex.Lock()
+	if len(q.waiters) == 0 && acquire() {
+		q.mutex.Unlock()
+		return 0, nil
+	}
+	if len(q.waiters) >= q.size {
+		q.mutex.Unlock()
+		return 0, ErrQueueFull
+	}
+
+	if !q.priority {
+		priority = 0
+	}
+	q.seq++
+	w := &waiter{priority: priority, seq: q.seq, acquire: acquire, ready: make(chan struct{})}
+	heap.Push(&q.waiters, w)
+	q.observe()
+	q.mutex.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrQueueTimeout
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// 超时或取消的同时已经获得资源
+	if w.index < 0 {
+		return time.Since(start), nil
+	}
+	heap.Remove(&q.waiters, w.index)
+	q.observe()
+	q.grant()
+	return time.Since(start), err
+}
+
+// Notify 资源释放后唤醒队首的等待者
+func (q *Queue) Notify() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.grant()
+}
+
+// grant 依次为队首的等待者获取资源，直到获取失败或队列为空，调用方需持有mutex
+func (q *Queue) grant() {
+	granted := false
+	for len(q.waiters) > 0 && q.waiters[0].acquire() {
+		w := heap.Pop(&q.waiters).(*waiter)
+		close(w.ready)
+		granted = true
+	}
+	if granted {
+		q.observe()
+	}
+}
+
+// observe 报告队列长度，调用方需持有mutex
+func (q *Queue) observe() {
+	if q.observer != nil {
+		q.observer(len(q.waiters))
+	}
+}
+
+// waiterHeap 按优先级从高到低、到达顺序从早到晚排列的等待者
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
+
+// ConcurrencyLimiter 限制同时处理的请求数，超出时在队列中等待
+type ConcurrencyLimiter struct {
+	max    int64
+	active int64
+	queue  *Queue
+}
+
+// NewConcurrencyLimiter 创建并发限制器
+func NewConcurrencyLimiter(max int, queue *Queue) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{max: int64(max), queue: queue}
+}
+
+// Acquire 获取一个名额，成功后需要调用 Release。返回排队等待的时间
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, priority int) (time.Duration, error) {
+	return l.queue.Wait(ctx, priority, l.tryAcquire)
+}
+
+// tryAcquire 在未达到上限时占用一个名额
+func (l *ConcurrencyLimiter) tryAcquire() bool {
+	for {
+		active := atomic.LoadInt64(&l.active)
+		if active >= l.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.active, active, active+1) {
+			return true
+		}
+	}
+}
+
+// Release 释放名额并唤醒等待者
+func (l *ConcurrencyLimiter) Release() {
+	atomic.AddInt64(&l.active, -1)
+	l.queue.Notify()
+}
+
+// Active 返回正在处理的请求数
+func (l *ConcurrencyLimiter) Active() int64 {
+	return atomic.LoadInt64(&l.active)
+}
+
+// Queue 返回限制器的等待队列
+func (l *ConcurrencyLimiter) Queue() *Queue {
+	return l.queue
+}
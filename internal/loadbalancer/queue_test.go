@@ -0,0 +1,102 @@
+package loadbalancer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitQueued 等待队列中有n个请求
+func waitQueued(t *testing.T, q *Queue, n int) {
+	require.Eventually(t, func() bool { return q.Len() == n }, time.Second, time.Millisecond)
+}
+
+// enqueue 在后台排队，返回按获得名额的顺序记录的结果
+func enqueue(t *testing.T, limiter *ConcurrencyLimiter, priorities []int) []int {
+	var mutex sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i, priority := range priorities {
+		wg.Add(1)
+		go func(i, priority int) {
+			defer wg.Done()
+			_, err := limiter.Acquire(context.Background(), priority)
+			assert.NoError(t, err)
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+		}(i, priority)
+		// 保证到达顺序
+		waitQueued(t, limiter.Queue(), i+1)
+	}
+
+	// 每次释放一个名额，等待被唤醒的请求记录后再释放下一个
+	for i := range priorities {
+		limiter.Release()
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(order) == i+1
+		}, time.Second, time.Millisecond)
+	}
+	wg.Wait()
+	return order
+}
+
+func TestConcurrencyLimiterOrder(t *testing.T) {
+	// FIFO模式忽略优先级
+	limiter := NewConcurrencyLimiter(1, NewQueue(3, time.Second, false))
+	_, err := limiter.Acquire(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, enqueue(t, limiter, []int{0, 5, 1}))
+
+	// 优先级模式先按优先级再按到达顺序
+	limiter = NewConcurrencyLimiter(1, NewQueue(4, time.Second, true))
+	_, err = limiter.Acquire(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 2, 0}, enqueue(t, limiter, []int{0, 5, 1, 5}))
+}
+
+func TestConcurrencyLimiterRejects(t *testing.T) {
+	var depths []int
+	queue := NewQueue(1, 20*time.Millisecond, false)
+	queue.SetDepthObserver(func(depth int) { depths = append(depths, depth) })
+	limiter := NewConcurrencyLimiter(1, queue)
+
+	waited, err := limiter.Acquire(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Zero(t, waited)
+
+	// 排队超时
+	waited, err = limiter.Acquire(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+	assert.GreaterOrEqual(t, waited, 20*time.Millisecond)
+
+	// 队列已满时立即拒绝
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := limiter.Acquire(ctx, 0)
+		done <- err
+	}()
+	waitQueued(t, queue, 1)
+	_, err = limiter.Acquire(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// 客户端断开后离开队列
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, 0, queue.Len())
+	assert.Equal(t, int64(1), limiter.Active())
+	assert.Equal(t, []int{1, 0, 1, 0}, depths)
+
+	// 释放后立即获得名额
+	limiter.Release()
+	_, err = limiter.Acquire(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), limiter.Active())
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -20,9 +21,16 @@ type Metrics struct {
 	BackendRequestsTotal    *prometheus.CounterVec
 	BackendRequestDuration  *prometheus.HistogramVec
 	BackendHealthStatus     *prometheus.GaugeVec
+	BackendRetriesTotal     *prometheus.CounterVec
+	BackendCircuitState     *prometheus.GaugeVec
 	
 	// 速率限制指标
 	RateLimitRequestsTotal *prometheus.CounterVec
+
+	// 排队指标
+	QueueDepth         *prometheus.GaugeVec
+	QueueWaitDuration  *prometheus.HistogramVec
+	QueueRejectedTotal *prometheus.CounterVec
 	
 	// 缓存指标
 	CacheRequestsTotal *prometheus.CounterVec
@@ -34,8 +42,9 @@ type Metrics struct {
 	SystemUptime         prometheus.Gauge
 	
 	// 认证指标
-	AuthRequestsTotal    *prometheus.CounterVec
-	TokenValidationTotal *prometheus.CounterVec
+	AuthRequestsTotal        *prometheus.CounterVec
+	TokenValidationTotal     *prometheus.CounterVec
+	AuthorizationDeniedTotal *prometheus.CounterVec
 }
 
 // NewMetrics 创建指标收集器
@@ -103,13 +112,55 @@ func NewMetrics() *Metrics {
 			[]string{"backend"},
 		),
 		
+		BackendRetriesTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backend_retries_total",
+				Help: "后端服务请求重试总数",
+			},
+			[]string{"route", "backend", "reason"}, // reason: connection_error, status_5xx
+		),
+		
+		BackendCircuitState: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "backend_circuit_state",
+				Help: "后端服务熔断器状态 (0=关闭, 1=半开, 2=打开)",
+			},
+			[]string{"route", "backend"},
+		),
+		
 		// 速率限制指标
 		RateLimitRequestsTotal: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "rate_limit_requests_total",
 				Help: "速率限制请求总数",
 			},
-			[]string{"result"}, // allowed, denied
+			[]string{"result", "plan"}, // allowed, denied
+		),
+
+		// 排队指标
+		QueueDepth: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "request_queue_depth",
+				Help: "等待队列中的请求数",
+			},
+			[]string{"route", "queue"}, // route, backend
+		),
+
+		QueueWaitDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "request_queue_wait_seconds",
+				Help:    "请求在等待队列中的等待时间",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"route", "queue"},
+		),
+
+		QueueRejectedTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "request_queue_rejected_total",
+				Help: "排队失败的请求总数",
+			},
+			[]string{"route", "queue", "reason"}, // full, timeout, canceled
 		),
 		
 		// 缓存指标
@@ -161,6 +212,14 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"result"}, // valid, invalid, expired
 		),
+
+		AuthorizationDeniedTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "authorization_denied_total",
+				Help: "授权拒绝总数",
+			},
+			[]string{"route", "reason"}, // missing_role, missing_scope
+		),
 	}
 }
 
@@ -188,6 +247,12 @@ func (m *Metrics) RecordBackendRequest(backend, method string, statusCode int, d
 	m.BackendRequestDuration.WithLabelValues(backend, method).Observe(duration.Seconds())
 }
 
+// RecordBackendTimeout 记录后端请求超时指标，与其他5xx响应分开统计
+func (m *Metrics) RecordBackendTimeout(backend, method string, duration time.Duration) {
+	m.BackendRequestsTotal.WithLabelValues(backend, method, "timeout").Inc()
+	m.BackendRequestDuration.WithLabelValues(backend, method).Observe(duration.Seconds())
+}
+
 // UpdateBackendHealth 更新后端健康状态
 func (m *Metrics) UpdateBackendHealth(backend string, healthy bool) {
 	var value float64
@@ -197,15 +262,40 @@ func (m *Metrics) UpdateBackendHealth(backend string, healthy bool) {
 	m.BackendHealthStatus.WithLabelValues(backend).Set(value)
 }
 
-// RecordRateLimit 记录速率限制指标
-func (m *Metrics) RecordRateLimit(allowed bool) {
+// RecordBackendRetry 记录后端请求重试指标
+func (m *Metrics) RecordBackendRetry(route, backend, reason string) {
+	m.BackendRetriesTotal.WithLabelValues(route, backend, reason).Inc()
+}
+
+// UpdateBackendCircuitState 更新后端熔断器状态
+func (m *Metrics) UpdateBackendCircuitState(route, backend string, state int) {
+	m.BackendCircuitState.WithLabelValues(route, backend).Set(float64(state))
+}
+
+// RecordRateLimit 记录速率限制指标，plan为应用的计划，未使用计划的限制为限制名称
+func (m *Metrics) RecordRateLimit(plan string, allowed bool) {
 	var result string
 	if allowed {
 		result = "allowed"
 	} else {
 		result = "denied"
 	}
-	m.RateLimitRequestsTotal.WithLabelValues(result).Inc()
+	m.RateLimitRequestsTotal.WithLabelValues(result, plan).Inc()
+}
+
+// UpdateQueueDepth 更新等待队列长度
+func (m *Metrics) UpdateQueueDepth(route, queue string, depth int) {
+	m.QueueDepth.WithLabelValues(route, queue).Set(float64(depth))
+}
+
+// RecordQueueWait 记录排队等待的时间
+func (m *Metrics) RecordQueueWait(route, queue string, duration time.Duration) {
+	m.QueueWaitDuration.WithLabelValues(route, queue).Observe(duration.Seconds())
+}
+
+// RecordQueueRejected 记录排队失败的请求
+func (m *Metrics) RecordQueueRejected(route, queue, reason string) {
+	m.QueueRejectedTotal.WithLabelValues(route, queue, reason).Inc()
 }
 
 // RecordCacheRequest 记录缓存请求指标
@@ -240,6 +330,11 @@ func (m *Metrics) RecordTokenValidation(result string) {
 	m.TokenValidationTotal.WithLabelValues(result).Inc()
 }
 
+// RecordAuthorizationDenied 记录授权拒绝指标
+func (m *Metrics) RecordAuthorizationDenied(route, reason string) {
+	m.AuthorizationDeniedTotal.WithLabelValues(route, reason).Inc()
+}
+
 // IncrementActiveConnections 增加活跃连接数
 func (m *Metrics) IncrementActiveConnections() {
 	m.ActiveConnections.Inc()
@@ -256,6 +351,12 @@ func (m *Metrics) UpdateSystemUptime(uptime time.Duration) {
 	m.SystemUptime.Set(uptime.Seconds())
 }
 
+// Prometheus指标注册在默认注册表上，同一进程内只能创建一次
+var (
+	defaultMetrics     *Metrics
+	defaultMetricsOnce sync.Once
+)
+
 // MetricsCollector 指标收集器
 type MetricsCollector struct {
 	metrics   *Metrics
@@ -264,8 +365,12 @@ type MetricsCollector struct {
 
 // NewMetricsCollector 创建指标收集器
 func NewMetricsCollector() *MetricsCollector {
+	defaultMetricsOnce.Do(func() {
+		defaultMetrics = NewMetrics()
+	})
+
 	return &MetricsCollector{
-		metrics:   NewMetrics(),
+		metrics:   defaultMetrics,
 		startTime: time.Now(),
 	}
 }
@@ -291,7 +396,6 @@ type RequestMetrics struct {
 	ResponseSize int64
 	Backend      string
 	CacheHit     bool
-	RateLimited  bool
 	Authenticated bool
 }
 
@@ -308,9 +412,6 @@ func (mc *MetricsCollector) Record(rm RequestMetrics) {
 	// 记录缓存指标
 	mc.metrics.RecordCacheRequest(rm.CacheHit)
 	
-	// 记录速率限制指标
-	mc.metrics.RecordRateLimit(!rm.RateLimited)
-	
 	// 记录认证指标
 	mc.metrics.RecordAuth(rm.Authenticated)
 }
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+const (
+	// forwardAuthCachePrefix 授权结果的缓存键前缀
+	forwardAuthCachePrefix = "forward_auth:"
+	// forwardAuthMaxBody 透传给客户端的拒绝响应体的最大字节数
+	forwardAuthMaxBody = 64 << 10
+)
+
+// forwardAuthRequest 发送给授权服务的请求内容
+type forwardAuthRequest struct {
+	Method  string             `json:"method"`
+	Path    string             `json:"path"`
+	Query   string             `json:"query,omitempty"`
+	Headers map[string]string  `json:"headers,omitempty"`
+	Claims  *forwardAuthClaims `json:"claims,omitempty"`
+}
+
+// forwardAuthClaims 认证中间件识别出的调用方身份
+type forwardAuthClaims struct {
+	UserID   string   `json:"user_id"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+	Scopes   []string `json:"scopes"`
+	APIKeyID string   `json:"api_key_id,omitempty"`
+}
+
+// forwardAuthDecision 授权服务的决定，允许时Headers为复制到上游请求的头，拒绝时为透传给客户端的响应
+type forwardAuthDecision struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// allowed 检查授权服务是否允许请求
+func (d *forwardAuthDecision) allowed() bool {
+	return d.Status >= 200 && d.Status < 300
+}
+
+// ForwardAuthMiddleware 外部授权中间件，代理前将请求的方法、路径、请求头和身份发送给授权服务，
+// 授权服务返回2xx时放行，返回401/403时将响应透传给客户端，授权服务不可用时拒绝请求
+type ForwardAuthMiddleware struct {
+	config config.ForwardAuthConfig
+	client *http.Client
+	cache  cache.Cache
+}
+
+// NewForwardAuthMiddleware 创建外部授权中间件
+func NewForwardAuthMiddleware(cfg config.ForwardAuthConfig, c cache.Cache) *ForwardAuthMiddleware {
+	return &ForwardAuthMiddleware{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		cache:  c,
+	}
+}
+
+// Name 返回中间件名称
+func (f *ForwardAuthMiddleware) Name() string {
+	return "forward_auth"
+}
+
+// Handle 处理外部授权
+func (f *ForwardAuthMiddleware) Handle() gin.HandlerFunc {
+	return gin.HandlerFunc(func(ctx *gin.Context) {
+		// 移除客户端提供的同名头，避免伪造授权服务的结果
+		for _, header := range f.config.UpstreamHeaders {
+			ctx.Request.Header.Del(header)
+		}
+
+		decision, err := f.authorize(ctx)
+		if err != nil {
+			logger.Errorf("外部授权失败 %s %s: %v", ctx.Request.Method, ctx.Request.URL.Path, err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "授权服务不可用"})
+			ctx.Abort()
+			return
+		}
+
+		if !decision.allowed() {
+			for name, value := range decision.Headers {
+				ctx.Header(name, value)
+			}
+			ctx.Data(decision.Status, decision.Headers["Content-Type"], []byte(decision.Body))
+			ctx.Abort()
+			return
+		}
+
+		for name, value := range decision.Headers {
+			ctx.Request.Header.Set(name, value)
+		}
+		ctx.Next()
+	})
+}
+
+// authorize 获取授权决定，优先使用缓存
+func (f *ForwardAuthMiddleware) authorize(ctx *gin.Context) (*forwardAuthDecision, error) {
+	body, err := json.Marshal(f.buildRequest(ctx))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	cacheKey := forwardAuthCachePrefix + hex.EncodeToString(sum[:])
+
+	if cached, err := f.cache.Get(ctx.Request.Context(), cacheKey); err == nil && cached != "" {
+		var decision forwardAuthDecision
+		if err := json.Unmarshal([]byte(cached), &decision); err == nil {
+			return &decision, nil
+		}
+	}
+
+	decision, err := f.call(ctx.Request.Context(), body)
+	if err != nil {
+		return nil, err
+	}
+	if f.config.CacheTTL > 0 {
+		if err := f.cache.Set(ctx.Request.Context(), cacheKey, decision, f.config.CacheTTL); err != nil {
+			logger.Errorf("缓存授权结果失败: %v", err)
+		}
+	}
+	return decision, nil
+}
+
+// buildRequest 按配置选取发送给授权服务的请求内容
+func (f *ForwardAuthMiddleware) buildRequest(ctx *gin.Context) *forwardAuthRequest {
+	req := &forwardAuthRequest{
+		Method: ctx.Request.Method,
+		Path:   ctx.Request.URL.Path,
+		Query:  ctx.Request.URL.RawQuery,
+	}
+
+	for _, name := range f.config.RequestHeaders {
+		if value := ctx.GetHeader(name); value != "" {
+			if req.Headers == nil {
+				req.Headers = make(map[string]string)
+			}
+			req.Headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+
+	if _, exists := ctx.Get("user_id"); f.config.IncludeClaims && exists {
+		req.Claims = &forwardAuthClaims{
+			UserID:   ctx.GetString("user_id"),
+			Username: ctx.GetString("username"),
+			Roles:    ctx.GetStringSlice("user_roles"),
+			Scopes:   ctx.GetStringSlice("user_scopes"),
+			APIKeyID: ctx.GetString("api_key_id"),
+		}
+	}
+	return req
+}
+
+// call 调用授权服务，2xx和401/403以外的状态码视为授权服务不可用
+func (f *ForwardAuthMiddleware) call(ctx context.Context, body []byte) (*forwardAuthDecision, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	decision := &forwardAuthDecision{Status: resp.StatusCode, Headers: make(map[string]string)}
+	switch {
+	case decision.allowed():
+		for _, name := range f.config.UpstreamHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				decision.Headers[http.CanonicalHeaderKey(name)] = value
+			}
+		}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		data, err := io.ReadAll(io.LimitReader(resp.Body, forwardAuthMaxBody))
+		if err != nil {
+			return nil, err
+		}
+		decision.Body = string(data)
+		for _, name := range []string{"Content-Type", "WWW-Authenticate"} {
+			if value := resp.Header.Get(name); value != "" {
+				decision.Headers[name] = value
+			}
+		}
+	default:
+		return nil, fmt.Errorf("授权服务返回状态码 %d", resp.StatusCode)
+	}
+	return decision, nil
+}
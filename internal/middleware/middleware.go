@@ -2,9 +2,8 @@ package middleware
 
 import (
 	"bytes"
-	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -12,6 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"api-gateway/internal/auth"
 	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
 	"api-gateway/internal/logger"
 	"api-gateway/internal/ratelimit"
 )
@@ -130,20 +130,40 @@ func (l *LoggingMiddleware) Handle() gin.HandlerFunc {
 
 // AuthMiddleware 认证中间件
 type AuthMiddleware struct {
-	tokenService *auth.TokenService
-	userService  auth.UserService
-	skipPaths    []string
+	validator   auth.TokenValidator
+	userService auth.UserService
+	skipPaths   []string
+
+	apiKeys      *auth.APIKeyService
+	apiKeyHeader string
+	apiKeyQuery  string
+
+	clientCert *config.ClientCertAuthConfig
 }
 
-// NewAuthMiddleware 创建认证中间件
-func NewAuthMiddleware(tokenService *auth.TokenService, userService auth.UserService, skipPaths []string) *AuthMiddleware {
+// NewAuthMiddleware 创建认证中间件，validator可以是网关的TokenService或外部令牌验证器
+func NewAuthMiddleware(validator auth.TokenValidator, userService auth.UserService, skipPaths []string) *AuthMiddleware {
 	return &AuthMiddleware{
-		tokenService: tokenService,
-		userService:  userService,
-		skipPaths:    skipPaths,
+		validator:   validator,
+		userService: userService,
+		skipPaths:   skipPaths,
 	}
 }
 
+// EnableAPIKeys 启用API密钥认证，密钥从header请求头或queryParam查询参数读取
+func (a *AuthMiddleware) EnableAPIKeys(apiKeys *auth.APIKeyService, header, queryParam string) *AuthMiddleware {
+	a.apiKeys = apiKeys
+	a.apiKeyHeader = header
+	a.apiKeyQuery = queryParam
+	return a
+}
+
+// EnableClientCerts 启用客户端证书认证，未携带令牌且客户端证书已由监听器验证时使用证书身份
+func (a *AuthMiddleware) EnableClientCerts(cfg config.ClientCertAuthConfig) *AuthMiddleware {
+	a.clientCert = &cfg
+	return a
+}
+
 // Name 返回中间件名称
 func (a *AuthMiddleware) Name() string {
 	return "auth"
@@ -161,8 +181,18 @@ func (a *AuthMiddleware) Handle() gin.HandlerFunc {
 			}
 		}
 
+		// 携带API密钥的请求使用API密钥认证
+		if apiKey := a.extractAPIKey(ctx); apiKey != "" {
+			a.authenticateAPIKey(ctx, apiKey)
+			return
+		}
+
 		// 从请求头获取token
 		authHeader := ctx.GetHeader("Authorization")
+		if authHeader == "" && a.authenticateClientCert(ctx) {
+			ctx.Next()
+			return
+		}
 		if authHeader == "" {
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证令牌"})
 			ctx.Abort()
@@ -180,15 +210,20 @@ func (a *AuthMiddleware) Handle() gin.HandlerFunc {
 		token := tokenParts[1]
 
 		// 验证token
-		claims, err := a.tokenService.ValidateToken(token)
+		claims, err := a.validator.ValidateToken(token)
+		if errors.Is(err, auth.ErrRevokedToken) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "认证令牌已被吊销"})
+			ctx.Abort()
+			return
+		}
 		if err != nil {
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
 			ctx.Abort()
 			return
 		}
 
-		// 检查用户是否活跃
-		if a.userService != nil {
+		// 检查用户是否活跃，外部身份提供方的用户不在本地用户服务中
+		if a.userService != nil && !claims.External {
 			active, err := a.userService.IsUserActive(claims.UserID)
 			if err != nil || !active {
 				ctx.JSON(http.StatusUnauthorized, gin.H{"error": "用户账户已被禁用"})
@@ -197,30 +232,231 @@ func (a *AuthMiddleware) Handle() gin.HandlerFunc {
 			}
 		}
 
-		// 将用户信息存储到上下文
-		ctx.Set("user_id", claims.UserID)
-		ctx.Set("username", claims.Username)
-		ctx.Set("user_roles", claims.Roles)
-		ctx.Set("claims", claims)
+		setIdentity(ctx, claims)
+		ctx.Next()
+	})
+}
+
+// authenticateClientCert 使用监听器验证过的客户端证书设置用户信息
+func (a *AuthMiddleware) authenticateClientCert(ctx *gin.Context) bool {
+	if a.clientCert == nil || ctx.Request.TLS == nil || len(ctx.Request.TLS.VerifiedChains) == 0 {
+		return false
+	}
+
+	cert := ctx.Request.TLS.VerifiedChains[0][0]
+	claims := auth.ClientCertClaims(cert, *a.clientCert)
+	if claims == nil {
+		return false
+	}
+	setIdentity(ctx, claims)
+	ctx.Set("client_cert_subject", cert.Subject.String())
+	return true
+}
+
+// extractAPIKey 从请求头或查询参数读取API密钥，并从请求中移除以免转发给后端
+func (a *AuthMiddleware) extractAPIKey(ctx *gin.Context) string {
+	if a.apiKeys == nil {
+		return ""
+	}
+
+	if key := ctx.GetHeader(a.apiKeyHeader); key != "" {
+		ctx.Request.Header.Del(a.apiKeyHeader)
+		return key
+	}
+
+	if a.apiKeyQuery != "" {
+		query := ctx.Request.URL.Query()
+		if key := query.Get(a.apiKeyQuery); key != "" {
+			query.Del(a.apiKeyQuery)
+			ctx.Request.URL.RawQuery = query.Encode()
+			return key
+		}
+	}
+	return ""
+}
+
+// authenticateAPIKey 验证API密钥并设置与JWT认证相同的用户信息
+func (a *AuthMiddleware) authenticateAPIKey(ctx *gin.Context, plaintext string) {
+	key, err := a.apiKeys.Authenticate(ctx.Request.Context(), plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrExpiredAPIKey):
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "API密钥已过期"})
+		case errors.Is(err, auth.ErrRevokedAPIKey):
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "API密钥已被吊销"})
+		case errors.Is(err, auth.ErrInvalidAPIKey):
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "无效的API密钥"})
+		default:
+			logger.Errorf("验证API密钥失败: %v", err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "认证服务暂不可用"})
+		}
+		ctx.Abort()
+		return
+	}
+
+	if !key.AllowsPath(ctx.Request.URL.Path) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "API密钥无权访问该路由"})
+		ctx.Abort()
+		return
+	}
+
+	setIdentity(ctx, &auth.Claims{
+		UserID:   key.Owner,
+		Username: key.Owner,
+		Roles:    key.Roles,
+		Type:     auth.TokenTypeAccess,
+		External: true,
+	})
+	ctx.Set("api_key_id", key.ID)
+	ctx.Set("rate_limit_tier", key.Tier)
+	ctx.Next()
+}
+
+// setIdentity 将用户信息存储到上下文
+func setIdentity(ctx *gin.Context, claims *auth.Claims) {
+	ctx.Set("user_id", claims.UserID)
+	ctx.Set("username", claims.Username)
+	ctx.Set("user_roles", claims.Roles)
+	ctx.Set("user_scopes", claims.Scopes())
+	ctx.Set("claims", claims)
+}
+
+// AuthorizationPolicy 授权策略，角色满足任一即可，权限范围需要全部具备
+type AuthorizationPolicy struct {
+	RequiredRoles  []string
+	RequiredScopes []string
+	MethodRules    []config.MethodRule
+}
+
+// AuthorizationMiddleware 基于角色和权限范围的授权中间件，需要在认证中间件之后使用
+type AuthorizationMiddleware struct {
+	policy   AuthorizationPolicy
+	onDenied func(ctx *gin.Context, reason string)
+}
+
+// NewAuthorizationMiddleware 创建授权中间件，onDenied在拒绝请求时调用，可以为nil
+func NewAuthorizationMiddleware(policy AuthorizationPolicy, onDenied func(ctx *gin.Context, reason string)) *AuthorizationMiddleware {
+	return &AuthorizationMiddleware{
+		policy:   policy,
+		onDenied: onDenied,
+	}
+}
+
+// Name 返回中间件名称
+func (a *AuthorizationMiddleware) Name() string {
+	return "authorization"
+}
+
+// Handle 处理授权
+func (a *AuthorizationMiddleware) Handle() gin.HandlerFunc {
+	return gin.HandlerFunc(func(ctx *gin.Context) {
+		// 未经认证的请求无法授权
+		if _, exists := ctx.Get("user_id"); !exists {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证令牌"})
+			ctx.Abort()
+			return
+		}
+
+		roles, _ := ctx.Get("user_roles")
+		scopes, _ := ctx.Get("user_scopes")
+		claims := &auth.Claims{}
+		claims.Roles, _ = roles.([]string)
+		if s, ok := scopes.([]string); ok {
+			claims.Scope = strings.Join(s, " ")
+		}
+
+		requiredRoles, requiredScopes := a.requirements(ctx.Request.Method)
+		for _, roles := range requiredRoles {
+			if !claims.HasAnyRole(roles...) {
+				a.deny(ctx, "missing_role", fmt.Sprintf("需要以下任一角色: %s", strings.Join(roles, ", ")))
+				return
+			}
+		}
+		for _, scope := range requiredScopes {
+			if !claims.HasScope(scope) {
+				a.deny(ctx, "missing_scope", fmt.Sprintf("需要权限范围: %s", scope))
+				return
+			}
+		}
 
 		ctx.Next()
 	})
 }
 
+// requirements 汇总请求方法需要满足的角色组和权限范围
+func (a *AuthorizationMiddleware) requirements(method string) ([][]string, []string) {
+	var roles [][]string
+	if len(a.policy.RequiredRoles) > 0 {
+		roles = append(roles, a.policy.RequiredRoles)
+	}
+	scopes := append([]string{}, a.policy.RequiredScopes...)
+
+	for _, rule := range a.policy.MethodRules {
+		for _, m := range rule.Methods {
+			if strings.EqualFold(m, method) {
+				if len(rule.RequiredRoles) > 0 {
+					roles = append(roles, rule.RequiredRoles)
+				}
+				scopes = append(scopes, rule.RequiredScopes...)
+				break
+			}
+		}
+	}
+	return roles, scopes
+}
+
+// deny 拒绝请求
+func (a *AuthorizationMiddleware) deny(ctx *gin.Context, reason, message string) {
+	if a.onDenied != nil {
+		a.onDenied(ctx, reason)
+	}
+	ctx.JSON(http.StatusForbidden, gin.H{
+		"error":   "权限不足",
+		"message": message,
+	})
+	ctx.Abort()
+}
+
+// unmatchedRoute 未匹配任何路由的请求在限流键中使用的路由名
+const unmatchedRoute = "unmatched"
+
 // RateLimitMiddleware 速率限制中间件
 type RateLimitMiddleware struct {
 	limiter     ratelimit.RateLimiter
 	defaultRate int
+	key         ratelimit.KeyTemplate
 }
 
-// NewRateLimitMiddleware 创建速率限制中间件
+// NewRateLimitMiddleware 创建速率限制中间件，按路由和用户（未认证时按客户端IP）计数
 func NewRateLimitMiddleware(limiter ratelimit.RateLimiter, defaultRate int) *RateLimitMiddleware {
+	key, _ := ratelimit.NewKeyTemplate(config.DefaultRateLimitKey)
 	return &RateLimitMiddleware{
 		limiter:     limiter,
 		defaultRate: defaultRate,
+		key:         key,
 	}
 }
 
+// RateLimitKeyRequest 从请求上下文收集生成限流键所需的信息，route为路由模式
+func RateLimitKeyRequest(ctx *gin.Context, route string) ratelimit.KeyRequest {
+	req := ratelimit.KeyRequest{
+		Route:    route,
+		Method:   ctx.Request.Method,
+		ClientIP: ctx.ClientIP(),
+		UserID:   ctx.GetString("user_id"),
+		APIKeyID: ctx.GetString("api_key_id"),
+		Header:   ctx.Request.Header,
+		Roles:    ctx.GetStringSlice("user_roles"),
+		Tier:     ctx.GetString("rate_limit_tier"),
+	}
+	if value, exists := ctx.Get("claims"); exists {
+		if claims, ok := value.(*auth.Claims); ok {
+			req.Claim = claims.Claim
+		}
+	}
+	return req
+}
+
 // Name 返回中间件名称
 func (r *RateLimitMiddleware) Name() string {
 	return "rate_limit"
@@ -229,15 +465,20 @@ func (r *RateLimitMiddleware) Name() string {
 // Handle 处理速率限制
 func (r *RateLimitMiddleware) Handle() gin.HandlerFunc {
 	return gin.HandlerFunc(func(ctx *gin.Context) {
-		clientIP := ctx.ClientIP()
-		userID, _ := ctx.Get("user_id")
-		path := ctx.Request.URL.Path
-
-		// 生成限制键
-		key := ratelimit.GenerateRateLimitKey(clientIP, fmt.Sprintf("%v", userID), path)
+		// 生成限制键，同一路由模式的请求共享计数，未匹配路由的请求使用同一个路由名
+		route := ctx.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		key, ok := r.key.Build(RateLimitKeyRequest(ctx, route))
+		if !ok {
+			// 请求中缺少键的组成部分时不限制，与路由的限流规则一致
+			ctx.Next()
+			return
+		}
 
 		// 检查速率限制
-		allowed, err := r.limiter.Allow(ctx.Request.Context(), key, r.defaultRate)
+		result, err := r.limiter.Allow(ctx.Request.Context(), key, r.defaultRate)
 		if err != nil {
 			logger.Errorf("速率限制检查失败: %v", err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "内部服务器错误"})
@@ -245,7 +486,8 @@ func (r *RateLimitMiddleware) Handle() gin.HandlerFunc {
 			return
 		}
 
-		if !allowed {
+		ratelimit.SetHeaders(ctx.Writer.Header(), result)
+		if !result.Allowed {
 			ctx.JSON(http.StatusTooManyRequests, gin.H{
 				"error":   "请求过于频繁",
 				"message": "请稍后再试",
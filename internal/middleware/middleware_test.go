@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+	"api-gateway/internal/ratelimit"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	logger.Init(config.LoggingConfig{Level: "error", Format: "text"})
+}
+
+func TestRateLimitMiddlewareUnmatchedRoutes(t *testing.T) {
+	limiter := ratelimit.NewLocalTokenBucketLimiter(nil, time.Minute, 0)
+	rateLimit := NewRateLimitMiddleware(limiter, 1)
+
+	router := gin.New()
+	router.Use(rateLimit.Handle())
+	router.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(path, clientIP string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = clientIP + ":12345"
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 未匹配路由的请求按客户端分别计数，不与其他客户端共享
+	assert.Equal(t, http.StatusNotFound, request("/missing", "10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/other", "10.0.0.1"))
+	assert.Equal(t, http.StatusNotFound, request("/missing", "10.0.0.2"))
+
+	// 与已匹配的路由分别计数
+	assert.Equal(t, http.StatusOK, request("/items", "10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/items", "10.0.0.1"))
+}
@@ -0,0 +1,35 @@
+package quota
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetHeaders 写入配额响应头：X-Quota-Limit（包含额外配额）、X-Quota-Remaining 和
+// X-Quota-Reset（距离窗口结束的秒数）取剩余请求数最少的窗口，剩余相同时取较长的窗口；
+// 请求被拒绝时写入 Retry-After
+func SetHeaders(header http.Header, usages []Usage, allowed bool, now time.Time) {
+	if len(usages) == 0 {
+		return
+	}
+
+	primary := usages[0]
+	for _, usage := range usages[1:] {
+		if usage.Remaining() <= primary.Remaining() {
+			primary = usage
+		}
+	}
+
+	reset := int64(math.Ceil(primary.ResetAt.Sub(now).Seconds()))
+	if reset < 0 {
+		reset = 0
+	}
+	header.Set("X-Quota-Limit", strconv.FormatInt(primary.Limit+primary.Granted, 10))
+	header.Set("X-Quota-Remaining", strconv.FormatInt(primary.Remaining(), 10))
+	header.Set("X-Quota-Reset", strconv.FormatInt(reset, 10))
+	if !allowed {
+		header.Set("Retry-After", strconv.FormatInt(reset, 10))
+	}
+}
@@ -0,0 +1,339 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+// Period 配额的日历窗口
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// Periods 所有窗口，按从短到长排列
+var Periods = []Period{Daily, Monthly}
+
+var (
+	// ErrUnknownGroup 配额组不存在
+	ErrUnknownGroup = errors.New("配额组不存在")
+	// ErrUnknownPeriod 配额组没有配置该窗口
+	ErrUnknownPeriod = errors.New("配额组没有配置该窗口")
+)
+
+// window 返回时间所在窗口的标识和结束时间
+func (p Period) window(now time.Time) (string, time.Time) {
+	year, month, day := now.Date()
+	if p == Daily {
+		return now.Format("2006-01-02"), time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	}
+	return now.Format("2006-01"), time.Date(year, month+1, 1, 0, 0, 0, 0, now.Location())
+}
+
+// limit 返回配额组在窗口内的配额，0表示不限制
+func (p Period) limit(group config.QuotaGroup) int64 {
+	switch p {
+	case Daily:
+		return group.Daily
+	case Monthly:
+		return group.Monthly
+	default:
+		return 0
+	}
+}
+
+// Usage 调用方在一个配额组的一个窗口内的用量
+type Usage struct {
+	Consumer string    `json:"consumer"`
+	Group    string    `json:"group"`
+	Period   Period    `json:"period"`
+	Window   string    `json:"window"` // 例如 2026-10-16 或 2026-10
+	Limit    int64     `json:"limit"`  // 配置的配额，不含额外授予的配额
+	Granted  int64     `json:"granted"`
+	Used     int64     `json:"used"`
+	ResetAt  time.Time `json:"reset_at"`
+}
+
+// Remaining 返回窗口内剩余的请求数
+func (u Usage) Remaining() int64 {
+	remaining := u.Limit + u.Granted - u.Used
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// key 用量在Redis和快照中的键
+func (u Usage) key() string {
+	return fmt.Sprintf("quota:%s:%s:%s:%s", u.Group, u.Period, u.Window, u.Consumer)
+}
+
+// Manager 配额管理器。配置了Redis时计数保存在Redis中，由所有网关实例共享，
+// Redis不可用时暂时在本实例内计数。最近看到的用量同时保存在内存中并定期写入快照文件，
+// Redis中的计数丢失（例如被清空）时从中恢复
+type Manager struct {
+	cache cache.ScriptRunner // 为nil时只在本实例内计数
+	file  string
+
+	groups   map[string]config.QuotaGroup
+	location *time.Location
+	usage    map[string]*Usage
+	mutex    sync.Mutex
+	now      func() time.Time
+
+	degraded atomic.Bool
+	retryAt  atomic.Int64
+}
+
+// NewManager 创建配额管理器并加载快照，缓存不支持脚本时只在本实例内计数
+func NewManager(cfg config.QuotaConfig, c cache.Cache) (*Manager, error) {
+	m := &Manager{
+		file:  cfg.SnapshotFile,
+		usage: make(map[string]*Usage),
+		now:   time.Now,
+	}
+	if runner, ok := c.(cache.ScriptRunner); ok {
+		m.cache = runner
+	}
+	if err := m.Configure(cfg); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Configure 更新配额组和时区，已有的计数保留。快照文件修改后需要重启才能生效
+func (m *Manager) Configure(cfg config.QuotaConfig) error {
+	location, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		return fmt.Errorf("无效的配额时区 %s: %w", cfg.TimeZone, err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.groups = cfg.Groups
+	m.location = location
+	return nil
+}
+
+// SetClock 设置获取当前时间的函数
+func (m *Manager) SetClock(now func() time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.now = now
+}
+
+// Consume 为调用方在配额组内计入一次请求，任一窗口的配额用尽时不计数并返回false。
+// 返回配额组配置的每个窗口的用量
+func (m *Manager) Consume(ctx context.Context, consumer, group string) ([]Usage, bool, error) {
+	usages, err := m.windows(consumer, group, "")
+	if err != nil || len(usages) == 0 {
+		return usages, true, err
+	}
+
+	if m.useRedis() {
+		allowed, err := m.consumeRedis(ctx, usages)
+		if m.redisResult(err) {
+			m.remember(usages)
+			return usages, allowed, nil
+		}
+	}
+	return usages, m.consumeLocal(usages), nil
+}
+
+// Get 返回调用方在所有配额组内的用量
+func (m *Manager) Get(ctx context.Context, consumer string) ([]Usage, error) {
+	m.mutex.Lock()
+	groups := make([]string, 0, len(m.groups))
+	for name := range m.groups {
+		groups = append(groups, name)
+	}
+	m.mutex.Unlock()
+	sort.Strings(groups)
+
+	var result []Usage
+	for _, group := range groups {
+		usages, err := m.windows(consumer, group, "")
+		if err != nil {
+			return nil, err
+		}
+		for i := range usages {
+			if err := m.adjust(ctx, &usages[i], "used", 0); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, usages...)
+	}
+	return result, nil
+}
+
+// Grant 为调用方在当前窗口内授予额外的配额，amount为负数时收回
+func (m *Manager) Grant(ctx context.Context, consumer, group string, period Period, amount int64) (Usage, error) {
+	usages, err := m.windows(consumer, group, period)
+	if err != nil {
+		return Usage{}, err
+	}
+	if err := m.adjust(ctx, &usages[0], "granted", amount); err != nil {
+		return Usage{}, err
+	}
+	return usages[0], nil
+}
+
+// Reset 清除调用方在当前窗口内的用量和额外配额，period为空时重置配额组的所有窗口
+func (m *Manager) Reset(ctx context.Context, consumer, group string, period Period) error {
+	usages, err := m.windows(consumer, group, period)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(usages))
+	m.mutex.Lock()
+	for _, usage := range usages {
+		keys = append(keys, usage.key())
+		delete(m.usage, usage.key())
+	}
+	m.mutex.Unlock()
+
+	if m.cache == nil {
+		return nil
+	}
+	return m.cache.Del(ctx, keys...)
+}
+
+// windows 返回配额组当前的窗口，已用量和额外配额取自内存中的用量，period为空时返回所有配置了配额的窗口
+func (m *Manager) windows(consumer, group string, period Period) ([]Usage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	groupConfig, exists := m.groups[group]
+	if !exists {
+		return nil, ErrUnknownGroup
+	}
+	if period != "" && period.limit(groupConfig) == 0 {
+		return nil, ErrUnknownPeriod
+	}
+
+	now := m.now().In(m.location)
+	var usages []Usage
+	for _, p := range Periods {
+		limit := p.limit(groupConfig)
+		if limit == 0 || (period != "" && p != period) {
+			continue
+		}
+		window, resetAt := p.window(now)
+		usage := Usage{Consumer: consumer, Group: group, Period: p, Window: window, Limit: limit, ResetAt: resetAt}
+		if stored, exists := m.usage[usage.key()]; exists {
+			usage.Used = stored.Used
+			usage.Granted = stored.Granted
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// consumeLocal 在本实例内计数
+func (m *Manager) consumeLocal(usages []Usage) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	allowed := true
+	for i := range usages {
+		if stored, exists := m.usage[usages[i].key()]; exists {
+			usages[i].Used = stored.Used
+			usages[i].Granted = stored.Granted
+		}
+		if usages[i].Remaining() == 0 {
+			allowed = false
+		}
+	}
+	if !allowed {
+		return false
+	}
+	for i := range usages {
+		usages[i].Used++
+		stored := usages[i]
+		m.usage[stored.key()] = &stored
+	}
+	return true
+}
+
+// adjust 将用量的一个字段增加amount并读取最新的用量
+func (m *Manager) adjust(ctx context.Context, usage *Usage, field string, amount int64) error {
+	if m.useRedis() {
+		err := m.adjustRedis(ctx, usage, field, amount)
+		if m.redisResult(err) {
+			m.remember([]Usage{*usage})
+			return nil
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, exists := m.usage[usage.key()]
+	if !exists {
+		if amount == 0 {
+			return nil
+		}
+		copied := *usage
+		stored = &copied
+		m.usage[usage.key()] = stored
+	}
+	if field == "granted" {
+		stored.Granted += amount
+	} else {
+		stored.Used += amount
+	}
+	usage.Used = stored.Used
+	usage.Granted = stored.Granted
+	return nil
+}
+
+// remember 记录Redis返回的最新用量
+func (m *Manager) remember(usages []Usage) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, usage := range usages {
+		copied := usage
+		m.usage[usage.key()] = &copied
+	}
+}
+
+// useRedis 检查是否应该尝试Redis，Redis不可用期间每隔一段时间才重新尝试
+func (m *Manager) useRedis() bool {
+	if m.cache == nil {
+		return false
+	}
+	return !m.degraded.Load() || time.Now().UnixNano() >= m.retryAt.Load()
+}
+
+// redisResult 记录Redis调用的结果，失败时返回false，由调用方改为在本实例内计数
+func (m *Manager) redisResult(err error) bool {
+	if err != nil {
+		m.retryAt.Store(time.Now().Add(redisRetryInterval).UnixNano())
+		if m.degraded.CompareAndSwap(false, true) {
+			logger.Warnf("Redis配额计数不可用，暂时在当前实例内计数: %v", err)
+		}
+		return false
+	}
+	if m.degraded.CompareAndSwap(true, false) {
+		logger.Info("Redis配额计数已恢复")
+	}
+	return true
+}
@@ -0,0 +1,249 @@
+package quota
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+func init() {
+	logger.Init(config.LoggingConfig{Level: "error", Format: "text"})
+}
+
+// newRedisServer 启动进程内执行Lua脚本的Redis，返回连接它的缓存
+func newRedisServer(t *testing.T, now time.Time) (*miniredis.Miniredis, cache.Cache) {
+	server := miniredis.RunT(t)
+	server.SetTime(now)
+	c, err := cache.NewRedisCache(config.RedisConfig{Addr: server.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return server, c
+}
+
+func newTestManager(t *testing.T, file string, now *time.Time) *Manager {
+	return newRedisTestManager(t, file, now, cache.NewMemoryCache())
+}
+
+// newRedisTestManager 创建使用指定缓存的配额管理器
+func newRedisTestManager(t *testing.T, file string, now *time.Time, c cache.Cache) *Manager {
+	manager, err := NewManager(config.QuotaConfig{
+		TimeZone:     "UTC",
+		SnapshotFile: file,
+		Groups: map[string]config.QuotaGroup{
+			"orders":  {Daily: 2, Monthly: 3},
+			"reports": {Monthly: 1},
+		},
+	}, c)
+	require.NoError(t, err)
+	manager.SetClock(func() time.Time { return *now })
+	return manager
+}
+
+// consume 计入n次请求，返回放行的次数
+func consume(t *testing.T, m *Manager, consumer, group string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		_, ok, err := m.Consume(context.Background(), consumer, group)
+		require.NoError(t, err)
+		if ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestManagerCalendarWindows(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	manager := newTestManager(t, "", &now)
+	ctx := context.Background()
+
+	usages, allowed, err := manager.Consume(ctx, "alice", "orders")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	require.Len(t, usages, 2)
+	assert.Equal(t, Usage{Consumer: "alice", Group: "orders", Period: Daily, Window: "2026-01-31", Limit: 2, Used: 1,
+		ResetAt: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)}, usages[0])
+	assert.Equal(t, "2026-01", usages[1].Window)
+
+	// 每日配额用尽后被拒绝且不计数
+	assert.Equal(t, 1, consume(t, manager, "alice", "orders", 2))
+	usages, allowed, err = manager.Consume(ctx, "alice", "orders")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(2), usages[1].Used)
+
+	// 其他调用方和配额组分别计数
+	assert.Equal(t, 2, consume(t, manager, "bob", "orders", 3))
+	assert.Equal(t, 1, consume(t, manager, "alice", "reports", 2))
+	_, _, err = manager.Consume(ctx, "alice", "billing")
+	assert.ErrorIs(t, err, ErrUnknownGroup)
+
+	// 新的一天、新的一个月
+	now = now.Add(30 * time.Minute)
+	assert.Equal(t, 0, consume(t, manager, "alice", "orders", 1))
+	now = now.Add(time.Hour)
+	assert.Equal(t, 2, consume(t, manager, "alice", "orders", 3))
+	assert.Equal(t, 1, consume(t, manager, "alice", "reports", 1))
+}
+
+func TestManagerGrantAndReset(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	manager := newTestManager(t, "", &now)
+	ctx := context.Background()
+
+	assert.Equal(t, 2, consume(t, manager, "alice", "orders", 3))
+
+	usage, err := manager.Grant(ctx, "alice", "orders", Daily, 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), usage.Granted)
+	assert.Equal(t, int64(5), usage.Remaining())
+	// 每月配额仍然限制
+	assert.Equal(t, 1, consume(t, manager, "alice", "orders", 3))
+
+	_, err = manager.Grant(ctx, "alice", "reports", Daily, 1)
+	assert.ErrorIs(t, err, ErrUnknownPeriod)
+
+	require.NoError(t, manager.Reset(ctx, "alice", "orders", Monthly))
+	usages, err := manager.Get(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, usages, 3)
+	assert.Equal(t, int64(3), usages[0].Used)
+	assert.Equal(t, int64(5), usages[0].Granted)
+	assert.Equal(t, int64(0), usages[1].Used)
+	assert.Equal(t, "reports", usages[2].Group)
+
+	require.NoError(t, manager.Reset(ctx, "alice", "orders", ""))
+	assert.Equal(t, 2, consume(t, manager, "alice", "orders", 3))
+}
+
+func TestManagerSnapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quotas.json")
+	now := time.Date(2026, 5, 20, 8, 0, 0, 0, time.UTC)
+	manager := newTestManager(t, file, &now)
+
+	assert.Equal(t, 2, consume(t, manager, "alice", "orders", 2))
+	assert.Equal(t, 1, consume(t, manager, "alice", "reports", 1))
+	require.NoError(t, manager.Save())
+
+	// 重启后从快照恢复
+	restored := newTestManager(t, file, &now)
+	assert.Equal(t, 0, consume(t, restored, "alice", "orders", 1))
+	assert.Equal(t, 0, consume(t, restored, "alice", "reports", 1))
+
+	// 快照中已经结束的窗口不再使用，保存时被清理
+	now = time.Date(2026, 5, 21, 8, 0, 0, 0, time.UTC)
+	restored = newTestManager(t, file, &now)
+	assert.Equal(t, 1, consume(t, restored, "alice", "orders", 2))
+	assert.Equal(t, 0, consume(t, restored, "alice", "reports", 1))
+
+	require.NoError(t, restored.Save())
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "2026-05-20")
+	assert.Contains(t, string(data), "2026-05-21")
+}
+
+func TestManagerSharedRedis(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quotas.json")
+	now := time.Date(2026, 5, 20, 8, 0, 0, 0, time.UTC)
+	seeded := newTestManager(t, file, &now)
+	assert.Equal(t, 1, consume(t, seeded, "alice", "orders", 1))
+	require.NoError(t, seeded.Save())
+
+	// 新的Redis只用快照初始化一次
+	server, c := newRedisServer(t, now)
+	replicas := []*Manager{
+		newRedisTestManager(t, file, &now, c),
+		newRedisTestManager(t, file, &now, c),
+	}
+	assert.Equal(t, 1, consume(t, replicas[0], "alice", "orders", 1))
+	assert.Equal(t, 0, consume(t, replicas[1], "alice", "orders", 1))
+	assert.False(t, replicas[1].degraded.Load())
+	assert.True(t, server.Exists(epochKey))
+	// 用量键在窗口结束时过期
+	assert.Equal(t, 16*time.Hour, server.TTL("quota:orders:daily:2026-05-20:alice"))
+
+	// 一个实例重置后，其他实例内存中旧的用量不会写回Redis
+	ctx := context.Background()
+	require.NoError(t, replicas[0].Reset(ctx, "alice", "orders", ""))
+	assert.Equal(t, 2, consume(t, replicas[1], "alice", "orders", 3))
+	usages, err := replicas[0].Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usages[0].Used)
+
+	// 额外配额的授予和收回对所有实例生效
+	_, err = replicas[0].Grant(ctx, "alice", "orders", Daily, 1)
+	require.NoError(t, err)
+	_, err = replicas[0].Grant(ctx, "alice", "orders", Daily, -1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, consume(t, replicas[1], "alice", "orders", 1))
+
+	// Redis被清空后用内存中的用量恢复
+	require.NoError(t, replicas[1].Reset(ctx, "alice", "orders", ""))
+	assert.Equal(t, 1, consume(t, replicas[0], "alice", "orders", 1))
+	server.FlushAll()
+	assert.Equal(t, 1, consume(t, replicas[0], "alice", "orders", 2))
+	assert.True(t, server.Exists(epochKey))
+	usages, err = replicas[1].Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usages[0].Used)
+}
+
+func TestManagerRunPrunesWithoutSnapshot(t *testing.T) {
+	now := time.Date(2026, 5, 20, 8, 0, 0, 0, time.UTC)
+	manager := newTestManager(t, "", &now)
+	assert.Equal(t, 1, consume(t, manager, "alice", "reports", 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	// 当前窗口保留，结束后被清理
+	time.Sleep(5 * time.Millisecond)
+	manager.mutex.Lock()
+	assert.Len(t, manager.usage, 1)
+	now = time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	manager.mutex.Unlock()
+	assert.Eventually(t, func() bool {
+		manager.mutex.Lock()
+		defer manager.mutex.Unlock()
+		return len(manager.usage) == 0
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestSetHeaders(t *testing.T) {
+	now := time.Date(2026, 5, 20, 23, 59, 0, 0, time.UTC)
+	usages := []Usage{
+		{Period: Daily, Limit: 10, Used: 4, ResetAt: now.Add(time.Minute)},
+		{Period: Monthly, Limit: 100, Granted: 5, Used: 100, ResetAt: now.Add(11 * 24 * time.Hour)},
+	}
+
+	header := http.Header{}
+	SetHeaders(header, usages, true, now)
+	assert.Equal(t, "105", header.Get("X-Quota-Limit"))
+	assert.Equal(t, "5", header.Get("X-Quota-Remaining"))
+	assert.Equal(t, "950400", header.Get("X-Quota-Reset"))
+	assert.Empty(t, header.Get("Retry-After"))
+
+	usages[0].Used = 10
+	header = http.Header{}
+	SetHeaders(header, usages, false, now)
+	assert.Equal(t, "0", header.Get("X-Quota-Remaining"))
+	assert.Equal(t, "60", header.Get("Retry-After"))
+}
@@ -0,0 +1,193 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"api-gateway/internal/logger"
+)
+
+const (
+	// redisTimeout 单次Redis配额调用的超时时间，超时后在本实例内计数
+	redisTimeout = 100 * time.Millisecond
+	// redisRetryInterval Redis不可用期间重新尝试Redis的间隔
+	redisRetryInterval = time.Second
+)
+
+// epochKey 记录Redis中的计数已经初始化的键。键不存在说明Redis是新的或被清空，
+// 此时用内存中的用量（启动时来自快照）恢复一次计数，之后键不存在的用量都从0开始，
+// 避免其他实例用旧的用量覆盖管理接口的重置或收回
+const epochKey = "quota:epoch"
+
+// consumeScript 检查并计入一次请求，所有窗口都有剩余配额时才计数，用量键在窗口结束时过期。
+// KEYS[1] 初始化标记；之后为每个窗口的用量键，每个窗口依次对应 ARGV 中的 配额、过期时间（Unix秒）。
+// 计数未初始化时返回 {-1}，否则返回 {是否允许, 第一个窗口的已用量, 第一个窗口的额外配额, ...}
+var consumeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return {-1}
+end
+
+local allowed = 1
+local result = {0}
+for i = 2, #KEYS do
+  local base = (i - 2) * 2
+  local state = redis.call('HMGET', KEYS[i], 'used', 'granted')
+  local used = tonumber(state[1]) or 0
+  local granted = tonumber(state[2]) or 0
+  if used >= tonumber(ARGV[base + 1]) + granted then
+    allowed = 0
+  end
+  result[(i - 1) * 2] = used
+  result[(i - 1) * 2 + 1] = granted
+end
+
+if allowed == 1 then
+  for i = 2, #KEYS do
+    result[(i - 1) * 2] = redis.call('HINCRBY', KEYS[i], 'used', 1)
+    redis.call('EXPIREAT', KEYS[i], ARGV[(i - 2) * 2 + 2])
+  end
+end
+result[1] = allowed
+return result
+`)
+
+// adjustScript 将用量的一个字段增加指定的数量，amount为0时只读取用量，不会创建用量键。
+// KEYS[1] 初始化标记；KEYS[2] 用量键；ARGV[1] 过期时间（Unix秒）；ARGV[2] 字段；ARGV[3] 增加的数量。
+// 计数未初始化时返回 {-1}，否则返回 {已用量, 额外配额}
+var adjustScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return {-1}
+end
+
+if tonumber(ARGV[3]) ~= 0 then
+  redis.call('HINCRBY', KEYS[2], ARGV[2], ARGV[3])
+  redis.call('EXPIREAT', KEYS[2], ARGV[1])
+end
+local state = redis.call('HMGET', KEYS[2], 'used', 'granted')
+return {tonumber(state[1]) or 0, tonumber(state[2]) or 0}
+`)
+
+// restoreScript 计数未初始化时写入恢复的用量并设置初始化标记，已经被其他实例初始化时不做修改。
+// KEYS[1] 初始化标记；之后为用量键，每个用量依次对应 ARGV 中的 已用量、额外配额、过期时间（Unix秒）；
+// ARGV 最后一个参数为标记的值。返回是否写入了用量
+var restoreScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return 0
+end
+
+for i = 2, #KEYS do
+  local base = (i - 2) * 3
+  if redis.call('EXISTS', KEYS[i]) == 0 then
+    redis.call('HSET', KEYS[i], 'used', ARGV[base + 1], 'granted', ARGV[base + 2])
+    redis.call('EXPIREAT', KEYS[i], ARGV[base + 3])
+  end
+end
+redis.call('SET', KEYS[1], ARGV[#ARGV])
+return 1
+`)
+
+// errNotRestored Redis中的计数尚未初始化
+var errNotRestored = errors.New("Redis中的配额计数未初始化")
+
+// consumeRedis 在Redis中计入一次请求，并用Redis中的用量更新usages
+func (m *Manager) consumeRedis(ctx context.Context, usages []Usage) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	keys := make([]string, 0, len(usages)+1)
+	keys = append(keys, epochKey)
+	args := make([]interface{}, 0, len(usages)*2)
+	for _, usage := range usages {
+		keys = append(keys, usage.key())
+		args = append(args, usage.Limit, usage.ResetAt.Unix())
+	}
+
+	numbers, err := m.runScript(ctx, consumeScript, keys, 1+len(usages)*2, args...)
+	if err != nil {
+		return false, err
+	}
+	for i := range usages {
+		usages[i].Used = numbers[1+i*2]
+		usages[i].Granted = numbers[2+i*2]
+	}
+	return numbers[0] == 1, nil
+}
+
+// adjustRedis 在Redis中调整用量，并用Redis中的用量更新usage
+func (m *Manager) adjustRedis(ctx context.Context, usage *Usage, field string, amount int64) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	numbers, err := m.runScript(ctx, adjustScript, []string{epochKey, usage.key()}, 2,
+		usage.ResetAt.Unix(), field, amount)
+	if err != nil {
+		return err
+	}
+	usage.Used = numbers[0]
+	usage.Granted = numbers[1]
+	return nil
+}
+
+// restoreRedis 用内存中尚未结束的窗口的用量初始化Redis中的计数
+func (m *Manager) restoreRedis(ctx context.Context) error {
+	m.mutex.Lock()
+	now := m.now()
+	keys := []string{epochKey}
+	var args []interface{}
+	for key, usage := range m.usage {
+		if !now.Before(usage.ResetAt) {
+			continue
+		}
+		keys = append(keys, key)
+		args = append(args, usage.Used, usage.Granted, usage.ResetAt.Unix())
+	}
+	m.mutex.Unlock()
+	args = append(args, now.Unix())
+
+	reply, err := m.cache.RunScript(ctx, restoreScript, keys, args...)
+	if err != nil {
+		return err
+	}
+	if restored, ok := reply.(int64); ok && restored == 1 {
+		logger.Infof("已用 %d 条用量初始化Redis中的配额计数", len(keys)-1)
+	}
+	return nil
+}
+
+// runScript 执行配额脚本，返回值需要是count个整数。Redis中的计数未初始化时先恢复再重新执行
+func (m *Manager) runScript(ctx context.Context, script *redis.Script, keys []string, count int, args ...interface{}) ([]int64, error) {
+	numbers, err := m.evalScript(ctx, script, keys, count, args...)
+	if !errors.Is(err, errNotRestored) {
+		return numbers, err
+	}
+	if err := m.restoreRedis(ctx); err != nil {
+		return nil, err
+	}
+	return m.evalScript(ctx, script, keys, count, args...)
+}
+
+// evalScript 执行一次配额脚本并检查返回值
+func (m *Manager) evalScript(ctx context.Context, script *redis.Script, keys []string, count int, args ...interface{}) ([]int64, error) {
+	reply, err := m.cache.RunScript(ctx, script, keys, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if ok && len(values) == 1 && values[0] == int64(-1) {
+		return nil, errNotRestored
+	}
+	if !ok || len(values) != count {
+		return nil, fmt.Errorf("无效的配额脚本返回值: %v", reply)
+	}
+	numbers := make([]int64, len(values))
+	for i, value := range values {
+		if numbers[i], ok = value.(int64); !ok {
+			return nil, fmt.Errorf("无效的配额脚本返回值: %v", reply)
+		}
+	}
+	return numbers, nil
+}
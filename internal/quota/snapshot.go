@@ -0,0 +1,116 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"api-gateway/internal/logger"
+)
+
+// snapshot 用量快照文件的内容
+type snapshot struct {
+	SavedAt time.Time `json:"saved_at"`
+	Usage   []*Usage  `json:"usage"`
+}
+
+// Run 定期保存用量快照并清理已经结束的窗口，未配置快照文件时只清理。ctx取消时保存最后一次快照
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.Save(); err != nil {
+				logger.Errorf("保存配额快照失败: %v", err)
+			}
+			return
+		case <-tick:
+			if err := m.Save(); err != nil {
+				logger.Errorf("保存配额快照失败: %v", err)
+			}
+		}
+	}
+}
+
+// Save 将当前窗口的用量写入快照文件，同时清理已经结束的窗口，未配置快照文件时只清理
+func (m *Manager) Save() error {
+	m.mutex.Lock()
+	now := m.now()
+	usage := make([]*Usage, 0, len(m.usage))
+	for key, u := range m.usage {
+		if !now.Before(u.ResetAt) {
+			delete(m.usage, key)
+			continue
+		}
+		copied := *u
+		usage = append(usage, &copied)
+	}
+	m.mutex.Unlock()
+
+	if m.file == "" {
+		return nil
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].key() < usage[j].key()
+	})
+	data, err := json.MarshalIndent(snapshot{SavedAt: now, Usage: usage}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), ".quotas-*.json")
+	if err != nil {
+		return fmt.Errorf("写入配额快照失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入配额快照失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入配额快照失败: %w", err)
+	}
+	return os.Rename(tmp.Name(), m.file)
+}
+
+// load 加载快照中的用量，文件不存在时忽略。已经结束的窗口不会再被使用，在下次保存时清理
+func (m *Manager) load() error {
+	if m.file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取配额快照失败: %w", err)
+	}
+
+	var saved snapshot
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("解析配额快照失败: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, usage := range saved.Usage {
+		m.usage[usage.key()] = usage
+	}
+	logger.Infof("已加载配额快照，保存于 %s", saved.SavedAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPolicy 未指定策略名时 RateLimit/RateLimit-Policy 响应头使用的策略名
+const DefaultPolicy = "default"
+
+// SetHeaders 写入限流响应头：RateLimit-Policy 和 RateLimit 按IETF草案列出每个策略，
+// X-RateLimit-Limit、X-RateLimit-Remaining、X-RateLimit-Reset（距离恢复的秒数）取拒绝请求的策略，
+// 都放行时取剩余请求数最少的策略；被拒绝时写入 Retry-After
+func SetHeaders(header http.Header, results ...Result) {
+	if len(results) == 0 {
+		return
+	}
+
+	primary := results[0]
+	policies := make([]string, 0, len(results))
+	states := make([]string, 0, len(results))
+	for _, r := range results {
+		policy := r.Policy
+		if policy == "" {
+			policy = DefaultPolicy
+		}
+		policies = append(policies, fmt.Sprintf("%q;q=%d;w=%d", policy, r.Limit, ceilSeconds(r.Window)))
+		states = append(states, fmt.Sprintf("%q;r=%d;t=%d", policy, r.Remaining, ceilSeconds(r.Reset)))
+
+		if primary.Allowed && (!r.Allowed || r.Remaining < primary.Remaining) {
+			primary = r
+		}
+	}
+
+	header.Set("X-RateLimit-Limit", strconv.Itoa(primary.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(primary.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(primary.Reset), 10))
+	header.Set("RateLimit-Policy", strings.Join(policies, ", "))
+	header.Set("RateLimit", strings.Join(states, ", "))
+
+	if !primary.Allowed {
+		retryAfter := ceilSeconds(primary.RetryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		header.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	}
+}
+
+// ceilSeconds 向上取整的秒数
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
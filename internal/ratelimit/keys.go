@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// KeyRequest 生成限流键所需的请求信息
+type KeyRequest struct {
+	Route    string // 路由模式，而不是请求的实际路径
+	Method   string
+	ClientIP string
+	UserID   string
+	APIKeyID string
+	Header   http.Header
+	Claim    func(path string) string // 读取令牌声明，未认证时为nil
+	Roles    []string
+	Tier     string // API密钥的速率限制等级
+}
+
+// KeyTemplate 由多个部分组成的限流键模板
+type KeyTemplate []config.RateLimitKeyPart
+
+// NewKeyTemplate 解析限流键模板，parts为空时使用 config.DefaultRateLimitKey
+func NewKeyTemplate(parts []string) (KeyTemplate, error) {
+	if len(parts) == 0 {
+		parts = config.DefaultRateLimitKey
+	}
+	template, err := config.ParseRateLimitKey(parts)
+	if err != nil {
+		return nil, err
+	}
+	return KeyTemplate(template), nil
+}
+
+// Build 生成限流键，任一部分在请求中不存在时返回false
+func (t KeyTemplate) Build(req KeyRequest) (string, bool) {
+	var key strings.Builder
+	for i, part := range t {
+		value := keyPartValue(part, req)
+		if value == "" {
+			return "", false
+		}
+		if i > 0 {
+			key.WriteByte('|')
+		}
+		key.WriteString(part.Kind)
+		if part.Arg != "" {
+			key.WriteByte(':')
+			key.WriteString(strings.ToLower(part.Arg))
+		}
+		key.WriteByte('=')
+		key.WriteString(url.QueryEscape(value))
+	}
+	return key.String(), true
+}
+
+// keyPartValue 返回键的一个部分在请求中的值
+func keyPartValue(part config.RateLimitKeyPart, req KeyRequest) string {
+	switch part.Kind {
+	case "route":
+		return req.Route
+	case "method":
+		return req.Method
+	case "ip":
+		return req.ClientIP
+	case "ip_prefix":
+		return ipPrefix(req.ClientIP, part.IPv4Prefix, part.IPv6Prefix)
+	case "user":
+		if req.UserID != "" {
+			return req.UserID
+		}
+		return "ip:" + req.ClientIP
+	case "api_key":
+		return req.APIKeyID
+	case "header":
+		return req.Header.Get(part.Arg)
+	case "claim":
+		if req.Claim == nil {
+			return ""
+		}
+		return req.Claim(part.Arg)
+	default:
+		return ""
+	}
+}
+
+// ipPrefix 返回IP所在的网段，例如 192.0.2.0/24
+func ipPrefix(ip string, v4Bits, v6Bits int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(v4Bits, 32)).String() + "/" + strconv.Itoa(v4Bits)
+	}
+	return parsed.Mask(net.CIDRMask(v6Bits, 128)).String() + "/" + strconv.Itoa(v6Bits)
+}
@@ -3,6 +3,8 @@ package ratelimit
 import (
 	"context"
 	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -10,174 +12,234 @@ import (
 	"api-gateway/internal/logger"
 )
 
-// RateLimiter 速率限制器接口
+// RateLimiter 速率限制器接口，limit为每个窗口允许的请求数
 type RateLimiter interface {
-	Allow(ctx context.Context, key string, limit int) (bool, error)
+	Allow(ctx context.Context, key string, limit int) (Result, error)
 	Reset(ctx context.Context, key string) error
 }
 
-// TokenBucketLimiter 令牌桶速率限制器
-type TokenBucketLimiter struct {
-	cache   cache.Cache
-	buckets map[string]*rate.Limiter
+// Result 一次限流检查的结果
+type Result struct {
+	Policy     string // 策略名，由调用方设置，用于 RateLimit/RateLimit-Policy 响应头
+	Allowed    bool
+	Limit      int           // 每个窗口允许的请求数
+	Remaining  int           // 本次请求之后剩余的请求数
+	Window     time.Duration // 限额对应的时间窗口
+	Reset      time.Duration // 距离限额恢复的时间，令牌桶和GCRA为补满的时间，其他算法为下一个请求名额释放的时间
+	RetryAfter time.Duration // 被拒绝时距离可以重试的时间
 }
 
-// NewTokenBucketLimiter 创建令牌桶限制器
-func NewTokenBucketLimiter(cache cache.Cache) *TokenBucketLimiter {
-	return &TokenBucketLimiter{
-		cache:   cache,
-		buckets: make(map[string]*rate.Limiter),
-	}
+// rejectAll 限额不大于0时拒绝所有请求
+func rejectAll(limit int, window time.Duration) Result {
+	return Result{Limit: limit, Window: window, Reset: window, RetryAfter: window}
 }
 
-// Allow 检查是否允许请求
-func (tbl *TokenBucketLimiter) Allow(ctx context.Context, key string, limit int) (bool, error) {
-	limiter, exists := tbl.buckets[key]
-	if !exists {
-		// 创建新的限制器，每秒最多limit个请求，突发容量为limit
-		limiter = rate.NewLimiter(rate.Limit(limit), limit)
-		tbl.buckets[key] = limiter
-	}
+// TokenBucketLimiter 本地令牌桶速率限制器，限额只在当前网关实例内生效
+type TokenBucketLimiter struct {
+	cache   cache.Cache
+	window  time.Duration
+	burst   int
+	buckets map[string]*localBucket
+	mutex   sync.Mutex
+	now     func() time.Time
 
-	return limiter.Allow(), nil
+	lastSweep time.Time
 }
 
-// Reset 重置限制器
-func (tbl *TokenBucketLimiter) Reset(ctx context.Context, key string) error {
-	delete(tbl.buckets, key)
-	return nil
+// localBucket 本地令牌桶及其最近一次使用时间
+type localBucket struct {
+	limiter  *rate.Limiter
+	limit    int
+	lastSeen time.Time
 }
 
-// SlidingWindowLimiter 滑动窗口速率限制器
-type SlidingWindowLimiter struct {
-	cache  cache.Cache
-	window time.Duration
+// sweepInterval 清理空闲限流状态的最小间隔
+const sweepInterval = time.Minute
+
+// NewTokenBucketLimiter 创建令牌桶限制器，每秒最多limit个请求，突发容量为limit
+func NewTokenBucketLimiter(cache cache.Cache) *TokenBucketLimiter {
+	return NewLocalTokenBucketLimiter(cache, time.Second, 0)
 }
 
-// NewSlidingWindowLimiter 创建滑动窗口限制器
-func NewSlidingWindowLimiter(cache cache.Cache, window time.Duration) *SlidingWindowLimiter {
-	return &SlidingWindowLimiter{
-		cache:  cache,
-		window: window,
+// NewLocalTokenBucketLimiter 创建令牌桶限制器，每个window最多limit个请求，burst为0时突发容量为limit
+func NewLocalTokenBucketLimiter(cache cache.Cache, window time.Duration, burst int) *TokenBucketLimiter {
+	if window <= 0 {
+		window = time.Second
+	}
+	return &TokenBucketLimiter{
+		cache:     cache,
+		window:    window,
+		burst:     burst,
+		buckets:   make(map[string]*localBucket),
+		now:       time.Now,
+		lastSweep: time.Now(),
 	}
 }
 
+// SetClock 设置获取当前时间的函数
+func (tbl *TokenBucketLimiter) SetClock(now func() time.Time) {
+	tbl.mutex.Lock()
+	defer tbl.mutex.Unlock()
+
+	tbl.now = now
+	tbl.lastSweep = now()
+}
+
 // Allow 检查是否允许请求
-func (swl *SlidingWindowLimiter) Allow(ctx context.Context, key string, limit int) (bool, error) {
-	now := time.Now()
-	windowStart := now.Add(-swl.window)
-	
-	// 使用Redis的ZREMRANGEBYSCORE清理过期记录
-	cacheKey := fmt.Sprintf("rate_limit:%s", key)
-	
-	// 清理过期记录（这里简化实现，实际应使用Redis的有序集合）
-	currentCount, err := swl.getCurrentCount(ctx, cacheKey, windowStart)
-	if err != nil {
-		logger.Errorf("获取当前计数失败: %v", err)
-		return false, err
+func (tbl *TokenBucketLimiter) Allow(ctx context.Context, key string, limit int) (Result, error) {
+	if limit <= 0 {
+		return rejectAll(limit, tbl.window), nil
 	}
 
-	if currentCount >= int64(limit) {
-		return false, nil
-	}
+	tbl.mutex.Lock()
+	defer tbl.mutex.Unlock()
 
-	// 增加计数
-	if err := swl.incrementCount(ctx, cacheKey, now); err != nil {
-		logger.Errorf("增加计数失败: %v", err)
-		return false, err
+	now := tbl.now()
+
+	tbl.sweep(now)
+
+	bucket, exists := tbl.buckets[key]
+	if !exists || bucket.limit != limit {
+		bucket = &localBucket{
+			limiter: rate.NewLimiter(rate.Limit(float64(limit)/tbl.window.Seconds()), burstSize(tbl.burst, limit)),
+			limit:   limit,
+		}
+		tbl.buckets[key] = bucket
 	}
+	bucket.lastSeen = now
 
-	return true, nil
+	result := Result{Limit: limit, Window: tbl.window}
+	tokens := bucket.limiter.TokensAt(now)
+	interval := float64(tbl.window) / float64(limit)
+	if bucket.limiter.AllowN(now, 1) {
+		result.Allowed = true
+		tokens--
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - tokens) * interval))
+	}
+	result.Remaining = int(math.Max(0, math.Floor(tokens)))
+	result.Reset = time.Duration(math.Ceil((float64(bucket.limiter.Burst()) - tokens) * interval))
+	return result, nil
 }
 
-// getCurrentCount 获取当前窗口内的请求计数
-func (swl *SlidingWindowLimiter) getCurrentCount(ctx context.Context, key string, windowStart time.Time) (int64, error) {
-	// 简化实现：使用计数器而不是有序集合
-	// 实际生产环境应该使用Redis的ZCOUNT命令
-	val, err := swl.cache.Get(ctx, key)
-	if err != nil || val == "" {
-		return 0, nil
+// sweep 删除已经补满的空闲令牌桶，补满后的令牌桶与新建的等价，调用方需持有mutex
+func (tbl *TokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(tbl.lastSweep) < sweepInterval {
+		return
 	}
-	
-	// 这里应该解析时间戳列表并计算在窗口内的数量
-	// 简化为直接返回计数
-	count, _ := swl.cache.Incr(ctx, key+"_count")
-	return count, nil
-}
+	tbl.lastSweep = now
 
-// incrementCount 增加计数
-func (swl *SlidingWindowLimiter) incrementCount(ctx context.Context, key string, timestamp time.Time) error {
-	// 简化实现：直接增加计数器
-	_, err := swl.cache.Incr(ctx, key+"_count")
-	if err != nil {
-		return err
+	for key, bucket := range tbl.buckets {
+		refill := time.Duration(float64(tbl.window) * float64(burstSize(tbl.burst, bucket.limit)) / float64(bucket.limit))
+		if now.Sub(bucket.lastSeen) > refill {
+			delete(tbl.buckets, key)
+		}
 	}
-	
-	// 设置过期时间
-	return swl.cache.Expire(ctx, key+"_count", swl.window)
 }
 
 // Reset 重置限制器
-func (swl *SlidingWindowLimiter) Reset(ctx context.Context, key string) error {
-	cacheKey := fmt.Sprintf("rate_limit:%s", key)
-	return swl.cache.Del(ctx, cacheKey, cacheKey+"_count")
+func (tbl *TokenBucketLimiter) Reset(ctx context.Context, key string) error {
+	tbl.mutex.Lock()
+	defer tbl.mutex.Unlock()
+
+	delete(tbl.buckets, key)
+	return nil
+}
+
+// burstSize 突发容量，未配置时与限额相同
+func burstSize(burst, limit int) int {
+	if burst > 0 {
+		return burst
+	}
+	return limit
 }
 
-// FixedWindowLimiter 固定窗口速率限制器
+// FixedWindowLimiter 固定窗口速率限制器，计数保存在缓存中，使用Redis时所有实例共享
 type FixedWindowLimiter struct {
 	cache  cache.Cache
 	window time.Duration
+	now    func() time.Time
 }
 
 // NewFixedWindowLimiter 创建固定窗口限制器
 func NewFixedWindowLimiter(cache cache.Cache, window time.Duration) *FixedWindowLimiter {
+	if window <= 0 {
+		window = time.Second
+	}
 	return &FixedWindowLimiter{
 		cache:  cache,
 		window: window,
+		now:    time.Now,
 	}
 }
 
+// SetClock 设置获取当前时间的函数
+func (fwl *FixedWindowLimiter) SetClock(now func() time.Time) {
+	fwl.now = now
+}
+
 // Allow 检查是否允许请求
-func (fwl *FixedWindowLimiter) Allow(ctx context.Context, key string, limit int) (bool, error) {
-	now := time.Now()
-	windowKey := fmt.Sprintf("rate_limit:%s:%d", key, now.Unix()/int64(fwl.window.Seconds()))
-	
+func (fwl *FixedWindowLimiter) Allow(ctx context.Context, key string, limit int) (Result, error) {
+	now := fwl.now().UnixNano()
+	index := now / int64(fwl.window)
+	windowKey := fwl.windowKey(key, index)
+
 	// 获取当前窗口的计数
 	count, err := fwl.cache.Incr(ctx, windowKey)
 	if err != nil {
 		logger.Errorf("增加计数失败: %v", err)
-		return false, err
+		return Result{}, err
 	}
-	
+
 	// 设置窗口过期时间
 	if count == 1 {
 		if err := fwl.cache.Expire(ctx, windowKey, fwl.window); err != nil {
 			logger.Errorf("设置过期时间失败: %v", err)
 		}
 	}
-	
-	return count <= int64(limit), nil
+
+	result := Result{
+		Allowed: count <= int64(limit),
+		Limit:   limit,
+		Window:  fwl.window,
+		Reset:   time.Duration((index+1)*int64(fwl.window) - now),
+	}
+	if result.Allowed {
+		result.Remaining = limit - int(count)
+	} else {
+		result.RetryAfter = result.Reset
+	}
+	return result, nil
 }
 
-// Reset 重置限制器
+// Reset 重置限制器，更早窗口的计数已经过期，只需删除当前和上一个窗口
 func (fwl *FixedWindowLimiter) Reset(ctx context.Context, key string) error {
-	// 删除所有相关的窗口键（简化实现）
-	pattern := fmt.Sprintf("rate_limit:%s:*", key)
-	// 注意：实际实现中应该使用Redis的SCAN命令来查找并删除匹配的键
-	return fwl.cache.Del(ctx, pattern)
+	index := fwl.windowIndex()
+	return fwl.cache.Del(ctx, fwl.windowKey(key, index), fwl.windowKey(key, index-1))
+}
+
+// windowIndex 当前时间所在窗口的序号
+func (fwl *FixedWindowLimiter) windowIndex() int64 {
+	return fwl.now().UnixNano() / int64(fwl.window)
+}
+
+// windowKey 窗口计数的缓存键
+func (fwl *FixedWindowLimiter) windowKey(key string, index int64) string {
+	return fmt.Sprintf("rate_limit:fixed_window:%s:%d", key, index)
 }
 
 // LimiterConfig 限制器配置
 type LimiterConfig struct {
-	Type     string        `yaml:"type"`     // token_bucket, sliding_window, fixed_window
-	Window   time.Duration `yaml:"window"`   // 窗口大小
-	BurstSize int          `yaml:"burst_size"` // 突发容量
+	Type      string        `yaml:"type"`       // token_bucket, gcra, sliding_window, sliding_window_counter, fixed_window
+	Window    time.Duration `yaml:"window"`     // 窗口大小
+	BurstSize int           `yaml:"burst_size"` // 突发容量
 }
 
 // LimiterManager 限制器管理器
 type LimiterManager struct {
 	limiters map[string]RateLimiter
 	cache    cache.Cache
+	mutex    sync.Mutex
 }
 
 // NewLimiterManager 创建限制器管理器
@@ -188,30 +250,41 @@ func NewLimiterManager(cache cache.Cache) *LimiterManager {
 	}
 }
 
-// GetLimiter 获取限制器
+// GetLimiter 获取限制器，缓存支持脚本时在Redis中计算，所有实例共享限额，否则在本实例内限制。
+// 固定窗口只依赖缓存的Incr，总是使用缓存
 func (lm *LimiterManager) GetLimiter(name string, config LimiterConfig) RateLimiter {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
 	limiter, exists := lm.limiters[name]
 	if exists {
 		return limiter
 	}
 
-	switch config.Type {
-	case "sliding_window":
-		limiter = NewSlidingWindowLimiter(lm.cache, config.Window)
-	case "fixed_window":
+	runner, distributed := lm.cache.(cache.ScriptRunner)
+	switch {
+	case config.Type == "fixed_window":
 		limiter = NewFixedWindowLimiter(lm.cache, config.Window)
+	case distributed:
+		limiter = NewRedisLimiter(runner, config)
 	default:
-		limiter = NewTokenBucketLimiter(lm.cache)
+		limiter = newLocalLimiter(lm.cache, config)
 	}
 
 	lm.limiters[name] = limiter
 	return limiter
 }
 
-// GenerateRateLimitKey 生成速率限制键
-func GenerateRateLimitKey(clientIP, userID, path string) string {
-	if userID != "" {
-		return fmt.Sprintf("user:%s:%s", userID, path)
+// newLocalLimiter 创建只在当前实例内生效的限制器
+func newLocalLimiter(c cache.Cache, config LimiterConfig) RateLimiter {
+	switch config.Type {
+	case "sliding_window":
+		return NewSlidingWindowLimiter(config.Window)
+	case "sliding_window_counter":
+		return NewSlidingWindowCounterLimiter(config.Window)
+	case "fixed_window":
+		return NewFixedWindowLimiter(c, config.Window)
+	default:
+		return NewLocalTokenBucketLimiter(c, config.Window, config.BurstSize)
 	}
-	return fmt.Sprintf("ip:%s:%s", clientIP, path)
 }
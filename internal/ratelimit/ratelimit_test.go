@@ -0,0 +1,471 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"api-gateway/internal/cache"
+	"api-gateway/internal/config"
+	"api-gateway/internal/logger"
+)
+
+func init() {
+	logger.Init(config.LoggingConfig{Level: "error", Format: "text"})
+}
+
+// redisServer 进程内执行Lua脚本的Redis，时间固定，只能通过 Advance 推进
+type redisServer struct {
+	*miniredis.Miniredis
+	now time.Time
+}
+
+// newRedisServer 启动进程内的Redis
+func newRedisServer(t *testing.T) *redisServer {
+	server := &redisServer{Miniredis: miniredis.RunT(t), now: time.Unix(1700000000, 0)}
+	server.SetTime(server.now)
+	return server
+}
+
+// Advance 推进时钟，到期的键被删除
+func (s *redisServer) Advance(d time.Duration) {
+	s.now = s.now.Add(d)
+	s.SetTime(s.now)
+	s.FastForward(d)
+}
+
+// newScriptRunner 创建连接Redis的缓存
+func newScriptRunner(t *testing.T, addr string) cache.ScriptRunner {
+	c, err := cache.NewRedisCache(config.RedisConfig{Addr: addr})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	runner, ok := c.(cache.ScriptRunner)
+	require.True(t, ok)
+	return runner
+}
+
+func TestRedisLimiterSharedAcrossInstances(t *testing.T) {
+	for _, algorithm := range []string{"token_bucket", "gcra"} {
+		t.Run(algorithm, func(t *testing.T) {
+			server := newRedisServer(t)
+			ctx := context.Background()
+			limiterConfig := LimiterConfig{Type: algorithm, Window: time.Second}
+
+			// 两个网关实例各自连接同一个Redis
+			replicas := []*RedisLimiter{
+				NewRedisLimiter(newScriptRunner(t, server.Addr()), limiterConfig),
+				NewRedisLimiter(newScriptRunner(t, server.Addr()), limiterConfig),
+			}
+
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				result, err := replicas[i%2].Allow(ctx, "client", 5)
+				require.NoError(t, err)
+				if result.Allowed {
+					allowed++
+				}
+			}
+			assert.Equal(t, 5, allowed, "限额应由所有实例共享")
+			assert.Positive(t, server.TTL("rate_limit:"+algorithm+":client"), "限制键需要设置过期时间")
+
+			// 其他键不受影响
+			result, err := replicas[0].Allow(ctx, "other", 5)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			// 补充一个令牌
+			server.Advance(200 * time.Millisecond)
+			result, err = replicas[1].Allow(ctx, "client", 5)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			result, err = replicas[0].Allow(ctx, "client", 5)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			// 重置后恢复全部限额
+			require.NoError(t, replicas[0].Reset(ctx, "client"))
+			result, err = replicas[1].Allow(ctx, "client", 5)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+}
+
+func TestRedisLimiterBurst(t *testing.T) {
+	server := newRedisServer(t)
+	ctx := context.Background()
+	limiter := NewRedisLimiter(newScriptRunner(t, server.Addr()), LimiterConfig{
+		Type:      "gcra",
+		Window:    time.Minute,
+		BurstSize: 2,
+	})
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "client", 60)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+	result, err := limiter.Allow(ctx, "client", 60)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "超过突发容量后应按每秒一个请求放行")
+
+	server.Advance(time.Second)
+	result, err = limiter.Allow(ctx, "client", 60)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestRedisLimiterFallback(t *testing.T) {
+	server := newRedisServer(t)
+	ctx := context.Background()
+	limiter := NewRedisLimiter(newScriptRunner(t, server.Addr()), LimiterConfig{Window: time.Minute})
+
+	result, err := limiter.Allow(ctx, "client", 2)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// Redis不可用时使用本地令牌桶，不返回错误
+	server.Close()
+	for i := 0; i < 2; i++ {
+		result, err = limiter.Allow(ctx, "client", 2)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+	result, err = limiter.Allow(ctx, "client", 2)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, limiter.degraded.Load())
+}
+
+func TestLimiterManagerSelection(t *testing.T) {
+	server := newRedisServer(t)
+
+	manager := NewLimiterManager(newScriptRunner(t, server.Addr()))
+	_, ok := manager.GetLimiter("redis", LimiterConfig{Type: "token_bucket"}).(*RedisLimiter)
+	assert.True(t, ok, "缓存支持脚本时应使用Redis限制器")
+	_, ok = manager.GetLimiter("gcra", LimiterConfig{Type: "gcra"}).(*RedisLimiter)
+	assert.True(t, ok)
+
+	manager = NewLimiterManager(cache.NewMemoryCache())
+	_, ok = manager.GetLimiter("local", LimiterConfig{Type: "token_bucket"}).(*TokenBucketLimiter)
+	assert.True(t, ok, "内存缓存时应使用本地令牌桶")
+}
+
+func TestTokenBucketLimiterLimitChange(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLocalTokenBucketLimiter(cache.NewMemoryCache(), time.Minute, 0)
+
+	result, err := limiter.Allow(ctx, "client", 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = limiter.Allow(ctx, "client", 1)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// 限额修改后使用新的令牌桶
+	result, err = limiter.Allow(ctx, "client", 3)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = limiter.Allow(ctx, "client", 0)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+}
+
+// fakeClock 可控的时钟
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+// Now 返回当前时间
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Advance 推进时钟
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// allowN 连续请求n次，返回允许的次数
+func allowN(t *testing.T, limiter RateLimiter, key string, limit, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		result, err := limiter.Allow(context.Background(), key, limit)
+		require.NoError(t, err)
+		if result.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSlidingWindowLimiter(10*time.Second)
+	limiter.SetClock(clock.Now)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, allowN(t, limiter, "client", 3, 1))
+		clock.Advance(time.Second)
+	}
+	// t=3s: 窗口内已有0s、1s、2s三个请求
+	assert.Equal(t, 0, allowN(t, limiter, "client", 3, 1))
+
+	// t=10s: 0s的请求恰好移出窗口
+	clock.Advance(7 * time.Second)
+	assert.Equal(t, 1, allowN(t, limiter, "client", 3, 2))
+
+	// t=10.5s: 窗口内为1s、2s、10s
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, 0, allowN(t, limiter, "client", 3, 1))
+
+	// t=11s: 1s的请求移出窗口
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, 1, allowN(t, limiter, "client", 3, 2))
+
+	// 被拒绝的请求不计入窗口，其他键不受影响
+	assert.Equal(t, 3, allowN(t, limiter, "other", 3, 5))
+
+	require.NoError(t, limiter.Reset(context.Background(), "client"))
+	assert.Equal(t, 3, allowN(t, limiter, "client", 3, 5))
+
+	// 空闲的键在清理时删除
+	clock.Advance(2 * sweepInterval)
+	assert.Equal(t, 1, allowN(t, limiter, "new", 3, 1))
+	assert.Len(t, limiter.logs, 1)
+}
+
+func TestSlidingWindowLimiterNoBoundaryBurst(t *testing.T) {
+	clock := newFakeClock()
+	sliding := NewSlidingWindowLimiter(time.Second)
+	sliding.SetClock(clock.Now)
+	fixed := NewFixedWindowLimiter(cache.NewMemoryCache(), time.Second)
+	fixed.SetClock(clock.Now)
+
+	// 固定窗口结束前和下一个窗口开始时各发送一批请求
+	clock.Advance(900 * time.Millisecond)
+	assert.Equal(t, 5, allowN(t, sliding, "client", 5, 5))
+	assert.Equal(t, 5, allowN(t, fixed, "client", 5, 5))
+
+	clock.Advance(200 * time.Millisecond)
+	assert.Equal(t, 0, allowN(t, sliding, "client", 5, 5), "滑动窗口在任意一秒内最多允许5个请求")
+	assert.Equal(t, 5, allowN(t, fixed, "client", 5, 5), "固定窗口在窗口边界允许双倍请求")
+}
+
+func TestSlidingWindowCounterLimiter(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewSlidingWindowCounterLimiter(10*time.Second)
+	limiter.SetClock(clock.Now)
+
+	// t=5s: 第一个窗口内最多10个请求
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, 10, allowN(t, limiter, "client", 10, 15))
+
+	// t=10s: 上一个窗口完全重叠，估算值为10
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, 0, allowN(t, limiter, "client", 10, 1))
+
+	// t=15s: 上一个窗口重叠一半，估算值为5
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, 5, allowN(t, limiter, "client", 10, 10))
+
+	// t=25s: 上一个窗口的5个请求重叠一半，估算值为2.5
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, 7, allowN(t, limiter, "client", 10, 10))
+
+	// t=40s: 两个窗口都已过期
+	clock.Advance(15 * time.Second)
+	assert.Equal(t, 10, allowN(t, limiter, "client", 10, 15))
+
+	require.NoError(t, limiter.Reset(context.Background(), "client"))
+	assert.Equal(t, 10, allowN(t, limiter, "client", 10, 15))
+
+	clock.Advance(2 * sweepInterval)
+	assert.Equal(t, 1, allowN(t, limiter, "new", 10, 1))
+	assert.Len(t, limiter.counters, 1)
+}
+
+// TestRedisSlidingWindowScripts 在Redis中执行滑动窗口脚本，结果应与本地实现一致
+func TestRedisSlidingWindowScripts(t *testing.T) {
+	steps := []time.Duration{
+		0, 0, 100 * time.Millisecond, 300 * time.Millisecond, 0, 0, 0,
+		600 * time.Millisecond, 50 * time.Millisecond, 0, 400 * time.Millisecond,
+		time.Second, 0, 0, 0, 0, 0, 250 * time.Millisecond, 0, 2 * time.Second, 0,
+	}
+
+	for _, algorithm := range []string{"sliding_window", "sliding_window_counter"} {
+		t.Run(algorithm, func(t *testing.T) {
+			server := newRedisServer(t)
+			clock := &fakeClock{now: server.now}
+			limiterConfig := LimiterConfig{Type: algorithm, Window: time.Second}
+
+			remote := NewRedisLimiter(newScriptRunner(t, server.Addr()), limiterConfig)
+			local := newLocalLimiter(cache.NewMemoryCache(), limiterConfig)
+			local.(interface{ SetClock(func() time.Time) }).SetClock(clock.Now)
+
+			for i, step := range steps {
+				server.Advance(step)
+				clock.Advance(step)
+				want, err := local.Allow(context.Background(), "client", 4)
+				require.NoError(t, err)
+				got, err := remote.Allow(context.Background(), "client", 4)
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "第%d个请求", i)
+			}
+			assert.False(t, remote.degraded.Load())
+		})
+	}
+}
+
+func TestFixedWindowLimiterReset(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewFixedWindowLimiter(cache.NewMemoryCache(), 500*time.Millisecond)
+	limiter.SetClock(clock.Now)
+
+	assert.Equal(t, 2, allowN(t, limiter, "client", 2, 3))
+	require.NoError(t, limiter.Reset(context.Background(), "client"))
+	assert.Equal(t, 2, allowN(t, limiter, "client", 2, 3))
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, 2, allowN(t, limiter, "client", 2, 3))
+}
+
+func TestTokenBucketLimiterSweep(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLocalTokenBucketLimiter(cache.NewMemoryCache(), time.Second, 0)
+	limiter.SetClock(clock.Now)
+
+	assert.Equal(t, 2, allowN(t, limiter, "idle", 2, 3))
+	clock.Advance(2 * sweepInterval)
+	assert.Equal(t, 2, allowN(t, limiter, "active", 2, 3))
+	assert.Len(t, limiter.buckets, 1, "已经补满的空闲令牌桶应被删除")
+}
+
+func TestLimiterResults(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+
+	tokenBucket := NewLocalTokenBucketLimiter(cache.NewMemoryCache(), 10*time.Second, 0)
+	tokenBucket.SetClock(clock.Now)
+	result, err := tokenBucket.Allow(ctx, "client", 5)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Limit: 5, Remaining: 4, Window: 10 * time.Second, Reset: 2 * time.Second}, result)
+	allowN(t, tokenBucket, "client", 5, 4)
+	result, err = tokenBucket.Allow(ctx, "client", 5)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Limit: 5, Window: 10 * time.Second, Reset: 10 * time.Second, RetryAfter: 2 * time.Second}, result)
+
+	fixed := NewFixedWindowLimiter(cache.NewMemoryCache(), 10*time.Second)
+	fixed.SetClock(clock.Now)
+	clock.Advance(4 * time.Second)
+	result, err = fixed.Allow(ctx, "client", 1)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Limit: 1, Window: 10 * time.Second, Reset: 6 * time.Second}, result)
+	result, err = fixed.Allow(ctx, "client", 1)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Limit: 1, Window: 10 * time.Second, Reset: 6 * time.Second, RetryAfter: 6 * time.Second}, result)
+
+	sliding := NewSlidingWindowLimiter(10*time.Second)
+	sliding.SetClock(clock.Now)
+	allowN(t, sliding, "client", 2, 1)
+	clock.Advance(3 * time.Second)
+	result, err = sliding.Allow(ctx, "client", 2)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Window: 10 * time.Second, Reset: 7 * time.Second}, result)
+	result, err = sliding.Allow(ctx, "client", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 7*time.Second, result.RetryAfter)
+}
+
+func TestSetHeaders(t *testing.T) {
+	header := http.Header{}
+	SetHeaders(header, Result{Allowed: true, Limit: 100, Remaining: 42, Window: time.Minute, Reset: 1500 * time.Millisecond})
+	assert.Equal(t, "100", header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "42", header.Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", header.Get("X-RateLimit-Reset"))
+	assert.Equal(t, `"default";q=100;w=60`, header.Get("RateLimit-Policy"))
+	assert.Equal(t, `"default";r=42;t=2`, header.Get("RateLimit"))
+	assert.Empty(t, header.Get("Retry-After"))
+
+	header = http.Header{}
+	SetHeaders(header, Result{Policy: "gold", Limit: 10, Window: time.Second, Reset: 300 * time.Millisecond, RetryAfter: 100 * time.Millisecond})
+	assert.Equal(t, `"gold";r=0;t=1`, header.Get("RateLimit"))
+	assert.Equal(t, "1", header.Get("Retry-After"), "Retry-After至少为1秒")
+
+	// 多个策略全部列出，X-RateLimit-*取剩余最少的策略
+	header = http.Header{}
+	SetHeaders(header,
+		Result{Policy: "user", Allowed: true, Limit: 10, Remaining: 9, Window: time.Second, Reset: time.Second},
+		Result{Policy: "tenant", Allowed: true, Limit: 1000, Remaining: 3, Window: time.Minute, Reset: 30 * time.Second},
+	)
+	assert.Equal(t, `"user";q=10;w=1, "tenant";q=1000;w=60`, header.Get("RateLimit-Policy"))
+	assert.Equal(t, `"user";r=9;t=1, "tenant";r=3;t=30`, header.Get("RateLimit"))
+	assert.Equal(t, "1000", header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "3", header.Get("X-RateLimit-Remaining"))
+}
+
+func TestKeyTemplate(t *testing.T) {
+	claims := map[string]string{"org_id": "acme"}
+	req := KeyRequest{
+		Route:    "/api/v1/users",
+		Method:   "GET",
+		ClientIP: "192.0.2.77",
+		UserID:   "42",
+		APIKeyID: "key-1",
+		Header:   http.Header{"X-Tenant": []string{"blue|green"}},
+		Claim:    func(path string) string { return claims[path] },
+	}
+
+	build := func(parts ...string) (string, bool) {
+		template, err := NewKeyTemplate(parts)
+		require.NoError(t, err)
+		return template.Build(req)
+	}
+
+	key, ok := build()
+	assert.True(t, ok)
+	assert.Equal(t, "route=%2Fapi%2Fv1%2Fusers|user=42", key)
+
+	key, _ = build("ip_prefix:/24", "method")
+	assert.Equal(t, "ip_prefix=192.0.2.0%2F24|method=GET", key)
+	key, _ = build("header:X-Tenant", "claim:org_id", "api_key")
+	assert.Equal(t, "header:x-tenant=blue%7Cgreen|claim:org_id=acme|api_key=key-1", key)
+
+	// IPv6使用单独的前缀长度
+	req.ClientIP = "2001:db8:1:2::5"
+	key, _ = build("ip_prefix:/24,/48")
+	assert.Equal(t, "ip_prefix=2001%3Adb8%3A1%3A%3A%2F48", key)
+	key, _ = build("ip_prefix:/24")
+	assert.Equal(t, "ip_prefix=2001%3Adb8%3A1%3A2%3A%3A%2F64", key)
+
+	// 未认证时user使用客户端IP，缺少的部分使限制不生效
+	req.UserID, req.APIKeyID, req.Claim = "", "", nil
+	key, ok = build("user")
+	assert.True(t, ok)
+	assert.Equal(t, "user=ip%3A2001%3Adb8%3A1%3A2%3A%3A5", key)
+	_, ok = build("route", "api_key")
+	assert.False(t, ok)
+	_, ok = build("claim:org_id")
+	assert.False(t, ok)
+	_, ok = build("header:X-Missing")
+	assert.False(t, ok)
+
+	_, err := NewKeyTemplate([]string{"cookie:session"})
+	assert.Error(t, err)
+}
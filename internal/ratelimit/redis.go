@@ -0,0 +1,253 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"api-gateway/internal/cache"
+	"api-gateway/internal/logger"
+)
+
+const (
+	// redisTimeout 单次Redis限流调用的超时时间，超时后使用本地限制
+	redisTimeout = 100 * time.Millisecond
+	// redisRetryInterval Redis不可用期间重新尝试Redis的间隔
+	redisRetryInterval = time.Second
+)
+
+// tokenBucketScript 令牌桶算法，时间使用Redis服务器时间（微秒），避免各实例的时钟偏差。
+// KEYS[1] 令牌桶键；ARGV[1] 限额；ARGV[2] 窗口（微秒）；ARGV[3] 突发容量。
+// 返回 {是否允许, 剩余令牌数, 需要等待的微秒数, 补满的微秒数}
+var tokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local rate = limit / window
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate / 1000) + 1000)
+return {allowed, math.floor(tokens), wait, math.ceil((burst - tokens) / rate)}
+`)
+
+// gcraScript 通用信元速率算法（GCRA），只保存理论到达时间（TAT），时间单位为微秒。
+// KEYS[1] 限制键；ARGV[1] 限额；ARGV[2] 窗口（微秒）；ARGV[3] 突发容量。
+// 返回 {是否允许, 剩余请求数, 需要等待的微秒数, 补满的微秒数}
+var gcraScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local interval = window / limit
+local tolerance = interval * burst
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - tolerance
+if now < allow_at then
+  return {0, 0, math.ceil(allow_at - now), math.ceil(tat - now)}
+end
+
+redis.call('SET', KEYS[1], new_tat, 'PX', math.ceil((new_tat - now) / 1000) + 1)
+return {1, math.floor((tolerance - (new_tat - now)) / interval), 0, math.ceil(new_tat - now)}
+`)
+
+// slidingWindowLogScript 滑动窗口日志，有序集合中保存窗口内每个请求的时间（微秒）。
+// KEYS[1] 日志键；ARGV[1] 限额；ARGV[2] 窗口（微秒）。
+// 返回 {是否允许, 剩余请求数, 需要等待的微秒数, 下一个名额释放的微秒数}
+var slidingWindowLogScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+  local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+  local wait = math.ceil(tonumber(oldest[2]) + window - now)
+  return {0, 0, wait, wait}
+end
+
+-- 同一微秒内的请求用当前数量区分，保证成员唯一
+redis.call('ZADD', KEYS[1], now, time[1] .. '.' .. time[2] .. ':' .. count)
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {1, limit - count - 1, 0, math.ceil(tonumber(oldest[2]) + window - now)}
+`)
+
+// slidingWindowCounterScript 滑动窗口计数器，只保存当前和上一个固定窗口的计数，
+// 按上一个窗口与滑动窗口重叠的比例估算请求数。
+// KEYS[1] 计数键；ARGV[1] 限额；ARGV[2] 窗口（微秒）。
+// 返回 {是否允许, 剩余请求数, 需要等待的微秒数, 当前窗口结束的微秒数}
+var slidingWindowCounterScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local index = math.floor(now / window)
+local elapsed = now - index * window
+
+local state = redis.call('HMGET', KEYS[1], 'index', 'previous', 'current')
+local last = tonumber(state[1])
+local previous = tonumber(state[2]) or 0
+local current = tonumber(state[3]) or 0
+if last == nil or index > last + 1 then
+  previous = 0
+  current = 0
+elseif index == last + 1 then
+  previous = current
+  current = 0
+end
+
+local estimate = previous * (window - elapsed) / window + current
+if estimate + 1 > limit then
+  local wait = window - elapsed
+  if previous > 0 and current + 1 <= limit then
+    wait = math.min(wait, math.ceil((estimate + 1 - limit) * window / previous))
+  end
+  return {0, 0, wait, window - elapsed}
+end
+
+redis.call('HSET', KEYS[1], 'index', index, 'previous', previous, 'current', current + 1)
+redis.call('PEXPIRE', KEYS[1], math.ceil(window * 2 / 1000))
+return {1, math.floor(limit - estimate - 1), 0, window - elapsed}
+`)
+
+// redisScripts 各算法在Redis中执行的脚本
+var redisScripts = map[string]*redis.Script{
+	"token_bucket":           tokenBucketScript,
+	"gcra":                   gcraScript,
+	"sliding_window":         slidingWindowLogScript,
+	"sliding_window_counter": slidingWindowCounterScript,
+}
+
+// RedisLimiter 在Redis中用Lua脚本原子计算的分布式速率限制器，所有网关实例共享同一个限额。
+// Redis不可用时退化为同一算法的本地实现并记录警告，Redis恢复后自动切回。
+type RedisLimiter struct {
+	cache    cache.ScriptRunner
+	script   *redis.Script
+	prefix   string
+	window   time.Duration
+	burst    int
+	fallback RateLimiter
+
+	degraded atomic.Bool
+	retryAt  atomic.Int64
+}
+
+// NewRedisLimiter 创建Redis分布式限制器，config.Type为空或不支持时使用令牌桶
+func NewRedisLimiter(c cache.ScriptRunner, config LimiterConfig) *RedisLimiter {
+	if _, exists := redisScripts[config.Type]; !exists {
+		config.Type = "token_bucket"
+	}
+	if config.Window <= 0 {
+		config.Window = time.Second
+	}
+
+	return &RedisLimiter{
+		cache:    c,
+		script:   redisScripts[config.Type],
+		prefix:   "rate_limit:" + config.Type + ":",
+		window:   config.Window,
+		burst:    config.BurstSize,
+		fallback: newLocalLimiter(c, config),
+	}
+}
+
+// Allow 检查是否允许请求
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit int) (Result, error) {
+	if limit <= 0 {
+		return rejectAll(limit, l.window), nil
+	}
+
+	// Redis不可用期间每隔一段时间才重新尝试，避免每个请求都等待超时
+	if l.degraded.Load() && time.Now().UnixNano() < l.retryAt.Load() {
+		return l.fallback.Allow(ctx, key, limit)
+	}
+
+	result, err := l.take(ctx, key, limit)
+	if err != nil {
+		l.retryAt.Store(time.Now().Add(redisRetryInterval).UnixNano())
+		if l.degraded.CompareAndSwap(false, true) {
+			logger.Warnf("Redis速率限制不可用，暂时使用本地限制，限额只在当前实例内生效: %v", err)
+		}
+		return l.fallback.Allow(ctx, key, limit)
+	}
+	if l.degraded.CompareAndSwap(true, false) {
+		logger.Info("Redis速率限制已恢复")
+	}
+	return result, nil
+}
+
+// take 在Redis中执行限流脚本
+func (l *RedisLimiter) take(ctx context.Context, key string, limit int) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	reply, err := l.cache.RunScript(ctx, l.script, []string{l.prefix + key},
+		limit, l.window.Microseconds(), burstSize(l.burst, limit))
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 4 {
+		return Result{}, fmt.Errorf("无效的限流脚本返回值: %v", reply)
+	}
+	numbers := make([]int64, len(values))
+	for i, value := range values {
+		if numbers[i], ok = value.(int64); !ok {
+			return Result{}, fmt.Errorf("无效的限流脚本返回值: %v", reply)
+		}
+	}
+
+	return Result{
+		Allowed:    numbers[0] == 1,
+		Limit:      limit,
+		Remaining:  int(numbers[1]),
+		Window:     l.window,
+		RetryAfter: time.Duration(numbers[2]) * time.Microsecond,
+		Reset:      time.Duration(numbers[3]) * time.Microsecond,
+	}, nil
+}
+
+// Reset 重置限制器
+func (l *RedisLimiter) Reset(ctx context.Context, key string) error {
+	if err := l.fallback.Reset(ctx, key); err != nil {
+		return err
+	}
+	return l.cache.Del(ctx, l.prefix+key)
+}
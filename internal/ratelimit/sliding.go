@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimiter 本地滑动窗口日志限制器，记录窗口内每个请求的时间，
+// 任意长度为window的时间段内最多允许limit个请求。与slidingWindowLogScript的Redis实现等价
+type SlidingWindowLimiter struct {
+	window time.Duration
+	logs   map[string][]time.Time
+	mutex  sync.Mutex
+	now    func() time.Time
+
+	lastSweep time.Time
+}
+
+// NewSlidingWindowLimiter 创建滑动窗口日志限制器
+func NewSlidingWindowLimiter(window time.Duration) *SlidingWindowLimiter {
+	if window <= 0 {
+		window = time.Second
+	}
+	return &SlidingWindowLimiter{
+		window:    window,
+		logs:      make(map[string][]time.Time),
+		now:       time.Now,
+		lastSweep: time.Now(),
+	}
+}
+
+// SetClock 设置获取当前时间的函数
+func (swl *SlidingWindowLimiter) SetClock(now func() time.Time) {
+	swl.mutex.Lock()
+	defer swl.mutex.Unlock()
+
+	swl.now = now
+	swl.lastSweep = now()
+}
+
+// Allow 检查是否允许请求
+func (swl *SlidingWindowLimiter) Allow(ctx context.Context, key string, limit int) (Result, error) {
+	if limit <= 0 {
+		return rejectAll(limit, swl.window), nil
+	}
+
+	swl.mutex.Lock()
+	defer swl.mutex.Unlock()
+
+	now := swl.now()
+	swl.sweep(now)
+
+	result := Result{Limit: limit, Window: swl.window}
+	log := swl.prune(swl.logs[key], now)
+	if len(log) < limit {
+		log = append(log, now)
+		result.Allowed = true
+		result.Remaining = limit - len(log)
+	}
+	swl.logs[key] = log
+
+	// 最早的请求移出窗口后释放一个名额
+	result.Reset = log[0].Add(swl.window).Sub(now)
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+	return result, nil
+}
+
+// prune 删除已经移出窗口的请求时间，与ZREMRANGEBYSCORE相同，恰好在窗口起点的记录也会被删除
+func (swl *SlidingWindowLimiter) prune(log []time.Time, now time.Time) []time.Time {
+	windowStart := now.Add(-swl.window)
+	expired := sort.Search(len(log), func(i int) bool {
+		return log[i].After(windowStart)
+	})
+	return log[expired:]
+}
+
+// sweep 删除窗口内已经没有请求的键，调用方需持有mutex
+func (swl *SlidingWindowLimiter) sweep(now time.Time) {
+	if now.Sub(swl.lastSweep) < sweepInterval {
+		return
+	}
+	swl.lastSweep = now
+
+	for key, log := range swl.logs {
+		if len(swl.prune(log, now)) == 0 {
+			delete(swl.logs, key)
+		}
+	}
+}
+
+// Reset 重置限制器
+func (swl *SlidingWindowLimiter) Reset(ctx context.Context, key string) error {
+	swl.mutex.Lock()
+	defer swl.mutex.Unlock()
+
+	delete(swl.logs, key)
+	return nil
+}
+
+// SlidingWindowCounterLimiter 本地滑动窗口计数器限制器，只保存当前和上一个固定窗口的计数，
+// 按上一个窗口与滑动窗口重叠的比例估算请求数。与slidingWindowCounterScript的Redis实现等价
+type SlidingWindowCounterLimiter struct {
+	window   time.Duration
+	counters map[string]*windowCounter
+	mutex    sync.Mutex
+	now      func() time.Time
+
+	lastSweep time.Time
+}
+
+// windowCounter 当前窗口的序号及当前和上一个窗口的请求数
+type windowCounter struct {
+	index    int64
+	previous int
+	current  int
+}
+
+// NewSlidingWindowCounterLimiter 创建滑动窗口计数器限制器
+func NewSlidingWindowCounterLimiter(window time.Duration) *SlidingWindowCounterLimiter {
+	if window <= 0 {
+		window = time.Second
+	}
+	return &SlidingWindowCounterLimiter{
+		window:    window,
+		counters:  make(map[string]*windowCounter),
+		now:       time.Now,
+		lastSweep: time.Now(),
+	}
+}
+
+// SetClock 设置获取当前时间的函数
+func (swc *SlidingWindowCounterLimiter) SetClock(now func() time.Time) {
+	swc.mutex.Lock()
+	defer swc.mutex.Unlock()
+
+	swc.now = now
+	swc.lastSweep = now()
+}
+
+// Allow 检查是否允许请求
+func (swc *SlidingWindowCounterLimiter) Allow(ctx context.Context, key string, limit int) (Result, error) {
+	if limit <= 0 {
+		return rejectAll(limit, swc.window), nil
+	}
+
+	swc.mutex.Lock()
+	defer swc.mutex.Unlock()
+
+	now := swc.now()
+	swc.sweep(now)
+
+	index := now.UnixNano() / int64(swc.window)
+	elapsed := now.UnixNano() - index*int64(swc.window)
+
+	counter, exists := swc.counters[key]
+	switch {
+	case !exists || index > counter.index+1:
+		counter = &windowCounter{index: index}
+		swc.counters[key] = counter
+	case index == counter.index+1:
+		counter.index = index
+		counter.previous = counter.current
+		counter.current = 0
+	}
+
+	window := float64(swc.window)
+	untilEnd := window - float64(elapsed)
+	estimate := float64(counter.previous)*untilEnd/window + float64(counter.current)
+	result := Result{Limit: limit, Window: swc.window, Reset: time.Duration(untilEnd)}
+	if estimate+1 > float64(limit) {
+		// 等待上一个窗口的权重下降到足以放行一个请求，或者等到下一个窗口
+		wait := untilEnd
+		if counter.previous > 0 && counter.current+1 <= limit {
+			wait = math.Min(wait, math.Ceil((estimate+1-float64(limit))*window/float64(counter.previous)))
+		}
+		result.RetryAfter = time.Duration(wait)
+		return result, nil
+	}
+	counter.current++
+	result.Allowed = true
+	result.Remaining = int(math.Floor(float64(limit) - estimate - 1))
+	return result, nil
+}
+
+// sweep 删除两个窗口内都没有请求的键，调用方需持有mutex
+func (swc *SlidingWindowCounterLimiter) sweep(now time.Time) {
+	if now.Sub(swc.lastSweep) < sweepInterval {
+		return
+	}
+	swc.lastSweep = now
+
+	index := now.UnixNano() / int64(swc.window)
+	for key, counter := range swc.counters {
+		if counter.index+1 < index {
+			delete(swc.counters, key)
+		}
+	}
+}
+
+// Reset 重置限制器
+func (swc *SlidingWindowCounterLimiter) Reset(ctx context.Context, key string) error {
+	swc.mutex.Lock()
+	defer swc.mutex.Unlock()
+
+	delete(swc.counters, key)
+	return nil
+}